@@ -3,6 +3,7 @@ package repositories
 import (
 	"github.com/Ilimm9/CMedicas/models"
 	"github.com/Ilimm9/CMedicas/initializers"
+	"gorm.io/gorm"
 )
 
 // Buscar un usuario por su ID
@@ -36,13 +37,16 @@ func ExisteCorreo(correo string) bool {
 
 
 func ActualizarUsuario(id uint, usuario *models.Usuario) error {
-	var existente models.Usuario
-	if err := initializers.GetDB().First(&existente, id).Error; err != nil {
-		return err
+	// Solo los datos de cuenta: un Save reescribiría la versión de sesión, la
+	// contraseña o el estado TOTP si cambiaron desde que se leyó el usuario
+	result := initializers.GetDB().Model(&models.Usuario{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"correo":     usuario.Correo,
+		"persona_id": usuario.PersonaID,
+	})
+	if result.Error == nil && result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
 	}
-
-	usuario.ID = existente.ID
-	return initializers.GetDB().Save(usuario).Error
+	return result.Error
 }
 
 
@@ -0,0 +1,121 @@
+package agenda
+
+import (
+	"sort"
+	"time"
+
+	"github.com/Ilimm9/CMedicas/calendario"
+	"github.com/Ilimm9/CMedicas/mensajeria"
+	"github.com/Ilimm9/CMedicas/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Nombres de Horario.DiaSemana indexados por time.Weekday
+var diasSemana = [...]string{"Domingo", "Lunes", "Martes", "Miércoles", "Jueves", "Viernes", "Sábado"}
+
+// Inicios de cita libres del médico entre desde (inclusive) y hasta. Los
+// espacios salen de sus horarios, en la zona de la clínica, cortados en
+// intervalos de calendario.DuracionCita(); se omiten los pasados y los que se
+// traslapan con una cita no cancelada.
+func Disponibilidad(db *gorm.DB, medicoID uint, desde, hasta time.Time) ([]time.Time, error) {
+	zona := mensajeria.ZonaClinica()
+	duracion := calendario.DuracionCita()
+	if ahora := time.Now(); desde.Before(ahora) {
+		desde = ahora
+	}
+	if !hasta.After(desde) {
+		return nil, nil
+	}
+
+	var horarios []models.Horario
+	if err := db.Where("medico_id = ?", medicoID).Find(&horarios).Error; err != nil {
+		return nil, err
+	}
+	var ocupadas []time.Time
+	if err := db.Model(&models.Cita{}).
+		Where("medico_id = ? AND estado <> ? AND fecha_cita > ? AND fecha_cita < ?",
+			medicoID, "cancelada", desde.Add(-duracion), hasta).
+		Pluck("fecha_cita", &ocupadas).Error; err != nil {
+		return nil, err
+	}
+
+	return espaciosLibres(horarios, ocupadas, desde, hasta, zona, duracion), nil
+}
+
+// Cálculo de Disponibilidad sobre los horarios y las citas ya cargados
+func espaciosLibres(horarios []models.Horario, ocupadas []time.Time, desde, hasta time.Time, zona *time.Location, duracion time.Duration) []time.Time {
+	libre := func(inicio time.Time) bool {
+		for _, o := range ocupadas {
+			if inicio.Before(o.Add(duracion)) && o.Before(inicio.Add(duracion)) {
+				return false
+			}
+		}
+		return true
+	}
+
+	var espacios []time.Time
+	dia := time.Date(desde.In(zona).Year(), desde.In(zona).Month(), desde.In(zona).Day(), 0, 0, 0, 0, zona)
+	for ; dia.Before(hasta); dia = dia.AddDate(0, 0, 1) {
+		nombre := diasSemana[dia.Weekday()]
+		for _, h := range horarios {
+			if h.DiaSemana != nombre {
+				continue
+			}
+			hi, hf := h.HoraInicio.In(zona), h.HoraFin.In(zona)
+			inicio := time.Date(dia.Year(), dia.Month(), dia.Day(), hi.Hour(), hi.Minute(), 0, 0, zona)
+			fin := time.Date(dia.Year(), dia.Month(), dia.Day(), hf.Hour(), hf.Minute(), 0, 0, zona)
+			for t := inicio; !t.Add(duracion).After(fin); t = t.Add(duracion) {
+				if !t.Before(desde) && t.Before(hasta) && libre(t) {
+					espacios = append(espacios, t)
+				}
+			}
+		}
+	}
+
+	// Dos horarios del mismo día pueden traslaparse
+	sort.Slice(espacios, func(i, j int) bool { return espacios[i].Before(espacios[j]) })
+	unicos := espacios[:0]
+	for i, e := range espacios {
+		if i == 0 || !e.Equal(espacios[i-1]) {
+			unicos = append(unicos, e)
+		}
+	}
+	return unicos
+}
+
+// La fecha es el inicio de un espacio libre del médico. Para que dos reservas
+// simultáneas no tomen el mismo espacio, llamarla en la transacción que crea
+// la cita y con el médico bloqueado.
+func EspacioLibre(db *gorm.DB, medicoID uint, fecha time.Time) (bool, error) {
+	espacios, err := Disponibilidad(db, medicoID, fecha, fecha.Add(time.Second))
+	if err != nil {
+		return false, err
+	}
+	for _, e := range espacios {
+		if e.Equal(fecha) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Bloquea al médico hasta el fin de la transacción. Todo lo que agenda o mueve
+// citas lo toma antes de revisar traslapes, así dos reservas simultáneas no
+// ocupan el mismo espacio.
+func BloquearMedico(tx *gorm.DB, medicoID uint) error {
+	return tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&models.Medico{}, medicoID).Error
+}
+
+// La cita de fecha se traslapa con otra no cancelada del médico (sin contar
+// excluirCitaID, la que se está moviendo)
+func Traslapada(db *gorm.DB, medicoID uint, fecha time.Time, excluirCitaID uint) (bool, error) {
+	duracion := calendario.DuracionCita()
+	var citas int64
+	err := db.Model(&models.Cita{}).
+		Where("medico_id = ? AND estado <> ? AND id <> ? AND fecha_cita > ? AND fecha_cita < ?",
+			medicoID, "cancelada", excluirCitaID, fecha.Add(-duracion), fecha.Add(duracion)).
+		Count(&citas).Error
+	return citas > 0, err
+}
@@ -0,0 +1,92 @@
+package agenda
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Ilimm9/CMedicas/models"
+)
+
+func TestEspaciosLibres(t *testing.T) {
+	zona := time.FixedZone("CST", -6*3600)
+	hora := func(h, m int) time.Time { return time.Date(2000, 1, 1, h, m, 0, 0, zona) }
+	// 2024-01-01 fue lunes
+	lunes := func(h, m int) time.Time { return time.Date(2024, 1, 1, h, m, 0, 0, zona) }
+	bloque := func(dia string, hi, mi, hf, mf int) models.Horario {
+		return models.Horario{DiaSemana: dia, HoraInicio: hora(hi, mi), HoraFin: hora(hf, mf)}
+	}
+	duracion := 30 * time.Minute
+
+	casos := []struct {
+		nombre   string
+		horarios []models.Horario
+		ocupadas []time.Time
+		desde    time.Time
+		hasta    time.Time
+		espacios []time.Time
+	}{
+		{
+			nombre:   "bloque completo",
+			horarios: []models.Horario{bloque("Lunes", 9, 0, 10, 0)},
+			desde:    lunes(0, 0), hasta: lunes(23, 59),
+			espacios: []time.Time{lunes(9, 0), lunes(9, 30)},
+		},
+		{
+			nombre:   "el último espacio debe caber en el bloque",
+			horarios: []models.Horario{bloque("Lunes", 9, 0, 10, 15)},
+			desde:    lunes(0, 0), hasta: lunes(23, 59),
+			espacios: []time.Time{lunes(9, 0), lunes(9, 30)},
+		},
+		{
+			nombre:   "una cita ocupa su espacio",
+			horarios: []models.Horario{bloque("Lunes", 9, 0, 10, 0)},
+			ocupadas: []time.Time{lunes(9, 30)},
+			desde:    lunes(0, 0), hasta: lunes(23, 59),
+			espacios: []time.Time{lunes(9, 0)},
+		},
+		{
+			nombre:   "una cita desfasada ocupa los dos espacios que toca",
+			horarios: []models.Horario{bloque("Lunes", 9, 0, 10, 0)},
+			ocupadas: []time.Time{lunes(9, 15)},
+			desde:    lunes(0, 0), hasta: lunes(23, 59),
+			espacios: nil,
+		},
+		{
+			nombre:   "se omiten los anteriores a desde",
+			horarios: []models.Horario{bloque("Lunes", 9, 0, 10, 0)},
+			desde:    lunes(9, 10), hasta: lunes(23, 59),
+			espacios: []time.Time{lunes(9, 30)},
+		},
+		{
+			nombre:   "horarios traslapados no repiten espacios",
+			horarios: []models.Horario{bloque("Lunes", 9, 0, 10, 0), bloque("Lunes", 9, 30, 10, 30)},
+			desde:    lunes(0, 0), hasta: lunes(23, 59),
+			espacios: []time.Time{lunes(9, 0), lunes(9, 30), lunes(10, 0)},
+		},
+		{
+			nombre:   "solo los días del horario",
+			horarios: []models.Horario{bloque("Martes", 9, 0, 10, 0)},
+			desde:    lunes(0, 0), hasta: lunes(23, 59),
+			espacios: nil,
+		},
+		{
+			nombre:   "varias semanas",
+			horarios: []models.Horario{bloque("Lunes", 9, 0, 9, 30)},
+			desde:    lunes(0, 0), hasta: lunes(0, 0).AddDate(0, 0, 8),
+			espacios: []time.Time{lunes(9, 0), lunes(9, 0).AddDate(0, 0, 7)},
+		},
+	}
+
+	for _, c := range casos {
+		t.Run(c.nombre, func(t *testing.T) {
+			espacios := espaciosLibres(c.horarios, c.ocupadas, c.desde, c.hasta, zona, duracion)
+			if len(espacios) == 0 && len(c.espacios) == 0 {
+				return
+			}
+			if !reflect.DeepEqual(espacios, c.espacios) {
+				t.Errorf("espaciosLibres = %v, se esperaba %v", espacios, c.espacios)
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package almacenamiento
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// Almacén de archivos (adjuntos clínicos). Las claves son rutas relativas
+// con "/" como separador; guardar sobre una clave existente la reemplaza.
+type Almacen interface {
+	Nombre() string
+	Guardar(ctx context.Context, clave string, contenido []byte, tipo string) error
+	Abrir(ctx context.Context, clave string) ([]byte, error)
+}
+
+var ErrNoExiste = errors.New("archivo no encontrado en el almacén")
+
+var (
+	almacen     Almacen
+	almacenErr  error
+	almacenOnce sync.Once
+)
+
+// Almacén elegido con ALMACEN_TIPO: "local" (por omisión, en ALMACEN_DIR) o
+// "s3" para un servicio compatible con S3 (S3_BUCKET, S3_REGION, S3_ENDPOINT,
+// S3_ACCESS_KEY, S3_SECRET_KEY)
+func Configurado() (Almacen, error) {
+	almacenOnce.Do(func() {
+		switch tipo := os.Getenv("ALMACEN_TIPO"); tipo {
+		case "", "local":
+			dir := os.Getenv("ALMACEN_DIR")
+			if dir == "" {
+				dir = "adjuntos"
+			}
+			almacen = Local{Dir: dir}
+		case "s3":
+			almacen, almacenErr = nuevoS3(
+				os.Getenv("S3_ENDPOINT"),
+				os.Getenv("S3_REGION"),
+				os.Getenv("S3_BUCKET"),
+				os.Getenv("S3_ACCESS_KEY"),
+				os.Getenv("S3_SECRET_KEY"),
+			)
+		default:
+			almacenErr = fmt.Errorf("ALMACEN_TIPO desconocido: %s", tipo)
+		}
+	})
+	return almacen, almacenErr
+}
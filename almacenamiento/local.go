@@ -0,0 +1,74 @@
+package almacenamiento
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Archivos en un directorio del servidor. Para varias réplicas el directorio
+// debe ser compartido (NFS, volumen común) o usar S3.
+type Local struct {
+	Dir string
+}
+
+func (Local) Nombre() string { return "local" }
+
+// Ruta dentro de Dir; una clave no puede salir de él
+func (l Local) ruta(clave string) (string, error) {
+	ruta := filepath.Join(l.Dir, filepath.FromSlash(clave))
+	relativa, err := filepath.Rel(l.Dir, ruta)
+	if err != nil || relativa == "." || relativa == ".." || strings.HasPrefix(relativa, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("clave inválida: %q", clave)
+	}
+	return ruta, nil
+}
+
+// Escribe en un temporal y lo renombra para que nunca quede un archivo a medias
+func (l Local) Guardar(ctx context.Context, clave string, contenido []byte, tipo string) error {
+	ruta, err := l.ruta(clave)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(ruta), 0o750); err != nil {
+		return err
+	}
+
+	temporal, err := os.CreateTemp(filepath.Dir(ruta), ".subiendo-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temporal.Name()) // sin efecto tras el rename
+
+	if _, err := temporal.Write(contenido); err != nil {
+		temporal.Close()
+		return err
+	}
+	if err := temporal.Sync(); err != nil {
+		temporal.Close()
+		return err
+	}
+	if err := temporal.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(temporal.Name(), 0o640); err != nil {
+		return err
+	}
+	return os.Rename(temporal.Name(), ruta)
+}
+
+func (l Local) Abrir(ctx context.Context, clave string) ([]byte, error) {
+	ruta, err := l.ruta(clave)
+	if err != nil {
+		return nil, err
+	}
+	contenido, err := os.ReadFile(ruta)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNoExiste
+	}
+	return contenido, err
+}
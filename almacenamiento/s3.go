@@ -0,0 +1,159 @@
+package almacenamiento
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Bucket de un servicio compatible con S3 (AWS, MinIO, R2...). Las peticiones
+// se firman con AWS Signature V4. Con S3_ENDPOINT se usan rutas
+// <endpoint>/<bucket>/<clave>, que es lo que aceptan los servicios compatibles;
+// sin él, el host virtual de AWS para la región.
+type S3 struct {
+	endpoint  string
+	region    string
+	accessKey string
+	secretKey string
+	cliente   *http.Client
+}
+
+func nuevoS3(endpoint, region, bucket, accessKey, secretKey string) (*S3, error) {
+	if bucket == "" || accessKey == "" || secretKey == "" {
+		return nil, errors.New("faltan S3_BUCKET, S3_ACCESS_KEY o S3_SECRET_KEY")
+	}
+	if region == "" {
+		region = "us-east-1"
+	}
+	if endpoint == "" {
+		endpoint = "https://" + bucket + ".s3." + region + ".amazonaws.com"
+	} else {
+		endpoint = strings.TrimSuffix(endpoint, "/") + "/" + bucket
+	}
+	return &S3{
+		endpoint:  endpoint,
+		region:    region,
+		accessKey: accessKey,
+		secretKey: secretKey,
+		cliente:   &http.Client{Timeout: 60 * time.Second},
+	}, nil
+}
+
+func (*S3) Nombre() string { return "s3" }
+
+func (s *S3) Guardar(ctx context.Context, clave string, contenido []byte, tipo string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, s.endpoint+"/"+codificarRuta(clave), bytes.NewReader(contenido))
+	if err != nil {
+		return err
+	}
+	if tipo != "" {
+		req.Header.Set("Content-Type", tipo)
+	}
+	s.firmar(req, contenido, time.Now())
+
+	resp, err := s.cliente.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		detalle, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("S3 respondió %d: %s", resp.StatusCode, detalle)
+	}
+	return nil
+}
+
+func (s *S3) Abrir(ctx context.Context, clave string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.endpoint+"/"+codificarRuta(clave), nil)
+	if err != nil {
+		return nil, err
+	}
+	s.firmar(req, nil, time.Now())
+
+	resp, err := s.cliente.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return io.ReadAll(resp.Body)
+	case http.StatusNotFound:
+		return nil, ErrNoExiste
+	}
+	detalle, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return nil, fmt.Errorf("S3 respondió %d: %s", resp.StatusCode, detalle)
+}
+
+// Firma AWS Signature V4 sobre host, x-amz-content-sha256 y x-amz-date
+func (s *S3) firmar(req *http.Request, contenido []byte, ahora time.Time) {
+	ahora = ahora.UTC()
+	fecha := ahora.Format("20060102")
+	marca := ahora.Format("20060102T150405Z")
+	resumen := sha256Hex(contenido)
+
+	req.Header.Set("X-Amz-Date", marca)
+	req.Header.Set("X-Amz-Content-Sha256", resumen)
+
+	const firmadas = "host;x-amz-content-sha256;x-amz-date"
+	canonica := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		"host:" + req.URL.Host + "\n" +
+			"x-amz-content-sha256:" + resumen + "\n" +
+			"x-amz-date:" + marca + "\n",
+		firmadas,
+		resumen,
+	}, "\n")
+
+	alcance := fecha + "/" + s.region + "/s3/aws4_request"
+	aFirmar := "AWS4-HMAC-SHA256\n" + marca + "\n" + alcance + "\n" + sha256Hex([]byte(canonica))
+
+	llave := hmacSHA256([]byte("AWS4"+s.secretKey), fecha)
+	llave = hmacSHA256(llave, s.region)
+	llave = hmacSHA256(llave, "s3")
+	llave = hmacSHA256(llave, "aws4_request")
+	firma := hex.EncodeToString(hmacSHA256(llave, aFirmar))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+s.accessKey+"/"+alcance+
+		", SignedHeaders="+firmadas+", Signature="+firma)
+}
+
+func sha256Hex(datos []byte) string {
+	suma := sha256.Sum256(datos)
+	return hex.EncodeToString(suma[:])
+}
+
+func hmacSHA256(llave []byte, datos string) []byte {
+	h := hmac.New(sha256.New, llave)
+	h.Write([]byte(datos))
+	return h.Sum(nil)
+}
+
+// Codifica cada segmento de la clave como lo exige SigV4: solo quedan sin
+// escapar letras, dígitos y -_.~
+func codificarRuta(clave string) string {
+	segmentos := strings.Split(clave, "/")
+	for i, segmento := range segmentos {
+		var b strings.Builder
+		for _, c := range []byte(segmento) {
+			if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') ||
+				c == '-' || c == '_' || c == '.' || c == '~' {
+				b.WriteByte(c)
+			} else {
+				fmt.Fprintf(&b, "%%%02X", c)
+			}
+		}
+		segmentos[i] = b.String()
+	}
+	return strings.Join(segmentos, "/")
+}
@@ -0,0 +1,110 @@
+package calendario
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Genera un calendario de suscripción (sin METHOD) con los eventos en la zona
+// indicada y su VTIMEZONE, para que los clientes muestren la hora correcta aun
+// con cambios de horario de verano
+func GenerarFeed(nombre string, zona *time.Location, eventos []Evento) []byte {
+	var b strings.Builder
+	linea := escritor(&b)
+
+	linea("BEGIN:VCALENDAR")
+	linea("VERSION:2.0")
+	linea("PRODID:" + prodID)
+	linea("CALSCALE:GREGORIAN")
+	linea("X-WR-CALNAME:" + escapar(nombre))
+	linea("X-WR-TIMEZONE:" + zona.String())
+	linea("REFRESH-INTERVAL;VALUE=DURATION:PT1H")
+	linea("X-PUBLISHED-TTL:PT1H")
+
+	desde, hasta := time.Now(), time.Now()
+	for _, ev := range eventos {
+		if ev.Inicio.Before(desde) {
+			desde = ev.Inicio
+		}
+		if ev.Fin.After(hasta) {
+			hasta = ev.Fin
+		}
+	}
+	for _, l := range vtimezone(zona, desde, hasta) {
+		linea(l)
+	}
+
+	for _, ev := range eventos {
+		escribirEvento(linea, ev, zona)
+	}
+	linea("END:VCALENDAR")
+
+	return []byte(b.String())
+}
+
+// VTIMEZONE de la zona entre dos fechas. Go no expone las reglas de la zona,
+// así que se buscan los cambios de desfase en el intervalo y se declara cada uno
+// como observancia propia (válido sin RRULE).
+func vtimezone(zona *time.Location, desde, hasta time.Time) []string {
+	lineas := []string{"BEGIN:VTIMEZONE", "TZID:" + zona.String()}
+
+	// Desfase vigente al inicio del intervalo
+	inicio := desde.AddDate(0, -1, 0).In(zona)
+	nombre, desfase := inicio.Zone()
+	lineas = append(lineas, observancia(inicio.IsDST(), "19700101T000000", desfase, desfase, nombre)...)
+
+	// Cambios de desfase dentro del intervalo, buscados por día y afinados por minuto
+	anterior := desfase
+	for dia := inicio; dia.Before(hasta.AddDate(0, 1, 0)); dia = dia.Add(24 * time.Hour) {
+		siguiente := dia.Add(24 * time.Hour)
+		_, nuevo := siguiente.Zone()
+		if nuevo == anterior {
+			continue
+		}
+
+		izq, der := dia, siguiente
+		for der.Sub(izq) > time.Minute {
+			medio := izq.Add(der.Sub(izq) / 2)
+			if _, d := medio.Zone(); d == anterior {
+				izq = medio
+			} else {
+				der = medio
+			}
+		}
+		cambio := der.Truncate(time.Minute).In(zona)
+		nombre, _ := cambio.Zone()
+
+		// DTSTART de la observancia es la hora local según el desfase anterior
+		local := cambio.Add(time.Duration(anterior-nuevo) * time.Second).In(time.FixedZone("", nuevo))
+		lineas = append(lineas, observancia(cambio.IsDST(), formatoLocal(local), anterior, nuevo, nombre)...)
+		anterior = nuevo
+	}
+
+	return append(lineas, "END:VTIMEZONE")
+}
+
+func observancia(verano bool, inicio string, desde, hacia int, nombre string) []string {
+	tipo := "STANDARD"
+	if verano {
+		tipo = "DAYLIGHT"
+	}
+	return []string{
+		"BEGIN:" + tipo,
+		"DTSTART:" + inicio,
+		"TZOFFSETFROM:" + formatoDesfase(desde),
+		"TZOFFSETTO:" + formatoDesfase(hacia),
+		"TZNAME:" + nombre,
+		"END:" + tipo,
+	}
+}
+
+// +HHMM / -HHMM
+func formatoDesfase(segundos int) string {
+	signo := "+"
+	if segundos < 0 {
+		signo = "-"
+		segundos = -segundos
+	}
+	return fmt.Sprintf("%s%02d%02d", signo, segundos/3600, (segundos%3600)/60)
+}
@@ -0,0 +1,158 @@
+package calendario
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Método iTIP de la invitación
+const (
+	MetodoSolicitud = "REQUEST"
+	MetodoCancelar  = "CANCEL"
+)
+
+// Duración de una cita en el calendario (CITA_DURACION, por defecto 30m)
+func DuracionCita() time.Duration {
+	if d, err := time.ParseDuration(os.Getenv("CITA_DURACION")); err == nil && d > 0 {
+		return d
+	}
+	return 30 * time.Minute
+}
+
+// Evento de calendario de una cita
+type Evento struct {
+	UID               string
+	Secuencia         int
+	Metodo            string
+	Inicio            time.Time
+	Fin               time.Time
+	Resumen           string
+	Descripcion       string
+	Ubicacion         string
+	Organizador       string // Correo del remitente de la clínica
+	NombreOrganizador string
+	Asistente         string // Correo del paciente
+	NombreAsistente   string
+}
+
+// UID estable de una cita: todas sus invitaciones actualizan el mismo evento
+func UIDCita(citaID uint) string {
+	dominio := os.Getenv("ICS_DOMINIO")
+	if dominio == "" {
+		if u, err := url.Parse(os.Getenv("APP_URL")); err == nil && u.Hostname() != "" {
+			dominio = u.Hostname()
+		} else {
+			dominio = "cmedicas"
+		}
+	}
+	return fmt.Sprintf("cita-%d@%s", citaID, dominio)
+}
+
+// Genera el VCALENDAR (RFC 5545) con un VEVENT. Las fechas van en UTC.
+func Generar(ev Evento) []byte {
+	var b strings.Builder
+	linea := escritor(&b)
+
+	linea("BEGIN:VCALENDAR")
+	linea("VERSION:2.0")
+	linea("PRODID:" + prodID)
+	linea("CALSCALE:GREGORIAN")
+	linea("METHOD:" + ev.Metodo)
+	escribirEvento(linea, ev, nil)
+	linea("END:VCALENDAR")
+
+	return []byte(b.String())
+}
+
+const prodID = "-//CMedicas//Citas//ES"
+
+// Escribe líneas de contenido plegadas y terminadas en CRLF
+func escritor(b *strings.Builder) func(string) {
+	return func(contenido string) {
+		b.WriteString(plegar(contenido))
+		b.WriteString("\r\n")
+	}
+}
+
+// Escribe un VEVENT. Con zona, las horas van en hora local con TZID (la zona
+// debe describirse con un VTIMEZONE en el mismo calendario); sin zona, en UTC.
+func escribirEvento(linea func(string), ev Evento, zona *time.Location) {
+	estado := "CONFIRMED"
+	if ev.Metodo == MetodoCancelar {
+		estado = "CANCELLED"
+	}
+
+	linea("BEGIN:VEVENT")
+	linea("UID:" + ev.UID)
+	linea(fmt.Sprintf("SEQUENCE:%d", ev.Secuencia))
+	linea("DTSTAMP:" + formatoUTC(time.Now()))
+	if zona != nil {
+		linea("DTSTART;TZID=" + zona.String() + ":" + formatoLocal(ev.Inicio.In(zona)))
+		linea("DTEND;TZID=" + zona.String() + ":" + formatoLocal(ev.Fin.In(zona)))
+	} else {
+		linea("DTSTART:" + formatoUTC(ev.Inicio))
+		linea("DTEND:" + formatoUTC(ev.Fin))
+	}
+	linea("SUMMARY:" + escapar(ev.Resumen))
+	if ev.Descripcion != "" {
+		linea("DESCRIPTION:" + escapar(ev.Descripcion))
+	}
+	if ev.Ubicacion != "" {
+		linea("LOCATION:" + escapar(ev.Ubicacion))
+	}
+	if ev.Organizador != "" {
+		if ev.NombreOrganizador != "" {
+			linea(fmt.Sprintf("ORGANIZER;CN=%s:mailto:%s", parametro(ev.NombreOrganizador), ev.Organizador))
+		} else {
+			linea("ORGANIZER:mailto:" + ev.Organizador)
+		}
+	}
+	if ev.Asistente != "" {
+		linea(fmt.Sprintf("ATTENDEE;CN=%s;ROLE=REQ-PARTICIPANT;RSVP=FALSE:mailto:%s", parametro(ev.NombreAsistente), ev.Asistente))
+	}
+	linea("STATUS:" + estado)
+	linea("TRANSP:OPAQUE")
+	linea("END:VEVENT")
+}
+
+func formatoLocal(t time.Time) string {
+	return t.Format("20060102T150405")
+}
+
+func formatoUTC(t time.Time) string {
+	return t.UTC().Format("20060102T150405Z")
+}
+
+// Escapa un valor TEXT (RFC 5545 §3.3.11)
+func escapar(texto string) string {
+	r := strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`)
+	return r.Replace(texto)
+}
+
+// Valor de parámetro entre comillas (sin comillas internas)
+func parametro(valor string) string {
+	return `"` + strings.ReplaceAll(valor, `"`, "'") + `"`
+}
+
+// Pliega líneas de más de 75 octetos sin partir caracteres UTF-8 (§3.1)
+func plegar(contenido string) string {
+	if len(contenido) <= 75 {
+		return contenido
+	}
+
+	var b strings.Builder
+	actual := 0
+	for _, r := range contenido {
+		tam := len(string(r))
+		if actual+tam > 75 {
+			b.WriteString("\r\n ")
+			actual = 1 // el espacio de continuación cuenta
+		}
+		b.WriteRune(r)
+		actual += tam
+	}
+	return b.String()
+}
@@ -1,11 +1,18 @@
 package clave
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
+	"io"
+	"net"
+	"net/mail"
+	"net/smtp"
 	"os"
+	"strconv"
 	"time"
 
-	"github.com/dgrijalva/jwt-go"
+	"github.com/golang-jwt/jwt/v5"
 	"golang.org/x/crypto/bcrypt"
 	"gopkg.in/gomail.v2"
 )
@@ -22,68 +29,211 @@ func CheckPasswordHash(password, hash string) bool {
 	return err == nil
 }
 
-// Clave secreta para firmar los tokens (esta en env)
-func getJWTSecret() []byte {
-	secret := os.Getenv("JWT_SECRET")
-	if secret == "" {
-		panic("JWT_SECRET no definido")
+// Genera un token JWT para un usuario, firmado con la llave activa (ver llaves.go)
+func GenerateJWT(userID uint, rol string, versionSesion uint) (string, error) {
+	claims := jwt.MapClaims{
+		"sub": userID,
+		"rol": rol,
+		"ver": versionSesion,
+		"iat": time.Now().Unix(),
+		"exp": time.Now().Add(time.Hour * 24).Unix(), // Expira en 24 hora (modificar, al terminar pruebas)
+	}
+	if emisor := os.Getenv("JWT_EMISOR"); emisor != "" {
+		claims["iss"] = emisor
 	}
-	return []byte(secret)
+
+	return firmarToken(claims)
 }
 
-// Clave secreta para firmar los tokens (esta en env)
-// var jwtSecret = []byte(os.Getenv("JWT_SECRET"))
+// Validar token (RS256/EdDSA por kid; HS256 solo para tokens previos a la migración)
+func ValidateJWT(tokenString string) (*jwt.Token, error) {
+	return jwt.Parse(tokenString, llaveVerificacion,
+		jwt.WithValidMethods([]string{"RS256", "EdDSA", "HS256"}),
+		jwt.WithExpirationRequired(),
+	)
+}
 
-// Genera un token JWT para un usuario
-func GenerateJWT(userID uint, rol string) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"sub": userID,               
-		"rol": rol,                 
-		"iat": time.Now().Unix(),   
-		"exp": time.Now().Add(time.Hour * 24).Unix(), // Expira en 24 hora (modificar, al terminar pruebas)
-	})
+// Correo saliente
+type Correo struct {
+	Destinatario string
+	Asunto       string
+	Texto        string
+	HTML         string // Opcional: se envía como alternativa al texto plano
+	Cabeceras    map[string]string
+	Adjuntos     []Adjunto
+}
 
-	return token.SignedString(getJWTSecret())
-	// return token.SignedString(jwtSecret)
+// Archivo adjunto de un correo
+type Adjunto struct {
+	Nombre    string
+	Tipo      string // Content-Type completo, ej. "text/calendar; method=REQUEST; charset=UTF-8"
+	Contenido []byte
 }
 
-// Validar token
-// func ValidateJWT(tokenString string) (*jwt.Token, error) {
-// 	return jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-// 		// Verifica el método de firma
-// 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-// 			return nil, jwt.ErrSignatureInvalid
-// 		}
-// 		return jwtSecret, nil
-// 	})
-// }
-// Validar token
-func ValidateJWT(tokenString string) (*jwt.Token, error) {
-	return jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, jwt.ErrSignatureInvalid
-		}
-		return getJWTSecret(), nil
-	})
+// Límite de un envío SMTP completo cuando el contexto no trae uno
+const limiteSMTP = 30 * time.Second
+
+type configSMTP struct {
+	host       string
+	puerto     int
+	usuario    string
+	contrasena string
+	remitente  string
 }
 
-func EnviarCorreo(destinatario, asunto, mensaje string) error {
+// Configuración SMTP: SMTP_HOST, SMTP_PUERTO, SMTP_REMITENTE, MAIL_USER, MAIL_PASS
+func leerConfigSMTP() configSMTP {
+	host := os.Getenv("SMTP_HOST")
+	if host == "" {
+		host = "smtp.gmail.com"
+	}
+	puerto, err := strconv.Atoi(os.Getenv("SMTP_PUERTO"))
+	if err != nil {
+		puerto = 587
+	}
+	remitente := os.Getenv("SMTP_REMITENTE")
+	if remitente == "" {
+		remitente = os.Getenv("MAIL_USER")
+	}
+
+	return configSMTP{
+		host:       host,
+		puerto:     puerto,
+		usuario:    os.Getenv("MAIL_USER"),
+		contrasena: os.Getenv("MAIL_PASS"),
+		remitente:  remitente,
+	}
+}
+
+// Remitente de los correos separado en nombre y dirección; SMTP_REMITENTE
+// puede traer nombre ("CMedicas <avisos@...>")
+func Remitente() (nombre, direccion string) {
+	return separarRemitente(leerConfigSMTP().remitente)
+}
+
+func separarRemitente(remitente string) (nombre, direccion string) {
+	if d, err := mail.ParseAddress(remitente); err == nil {
+		return d.Name, d.Address
+	}
+	return "", remitente
+}
+
+func mensajeCorreo(correo Correo, remitente string) *gomail.Message {
 	m := gomail.NewMessage()
-	m.SetHeader("From", "candcfly9@gmail.com")
-	m.SetHeader("To", destinatario)
-	m.SetHeader("Subject", asunto)
-	m.SetBody("text/plain", mensaje)
-
-	d := gomail.NewDialer(
-    "smtp.gmail.com",
-    587,
-    os.Getenv("MAIL_USER"),
-    os.Getenv("MAIL_PASS"),
-	)
+	m.SetHeader("From", remitente)
+	m.SetHeader("To", correo.Destinatario)
+	m.SetHeader("Subject", correo.Asunto)
+	for nombre, valor := range correo.Cabeceras {
+		m.SetHeader(nombre, valor)
+	}
+	m.SetBody("text/plain", correo.Texto)
+	if correo.HTML != "" {
+		m.AddAlternative("text/html", correo.HTML)
+	}
+	for _, adjunto := range correo.Adjuntos {
+		contenido := adjunto.Contenido
+		m.Attach(adjunto.Nombre,
+			gomail.SetHeader(map[string][]string{"Content-Type": {adjunto.Tipo}}),
+			gomail.SetCopyFunc(func(w io.Writer) error {
+				_, err := w.Write(contenido)
+				return err
+			}),
+		)
+	}
+	return m
+}
 
+func EnviarCorreoCompleto(correo Correo) error {
+	return EnviarCorreoContexto(context.Background(), correo)
+}
 
-	if err := d.DialAndSend(m); err != nil {
+// Envía el correo respetando el contexto: la conexión se cierra al cancelarse
+// y todo el intercambio SMTP tiene plazo (el del contexto o limiteSMTP), así un
+// servidor que no responde no retiene al llamador
+func EnviarCorreoContexto(ctx context.Context, correo Correo) error {
+	config := leerConfigSMTP()
+	if err := enviarSMTP(ctx, config, correo.Destinatario, mensajeCorreo(correo, config.remitente)); err != nil {
 		return fmt.Errorf("no se pudo enviar el correo: %w", err)
 	}
 	return nil
-}
\ No newline at end of file
+}
+
+func enviarSMTP(ctx context.Context, config configSMTP, destinatario string, m *gomail.Message) (err error) {
+	plazo, ok := ctx.Deadline()
+	if !ok {
+		plazo = time.Now().Add(limiteSMTP)
+	}
+	ctx, cancelar := context.WithDeadline(ctx, plazo)
+	defer cancelar()
+	// Si se venció el plazo, la causa es esa y no la conexión cerrada
+	defer func() {
+		if err != nil && ctx.Err() != nil {
+			err = ctx.Err()
+		}
+	}()
+
+	direccion := net.JoinHostPort(config.host, strconv.Itoa(config.puerto))
+	tlsConfig := &tls.Config{ServerName: config.host}
+	dialer := &net.Dialer{}
+
+	// 465: TLS implícito; otros puertos: STARTTLS si el servidor lo ofrece
+	var conn net.Conn
+	if config.puerto == 465 {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: tlsConfig}).DialContext(ctx, "tcp", direccion)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", direccion)
+	}
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(plazo); err != nil {
+		return err
+	}
+	detener := context.AfterFunc(ctx, func() { conn.Close() })
+	defer detener()
+
+	cliente, err := smtp.NewClient(conn, config.host)
+	if err != nil {
+		return err
+	}
+	defer cliente.Close()
+
+	if config.puerto != 465 {
+		if ok, _ := cliente.Extension("STARTTLS"); ok {
+			if err := cliente.StartTLS(tlsConfig); err != nil {
+				return err
+			}
+		}
+	}
+	if config.usuario != "" {
+		if ok, _ := cliente.Extension("AUTH"); ok {
+			if err := cliente.Auth(smtp.PlainAuth("", config.usuario, config.contrasena, config.host)); err != nil {
+				return err
+			}
+		}
+	}
+
+	_, remitente := separarRemitente(config.remitente)
+	if err := cliente.Mail(remitente); err != nil {
+		return err
+	}
+	if err := cliente.Rcpt(destinatario); err != nil {
+		return err
+	}
+	w, err := cliente.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := m.WriteTo(w); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return cliente.Quit()
+}
+
+func EnviarCorreo(destinatario, asunto, mensaje string) error {
+	return EnviarCorreoCompleto(Correo{Destinatario: destinatario, Asunto: asunto, Texto: mensaje})
+}
@@ -0,0 +1,21 @@
+package clave
+
+import "testing"
+
+func TestSepararRemitente(t *testing.T) {
+	casos := []struct {
+		remitente string
+		nombre    string
+		direccion string
+	}{
+		{"avisos@clinica.mx", "", "avisos@clinica.mx"},
+		{"CMedicas <avisos@clinica.mx>", "CMedicas", "avisos@clinica.mx"},
+		{`"Clínica Norte" <avisos@clinica.mx>`, "Clínica Norte", "avisos@clinica.mx"},
+	}
+	for _, c := range casos {
+		nombre, direccion := separarRemitente(c.remitente)
+		if nombre != c.nombre || direccion != c.direccion {
+			t.Errorf("separarRemitente(%q) = (%q, %q), se esperaba (%q, %q)", c.remitente, nombre, direccion, c.nombre, c.direccion)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package clave
+
+import (
+	"errors"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Audiencia de los tokens de enlace de cita; el middleware de autenticación
+// rechaza cualquier token con audiencia, así que no sirven como sesión
+const audienciaCita = "cita"
+
+// Token firmado para confirmar o cancelar una cita sin iniciar sesión. Vence a
+// la hora de la cita y queda ligado a esa fecha: si la cita se reprograma, los
+// enlaces anteriores dejan de valer.
+func GenerarTokenCita(citaID uint, fechaCita time.Time) (string, error) {
+	return firmarToken(jwt.MapClaims{
+		"aud": audienciaCita,
+		"cid": citaID,
+		"fc":  fechaCita.Unix(),
+		"iat": time.Now().Unix(),
+		"exp": fechaCita.Unix(),
+	})
+}
+
+// Valida un token de enlace y devuelve la cita y la fecha a la que se refiere
+func ValidarTokenCita(tokenString string) (uint, time.Time, error) {
+	token, err := jwt.Parse(tokenString, llaveVerificacion,
+		jwt.WithValidMethods([]string{"RS256", "EdDSA"}),
+		jwt.WithExpirationRequired(),
+		jwt.WithAudience(audienciaCita),
+	)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return 0, time.Time{}, errors.New("token inválido")
+	}
+	citaID, okCita := claims["cid"].(float64)
+	fecha, okFecha := claims["fc"].(float64)
+	if !okCita || !okFecha {
+		return 0, time.Time{}, errors.New("token inválido")
+	}
+	return uint(citaID), time.Unix(int64(fecha), 0), nil
+}
+
+// Audiencia de los boletos para abrir el flujo de eventos en tiempo real
+const audienciaEventos = "eventos"
+
+// Vigencia del boleto: solo tiene que alcanzar para abrir la conexión
+const VigenciaTicketEventos = 60 * time.Second
+
+// Boleto de corta duración para abrir /api/eventos. EventSource no envía
+// cabeceras y lo que va en la URL termina en los registros de acceso, así que
+// en la consulta va este boleto y no el token de sesión.
+func GenerarTicketEventos(usuarioID uint, rol string, versionSesion uint) (string, error) {
+	return firmarToken(jwt.MapClaims{
+		"aud": audienciaEventos,
+		"uid": usuarioID,
+		"rol": rol,
+		"ver": versionSesion,
+		"iat": time.Now().Unix(),
+		"exp": time.Now().Add(VigenciaTicketEventos).Unix(),
+	})
+}
+
+// Valida un boleto de eventos; devuelve el usuario, su rol y la versión de
+// sesión con la que se emitió
+func ValidarTicketEventos(ticket string) (uint, string, uint, error) {
+	token, err := jwt.Parse(ticket, llaveVerificacion,
+		jwt.WithValidMethods([]string{"RS256", "EdDSA"}),
+		jwt.WithExpirationRequired(),
+		jwt.WithAudience(audienciaEventos),
+	)
+	if err != nil {
+		return 0, "", 0, err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return 0, "", 0, errors.New("boleto inválido")
+	}
+	usuarioID, okUsuario := claims["uid"].(float64)
+	rol, okRol := claims["rol"].(string)
+	version, okVersion := claims["ver"].(float64)
+	if !okUsuario || !okRol || !okVersion {
+		return 0, "", 0, errors.New("boleto inválido")
+	}
+	return uint(usuarioID), rol, uint(version), nil
+}
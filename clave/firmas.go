@@ -0,0 +1,142 @@
+package clave
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Audiencia de las firmas de documentos clínicos; igual que los enlaces de
+// cita, no sirven como token de sesión
+const audienciaDocumento = "documento"
+
+func resumenDocumento(contenido []byte) string {
+	suma := sha256.Sum256(contenido)
+	return base64.RawURLEncoding.EncodeToString(suma[:])
+}
+
+var (
+	llavesDocumento     *juegoLlaves
+	llavesDocumentoErr  error
+	llavesDocumentoOnce sync.Once
+)
+
+// Las firmas de documentos no vencen, así que no usan las llaves de sesión
+// (que rotan y se retiran) sino su propio juego en DOCUMENTO_LLAVES_DIR
+// ("llaves_documentos" por omisión). Ninguna llave de ese directorio se debe
+// borrar: para rotar se agrega otra y se apunta DOCUMENTO_KID_ACTIVO a ella.
+// Si el directorio no tiene llaves se genera una Ed25519.
+func cargarLlavesDocumento() (*juegoLlaves, error) {
+	llavesDocumentoOnce.Do(func() {
+		dir := os.Getenv("DOCUMENTO_LLAVES_DIR")
+		if dir == "" {
+			dir = "llaves_documentos"
+		}
+		if llavesDocumentoErr = crearLlaveDocumentoSiFalta(dir); llavesDocumentoErr != nil {
+			return
+		}
+		llavesDocumento, llavesDocumentoErr = leerLlaves(dir, os.Getenv("DOCUMENTO_KID_ACTIVO"))
+	})
+	return llavesDocumento, llavesDocumentoErr
+}
+
+func crearLlaveDocumentoSiFalta(dir string) error {
+	existentes, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil || len(existentes) > 0 {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+
+	_, privada, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(privada)
+	if err != nil {
+		return err
+	}
+	kid := "doc-" + time.Now().UTC().Format("20060102150405")
+	archivo, err := os.OpenFile(filepath.Join(dir, kid+".pem"), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	if err := pem.Encode(archivo, &pem.Block{Type: "PRIVATE KEY", Bytes: der}); err != nil {
+		archivo.Close()
+		return err
+	}
+	log.Printf("Llave de firma de documentos generada en %s; respáldela y no la borre", archivo.Name())
+	return archivo.Close()
+}
+
+// Llave pública para verificar una firma de documento. Las firmas hechas antes
+// de separar los juegos de llaves llevan el kid de una llave de sesión y solo
+// se pueden verificar mientras esa llave se conserve.
+func llaveVerificacionDocumento(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	juego, err := cargarLlavesDocumento()
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := juego.porKid[kid]; ok {
+		return juego.publica(token, kid)
+	}
+	return llaveVerificacion(token)
+}
+
+// Firma el contenido de un documento (adenda, nota clínica) a nombre de un
+// usuario. La firma es un JWS con el resumen SHA-256 del contenido, así que
+// cualquier cambio posterior la invalida. No vence.
+func FirmarDocumento(tipo string, firmanteID uint, contenido []byte) (string, error) {
+	juego, err := cargarLlavesDocumento()
+	if err != nil {
+		return "", err
+	}
+	return juego.firmar(jwt.MapClaims{
+		"aud": audienciaDocumento,
+		"doc": tipo,
+		"fid": firmanteID,
+		"sha": resumenDocumento(contenido),
+		"iat": time.Now().Unix(),
+	})
+}
+
+// Verifica que la firma corresponda al contenido y al tipo de documento;
+// devuelve quién firmó y cuándo
+func VerificarFirmaDocumento(firma, tipo string, contenido []byte) (uint, time.Time, error) {
+	token, err := jwt.Parse(firma, llaveVerificacionDocumento,
+		jwt.WithValidMethods([]string{"RS256", "EdDSA"}),
+		jwt.WithAudience(audienciaDocumento),
+	)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return 0, time.Time{}, errors.New("firma inválida")
+	}
+	doc, _ := claims["doc"].(string)
+	sha, _ := claims["sha"].(string)
+	firmante, okFirmante := claims["fid"].(float64)
+	emitida, okEmitida := claims["iat"].(float64)
+	if doc != tipo || !okFirmante || !okEmitida {
+		return 0, time.Time{}, errors.New("firma inválida")
+	}
+	if sha != resumenDocumento(contenido) {
+		return 0, time.Time{}, errors.New("el contenido no coincide con la firma")
+	}
+	return uint(firmante), time.Unix(int64(emitida), 0), nil
+}
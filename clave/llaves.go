@@ -0,0 +1,225 @@
+package clave
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Llave de firma identificada por kid. Las llaves retiradas se conservan
+// solo para verificar tokens emitidos antes de una rotación.
+type llaveJWT struct {
+	kid     string
+	metodo  jwt.SigningMethod
+	privada crypto.Signer
+	publica crypto.PublicKey
+}
+
+type juegoLlaves struct {
+	activa *llaveJWT
+	porKid map[string]*llaveJWT
+}
+
+var (
+	llaves     *juegoLlaves
+	llavesErr  error
+	llavesOnce sync.Once
+)
+
+// Carga las llaves de JWT_LLAVES_DIR (un archivo <kid>.pem por llave, RSA o Ed25519).
+// JWT_KID_ACTIVO elige la llave que firma; si no se define se usa la última por nombre.
+// Para rotar: agregar la nueva llave, apuntar JWT_KID_ACTIVO a ella y reiniciar;
+// la llave anterior se retira cuando ya no haya tokens vigentes firmados con ella.
+func cargarLlaves() (*juegoLlaves, error) {
+	llavesOnce.Do(func() {
+		llaves, llavesErr = leerLlaves(os.Getenv("JWT_LLAVES_DIR"), os.Getenv("JWT_KID_ACTIVO"))
+	})
+	return llaves, llavesErr
+}
+
+func leerLlaves(dir, kidActivo string) (*juegoLlaves, error) {
+	juego := &juegoLlaves{porKid: map[string]*llaveJWT{}}
+
+	if dir == "" {
+		// Sin llaves configuradas (modo local): llave efímera, los tokens no sobreviven reinicios
+		log.Println("JWT_LLAVES_DIR no definido, usando llave Ed25519 efímera")
+		_, privada, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		llave := &llaveJWT{kid: "efimera", metodo: jwt.SigningMethodEdDSA, privada: privada, publica: privada.Public()}
+		juego.activa = llave
+		juego.porKid[llave.kid] = llave
+		return juego, nil
+	}
+
+	archivos, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(archivos)
+
+	for _, archivo := range archivos {
+		kid := strings.TrimSuffix(filepath.Base(archivo), ".pem")
+		contenido, err := os.ReadFile(archivo)
+		if err != nil {
+			return nil, fmt.Errorf("no se pudo leer la llave %s: %w", kid, err)
+		}
+
+		llave, err := parsearLlavePrivada(kid, contenido)
+		if err != nil {
+			return nil, err
+		}
+		juego.porKid[kid] = llave
+		juego.activa = llave
+	}
+
+	if kidActivo != "" {
+		juego.activa = juego.porKid[kidActivo]
+	}
+	if juego.activa == nil {
+		return nil, errors.New("no hay llave activa para firmar tokens en JWT_LLAVES_DIR")
+	}
+	return juego, nil
+}
+
+func parsearLlavePrivada(kid string, contenido []byte) (*llaveJWT, error) {
+	bloque, _ := pem.Decode(contenido)
+	if bloque == nil {
+		return nil, fmt.Errorf("la llave %s no está en formato PEM", kid)
+	}
+
+	var privada interface{}
+	var err error
+	switch bloque.Type {
+	case "RSA PRIVATE KEY":
+		privada, err = x509.ParsePKCS1PrivateKey(bloque.Bytes)
+	case "PRIVATE KEY":
+		privada, err = x509.ParsePKCS8PrivateKey(bloque.Bytes)
+	default:
+		return nil, fmt.Errorf("tipo de llave no soportado en %s: %s", kid, bloque.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("llave %s inválida: %w", kid, err)
+	}
+
+	switch k := privada.(type) {
+	case *rsa.PrivateKey:
+		if k.N.BitLen() < 2048 {
+			return nil, fmt.Errorf("la llave RSA %s debe ser de al menos 2048 bits", kid)
+		}
+		return &llaveJWT{kid: kid, metodo: jwt.SigningMethodRS256, privada: k, publica: k.Public()}, nil
+	case ed25519.PrivateKey:
+		return &llaveJWT{kid: kid, metodo: jwt.SigningMethodEdDSA, privada: k, publica: k.Public()}, nil
+	}
+	return nil, fmt.Errorf("algoritmo de llave no soportado en %s", kid)
+}
+
+// Firma los claims con la llave activa e incluye su kid en el encabezado
+func firmarToken(claims jwt.Claims) (string, error) {
+	juego, err := cargarLlaves()
+	if err != nil {
+		return "", err
+	}
+	return juego.firmar(claims)
+}
+
+func (juego *juegoLlaves) firmar(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(juego.activa.metodo, claims)
+	token.Header["kid"] = juego.activa.kid
+	return token.SignedString(juego.activa.privada)
+}
+
+// Llave pública del juego que corresponde al kid del token
+func (juego *juegoLlaves) publica(token *jwt.Token, kid string) (interface{}, error) {
+	llave, ok := juego.porKid[kid]
+	if !ok {
+		return nil, fmt.Errorf("kid desconocido: %s", kid)
+	}
+	if token.Method.Alg() != llave.metodo.Alg() {
+		return nil, jwt.ErrTokenSignatureInvalid
+	}
+	return llave.publica, nil
+}
+
+// Devuelve la llave pública que corresponde al kid del token
+func llaveVerificacion(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		// Tokens HS256 emitidos antes de la migración, mientras JWT_SECRET siga definido
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); ok {
+			if secreto := os.Getenv("JWT_SECRET"); secreto != "" {
+				return []byte(secreto), nil
+			}
+		}
+		return nil, errors.New("token sin kid")
+	}
+
+	juego, err := cargarLlaves()
+	if err != nil {
+		return nil, err
+	}
+
+	return juego.publica(token, kid)
+}
+
+// Llave pública en formato JWK (RFC 7517)
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// Conjunto de llaves públicas vigentes para que otros servicios validen los tokens
+func JWKS() ([]JWK, error) {
+	juego, err := cargarLlaves()
+	if err != nil {
+		return nil, err
+	}
+
+	kids := make([]string, 0, len(juego.porKid))
+	for kid := range juego.porKid {
+		kids = append(kids, kid)
+	}
+	sort.Strings(kids)
+
+	b64 := base64.RawURLEncoding
+	jwks := make([]JWK, 0, len(kids))
+	for _, kid := range kids {
+		llave := juego.porKid[kid]
+		jwk := JWK{Kid: kid, Use: "sig", Alg: llave.metodo.Alg()}
+
+		switch pub := llave.publica.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = b64.EncodeToString(pub.N.Bytes())
+			jwk.E = b64.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = b64.EncodeToString(pub)
+		}
+		jwks = append(jwks, jwk)
+	}
+	return jwks, nil
+}
@@ -0,0 +1,138 @@
+package clave
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Parámetros TOTP (RFC 6238), compatibles con Google Authenticator, Authy, etc.
+const (
+	totpDigitos  = 6
+	totpPeriodo  = 30
+	totpVentana  = 1 // pasos de tolerancia antes/después por desfase de reloj
+	emisorPorDef = "CMedicas"
+)
+
+var base32SinRelleno = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Genera un secreto TOTP aleatorio (160 bits) codificado en base32
+func GenerarSecretoTOTP() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base32SinRelleno.EncodeToString(b), nil
+}
+
+// Construye la URI otpauth:// que las apps convierten en código QR
+func URIProvisionamientoTOTP(secreto, cuenta string) string {
+	emisor := os.Getenv("TOTP_EMISOR")
+	if emisor == "" {
+		emisor = emisorPorDef
+	}
+
+	v := url.Values{}
+	v.Set("secret", secreto)
+	v.Set("issuer", emisor)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprint(totpDigitos))
+	v.Set("period", fmt.Sprint(totpPeriodo))
+
+	etiqueta := url.PathEscape(emisor + ":" + cuenta)
+	return "otpauth://totp/" + etiqueta + "?" + v.Encode()
+}
+
+// Valida un código TOTP contra el secreto, tolerando un paso de desfase, y
+// devuelve el paso que coincidió. Solo se aceptan pasos posteriores a
+// ultimoPaso, para que un código ya usado no sirva otra vez dentro de su ventana.
+func ValidarCodigoTOTP(secreto, codigo string, ahora time.Time, ultimoPaso int64) (int64, bool) {
+	codigo = strings.TrimSpace(codigo)
+	if len(codigo) != totpDigitos {
+		return 0, false
+	}
+
+	llave, err := base32SinRelleno.DecodeString(strings.ToUpper(secreto))
+	if err != nil {
+		return 0, false
+	}
+
+	paso := ahora.Unix() / totpPeriodo
+	for i := -totpVentana; i <= totpVentana; i++ {
+		candidato := paso + int64(i)
+		esperado := codigoHOTP(llave, uint64(candidato))
+		if subtle.ConstantTimeCompare([]byte(esperado), []byte(codigo)) == 1 && candidato > ultimoPaso {
+			return candidato, true
+		}
+	}
+	return 0, false
+}
+
+func codigoHOTP(llave []byte, contador uint64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], contador)
+
+	mac := hmac.New(sha1.New, llave)
+	mac.Write(msg[:])
+	suma := mac.Sum(nil)
+
+	desplazamiento := suma[len(suma)-1] & 0x0f
+	valor := binary.BigEndian.Uint32(suma[desplazamiento:desplazamiento+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigitos, valor%1000000)
+}
+
+// Genera n códigos de recuperación de un solo uso (formato xxxxx-xxxxx)
+func GenerarCodigosRecuperacion(n int) ([]string, error) {
+	codigos := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		h := hex.EncodeToString(b)
+		codigos = append(codigos, h[:5]+"-"+h[5:])
+	}
+	return codigos, nil
+}
+
+// Normaliza un código de recuperación ingresado por el usuario
+func NormalizarCodigoRecuperacion(codigo string) string {
+	codigo = strings.ToLower(strings.TrimSpace(codigo))
+	return strings.ReplaceAll(codigo, " ", "")
+}
+
+// Genera un token opaco aleatorio (para desafíos, invitaciones, etc.)
+func GenerarTokenAleatorio() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Hash SHA-256 de un token; en BD solo se guarda el hash, nunca el token
+func HashToken(token string) string {
+	suma := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(suma[:])
+}
+
+// Indica si la política obliga a un rol a usar segundo factor.
+// Se configura con MFA_ROLES_OBLIGATORIOS (ej. "medico,administrador").
+func SegundoFactorObligatorio(rol string) bool {
+	for _, r := range strings.Split(os.Getenv("MFA_ROLES_OBLIGATORIOS"), ",") {
+		if strings.TrimSpace(r) == rol && rol != "" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,77 @@
+package clave
+
+import (
+	"testing"
+	"time"
+)
+
+// Semilla de los vectores de prueba de RFC 4226 y RFC 6238 (SHA-1)
+var semillaRFC = []byte("12345678901234567890")
+
+func TestCodigoHOTP(t *testing.T) {
+	// RFC 4226, apéndice D
+	esperados := []string{"755224", "287082", "359152", "969429", "338314", "254676", "287922", "162583", "399871", "520489"}
+	for contador, esperado := range esperados {
+		if codigo := codigoHOTP(semillaRFC, uint64(contador)); codigo != esperado {
+			t.Errorf("contador %d: código %s, se esperaba %s", contador, codigo, esperado)
+		}
+	}
+}
+
+func TestValidarCodigoTOTP(t *testing.T) {
+	secreto := base32SinRelleno.EncodeToString(semillaRFC)
+
+	// RFC 6238, apéndice B (SHA-1), con los últimos 6 dígitos
+	vectores := []struct {
+		unix   int64
+		codigo string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, v := range vectores {
+		paso, ok := ValidarCodigoTOTP(secreto, v.codigo, time.Unix(v.unix, 0), 0)
+		if !ok || paso != v.unix/totpPeriodo {
+			t.Errorf("t=%d: ValidarCodigoTOTP(%s) = (%d, %v), se esperaba (%d, true)", v.unix, v.codigo, paso, ok, v.unix/totpPeriodo)
+		}
+	}
+}
+
+func TestValidarCodigoTOTPVentanaYReuso(t *testing.T) {
+	secreto := base32SinRelleno.EncodeToString(semillaRFC)
+	ahora := time.Unix(1111111111, 0)
+	paso := ahora.Unix() / totpPeriodo
+	codigo := codigoHOTP(semillaRFC, uint64(paso))
+
+	casos := []struct {
+		nombre     string
+		codigo     string
+		ahora      time.Time
+		ultimoPaso int64
+		valido     bool
+	}{
+		{"paso actual", codigo, ahora, 0, true},
+		{"un paso de desfase", codigo, ahora.Add(totpPeriodo * time.Second), 0, true},
+		{"fuera de la ventana", codigo, ahora.Add(2 * totpPeriodo * time.Second), 0, false},
+		{"paso ya usado", codigo, ahora, paso, false},
+		{"paso posterior al último usado", codigo, ahora, paso - 1, true},
+		{"con espacios", " " + codigo + " ", ahora, 0, true},
+		{"longitud incorrecta", codigo[:5], ahora, 0, false},
+		{"código incorrecto", "000000", ahora, 0, false},
+	}
+	for _, c := range casos {
+		t.Run(c.nombre, func(t *testing.T) {
+			if _, ok := ValidarCodigoTOTP(secreto, c.codigo, c.ahora, c.ultimoPaso); ok != c.valido {
+				t.Errorf("ValidarCodigoTOTP = %v, se esperaba %v", ok, c.valido)
+			}
+		})
+	}
+
+	if _, ok := ValidarCodigoTOTP("no-es-base32!", codigo, ahora, 0); ok {
+		t.Error("un secreto inválido no debe validar")
+	}
+}
@@ -0,0 +1,355 @@
+package controllers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Ilimm9/CMedicas/Respuestas"
+	"github.com/Ilimm9/CMedicas/almacenamiento"
+	"github.com/Ilimm9/CMedicas/documentos"
+	"github.com/Ilimm9/CMedicas/initializers"
+	"github.com/Ilimm9/CMedicas/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Tipos admitidos, detectados del contenido, con la extensión con que se guardan
+var tiposAdjunto = map[string]string{
+	"application/pdf": ".pdf",
+	"image/jpeg":      ".jpg",
+	"image/png":       ".png",
+	"image/webp":      ".webp",
+}
+
+const ladoMiniatura = 256
+
+// Tamaño máximo de un adjunto (ADJUNTO_TAMANO_MAXIMO_MB, 20 MB por omisión)
+func tamanoMaximoAdjunto() int64 {
+	if v, err := strconv.Atoi(os.Getenv("ADJUNTO_TAMANO_MAXIMO_MB")); err == nil && v > 0 {
+		return int64(v) << 20
+	}
+	return 20 << 20
+}
+
+// Adjunto vigente de :id si el usuario puede ver el historial del paciente
+func adjuntoAccesible(c *gin.Context) (models.Adjunto, bool) {
+	var adjunto models.Adjunto
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, "ID inválido")
+		return adjunto, false
+	}
+
+	if err := initializers.GetDB().Where("eliminado_en IS NULL").First(&adjunto, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			respuestas.RespondError(c, http.StatusNotFound, "Adjunto no encontrado")
+		} else {
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al buscar adjunto: "+err.Error())
+		}
+		return adjunto, false
+	}
+
+	permitido, err := puedeVerHistorial(c, adjunto.PersonaID)
+	if err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al verificar acceso: "+err.Error())
+		return adjunto, false
+	}
+	// Sin acceso se responde igual que si no existiera
+	if !permitido {
+		respuestas.RespondError(c, http.StatusNotFound, "Adjunto no encontrado")
+		return adjunto, false
+	}
+	return adjunto, true
+}
+
+// Adjuntos vigentes de un paciente (?cita_id=, ?categoria=), los más recientes primero
+func GetAdjuntos(c *gin.Context) {
+	personaID, ok := accesoAntecedentes(c, false)
+	if !ok {
+		return
+	}
+
+	consulta := initializers.GetDB().Where("persona_id = ? AND eliminado_en IS NULL", personaID)
+	if citaID := c.Query("cita_id"); citaID != "" {
+		consulta = consulta.Where("cita_id = ?", citaID)
+	}
+	if categoria := c.Query("categoria"); categoria != "" {
+		consulta = consulta.Where("categoria = ?", categoria)
+	}
+
+	var adjuntos []models.Adjunto
+	if err := consulta.Order("creado_en DESC").Find(&adjuntos).Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al obtener adjuntos: "+err.Error())
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusOK, adjuntos)
+}
+
+// Subir un archivo al expediente (multipart: "archivo", "categoria",
+// "cita_id" y "descripcion" opcionales). Lo puede hacer quien ve el historial:
+// el paciente o su tutor, sus médicos o un administrador.
+func PostAdjunto(c *gin.Context) {
+	personaID, ok := accesoAntecedentes(c, false)
+	if !ok {
+		return
+	}
+	usuarioID, _ := usuarioActualID(c)
+
+	maximo := tamanoMaximoAdjunto()
+	// Margen para los demás campos del formulario
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maximo+1<<20)
+
+	archivo, err := c.FormFile("archivo")
+	if err != nil {
+		var demasiado *http.MaxBytesError
+		if errors.As(err, &demasiado) {
+			respuestas.RespondError(c, http.StatusRequestEntityTooLarge, fmt.Sprintf("El archivo excede %d MB", maximo>>20))
+		} else {
+			respuestas.RespondError(c, http.StatusBadRequest, "Adjunte el archivo en el campo 'archivo'")
+		}
+		return
+	}
+	if archivo.Size > maximo {
+		respuestas.RespondError(c, http.StatusRequestEntityTooLarge, fmt.Sprintf("El archivo excede %d MB", maximo>>20))
+		return
+	}
+	if archivo.Size == 0 {
+		respuestas.RespondError(c, http.StatusBadRequest, "El archivo está vacío")
+		return
+	}
+
+	categoria := c.DefaultPostForm("categoria", "otro")
+	switch categoria {
+	case "laboratorio", "imagen", "referencia", "otro":
+	default:
+		respuestas.RespondError(c, http.StatusBadRequest, "Categoría inválida: use laboratorio, imagen, referencia u otro")
+		return
+	}
+
+	var citaID *uint
+	if v := c.PostForm("cita_id"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			respuestas.RespondError(c, http.StatusBadRequest, "ID de cita inválido")
+			return
+		}
+		var citas int64
+		if err := citasDePersona(initializers.GetDB().Model(&models.Cita{}), personaID).
+			Where("cita.id = ?", id).
+			Count(&citas).Error; err != nil {
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al verificar cita: "+err.Error())
+			return
+		}
+		if citas == 0 {
+			respuestas.RespondError(c, http.StatusBadRequest, "La cita no es de este paciente")
+			return
+		}
+		cita := uint(id)
+		citaID = &cita
+	}
+
+	f, err := archivo.Open()
+	if err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, "No se pudo leer el archivo: "+err.Error())
+		return
+	}
+	contenido, err := io.ReadAll(f)
+	f.Close()
+	if err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, "No se pudo leer el archivo: "+err.Error())
+		return
+	}
+
+	// Se confía en el contenido, no en la extensión ni en el Content-Type del cliente
+	tipo := http.DetectContentType(contenido)
+	extension, permitido := tiposAdjunto[tipo]
+	if !permitido {
+		respuestas.RespondError(c, http.StatusUnsupportedMediaType, "Tipo de archivo no admitido ("+tipo+"); se aceptan PDF, JPEG, PNG y WebP")
+		return
+	}
+
+	var miniatura []byte
+	if strings.HasPrefix(tipo, "image/") {
+		miniatura, err = documentos.MiniaturaJPEG(contenido, ladoMiniatura)
+		if err != nil {
+			respuestas.RespondError(c, http.StatusUnsupportedMediaType, "La imagen está dañada o no se puede leer: "+err.Error())
+			return
+		}
+	}
+
+	suma := sha256.Sum256(contenido)
+	checksum := hex.EncodeToString(suma[:])
+
+	var existente models.Adjunto
+	if err := initializers.GetDB().
+		Where("persona_id = ? AND sha256 = ? AND eliminado_en IS NULL", personaID, checksum).
+		Limit(1).Find(&existente).Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al verificar duplicados: "+err.Error())
+		return
+	}
+	if existente.ID != 0 {
+		c.JSON(http.StatusConflict, gin.H{
+			"success": false,
+			"error":   "El archivo ya está en el expediente",
+			"adjunto": existente,
+		})
+		return
+	}
+
+	almacen, err := almacenamiento.Configurado()
+	if err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Almacenamiento no disponible: "+err.Error())
+		return
+	}
+
+	// Por contenido: volver a subir el mismo archivo no duplica el almacenamiento
+	clave := fmt.Sprintf("%d/%s%s", personaID, checksum, extension)
+	if err := almacen.Guardar(c.Request.Context(), clave, contenido, tipo); err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al guardar archivo: "+err.Error())
+		return
+	}
+	if miniatura != nil {
+		if err := almacen.Guardar(c.Request.Context(), clave+".miniatura.jpg", miniatura, "image/jpeg"); err != nil {
+			log.Printf("adjuntos: no se guardó la miniatura de %s: %v", clave, err)
+			miniatura = nil
+		}
+	}
+
+	nombre := strings.TrimSpace(filepath.Base(archivo.Filename))
+	if nombre == "" || nombre == "." || nombre == string(filepath.Separator) {
+		nombre = "archivo" + extension
+	}
+	if len(nombre) > 255 {
+		nombre = strings.ToValidUTF8(nombre[len(nombre)-255:], "")
+	}
+
+	adjunto := models.Adjunto{
+		PersonaID:   personaID,
+		CitaID:      citaID,
+		Categoria:   categoria,
+		Nombre:      nombre,
+		Descripcion: strings.TrimSpace(c.PostForm("descripcion")),
+		TipoMIME:    tipo,
+		Tamano:      int64(len(contenido)),
+		SHA256:      checksum,
+		Clave:       clave,
+		Miniatura:   miniatura != nil,
+		Almacen:     almacen.Nombre(),
+		SubidoPor:   usuarioID,
+	}
+	if err := initializers.GetDB().Create(&adjunto).Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al registrar adjunto: "+err.Error())
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusCreated, adjunto)
+}
+
+// Descarga un objeto del almacén; con checksum, verifica que no se haya alterado
+func abrirAdjunto(c *gin.Context, clave, checksum string) ([]byte, bool) {
+	almacen, err := almacenamiento.Configurado()
+	if err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Almacenamiento no disponible: "+err.Error())
+		return nil, false
+	}
+	contenido, err := almacen.Abrir(c.Request.Context(), clave)
+	if err != nil {
+		if errors.Is(err, almacenamiento.ErrNoExiste) {
+			respuestas.RespondError(c, http.StatusNotFound, "El archivo ya no está en el almacén")
+		} else {
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al leer archivo: "+err.Error())
+		}
+		return nil, false
+	}
+	if checksum != "" {
+		suma := sha256.Sum256(contenido)
+		if hex.EncodeToString(suma[:]) != checksum {
+			log.Printf("adjuntos: %s no coincide con su checksum", clave)
+			respuestas.RespondError(c, http.StatusInternalServerError, "El archivo no coincide con su checksum")
+			return nil, false
+		}
+	}
+	return contenido, true
+}
+
+// Descargar el archivo (?inline=1 para mostrarlo en el navegador)
+func GetAdjuntoArchivo(c *gin.Context) {
+	adjunto, ok := adjuntoAccesible(c)
+	if !ok {
+		return
+	}
+
+	contenido, ok := abrirAdjunto(c, adjunto.Clave, adjunto.SHA256)
+	if !ok {
+		return
+	}
+
+	disposicion := "attachment"
+	if c.Query("inline") == "1" {
+		disposicion = "inline"
+	}
+	c.Header("Content-Disposition", mime.FormatMediaType(disposicion, map[string]string{"filename": adjunto.Nombre}))
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Header("Cache-Control", "private, no-store")
+	c.Header("ETag", `"`+adjunto.SHA256+`"`)
+	c.Data(http.StatusOK, adjunto.TipoMIME, contenido)
+}
+
+// Miniatura JPEG de una imagen
+func GetAdjuntoMiniatura(c *gin.Context) {
+	adjunto, ok := adjuntoAccesible(c)
+	if !ok {
+		return
+	}
+	if !adjunto.Miniatura {
+		respuestas.RespondError(c, http.StatusNotFound, "El adjunto no tiene miniatura")
+		return
+	}
+
+	contenido, ok := abrirAdjunto(c, adjunto.Clave+".miniatura.jpg", "")
+	if !ok {
+		return
+	}
+
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Header("Cache-Control", "private, max-age=3600")
+	c.Data(http.StatusOK, "image/jpeg", contenido)
+}
+
+// Retirar un adjunto del expediente (quien lo subió o un administrador). El
+// archivo se conserva en el almacén.
+func DeleteAdjunto(c *gin.Context) {
+	adjunto, ok := adjuntoAccesible(c)
+	if !ok {
+		return
+	}
+	usuarioID, _ := usuarioActualID(c)
+	if c.GetString("userRol") != "administrador" && adjunto.SubidoPor != usuarioID {
+		respuestas.RespondError(c, http.StatusForbidden, "Solo quien subió el archivo puede retirarlo")
+		return
+	}
+
+	if err := initializers.GetDB().Model(&adjunto).Updates(map[string]interface{}{
+		"eliminado_en":  time.Now(),
+		"eliminado_por": usuarioID,
+	}).Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al retirar adjunto: "+err.Error())
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusOK, gin.H{"message": "Adjunto retirado del expediente"})
+}
@@ -0,0 +1,569 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Ilimm9/CMedicas/Respuestas"
+	"github.com/Ilimm9/CMedicas/initializers"
+	"github.com/Ilimm9/CMedicas/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type AlergiaInput struct {
+	Sustancia     string `json:"sustancia" binding:"required,max=200"`
+	MedicamentoID *uint  `json:"medicamento_id"`
+	Reaccion      string `json:"reaccion"`
+	Severidad     string `json:"severidad" binding:"required,oneof=leve moderada grave"`
+	Activa        *bool  `json:"activa"`
+}
+
+type CondicionCronicaInput struct {
+	Nombre string `json:"nombre" binding:"required,max=200"`
+	// Código CIE-10 opcional ("E11.9")
+	Codigo          string `json:"codigo"`
+	DiagnosticadaEn string `json:"diagnosticada_en"` // AAAA-MM-DD
+	Notas           string `json:"notas"`
+	Activa          *bool  `json:"activa"`
+}
+
+type MedicacionActualInput struct {
+	Nombre        string `json:"nombre" binding:"required,max=200"`
+	MedicamentoID *uint  `json:"medicamento_id"`
+	Dosis         string `json:"dosis" binding:"max=100"`
+	Frecuencia    string `json:"frecuencia" binding:"max=100"`
+	Via           string `json:"via" binding:"omitempty,oneof=oral sublingual tópica oftálmica ótica nasal inhalada rectal vaginal transdérmica intramuscular intravenosa subcutánea"`
+	Desde         string `json:"desde"` // AAAA-MM-DD
+	Activa        *bool  `json:"activa"`
+}
+
+type ContactoEmergenciaInput struct {
+	Nombre     string `json:"nombre" binding:"required,max=200"`
+	Parentesco string `json:"parentesco" binding:"max=50"`
+	Telefono   string `json:"telefono" binding:"required,max=20"`
+	Correo     string `json:"correo" binding:"omitempty,email,max=100"`
+	Prioridad  int    `json:"prioridad" binding:"omitempty,min=1"`
+}
+
+// Carga los antecedentes de una persona: alergias, condiciones y medicación
+// activas, y contactos de emergencia
+func cargarAntecedentes(db *gorm.DB, persona *models.Persona) error {
+	if err := db.Where("persona_id = ? AND activa", persona.ID).Order("severidad = 'grave' DESC, sustancia").Find(&persona.Alergias).Error; err != nil {
+		return err
+	}
+	if err := db.Preload("Catalogo").Where("persona_id = ? AND activa", persona.ID).Order("nombre").Find(&persona.CondicionesCronicas).Error; err != nil {
+		return err
+	}
+	if err := db.Where("persona_id = ? AND activa", persona.ID).Order("nombre").Find(&persona.MedicacionActual).Error; err != nil {
+		return err
+	}
+	return db.Where("persona_id = ?", persona.ID).Order("prioridad, id").Find(&persona.ContactosEmergencia).Error
+}
+
+// Verifica el acceso a los antecedentes de :persona_id. Los datos clínicos solo
+// los editan los médicos tratantes y los administradores; los contactos de
+// emergencia también el propio paciente o su tutor.
+func accesoAntecedentes(c *gin.Context, edicionClinica bool) (uint, bool) {
+	id, err := strconv.Atoi(c.Param("persona_id"))
+	if err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, "ID inválido")
+		return 0, false
+	}
+	personaID := uint(id)
+
+	permitido, err := puedeVerHistorial(c, personaID)
+	if err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al verificar acceso: "+err.Error())
+		return 0, false
+	}
+	if !permitido {
+		respuestas.RespondError(c, http.StatusNotFound, "Paciente no encontrado")
+		return 0, false
+	}
+	if edicionClinica && c.GetString("userRol") == "paciente" {
+		respuestas.RespondError(c, http.StatusForbidden, "Solo el médico puede modificar los antecedentes clínicos")
+		return 0, false
+	}
+	return personaID, true
+}
+
+// Busca en destino el registro :id de la persona
+func antecedenteDePersona(c *gin.Context, personaID uint, destino interface{}) bool {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, "ID inválido")
+		return false
+	}
+	if err := initializers.GetDB().Where("persona_id = ?", personaID).First(destino, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			respuestas.RespondError(c, http.StatusNotFound, "Registro no encontrado")
+		} else {
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al buscar registro: "+err.Error())
+		}
+		return false
+	}
+	return true
+}
+
+func fechaOpcional(valor string) (*time.Time, error) {
+	if valor == "" {
+		return nil, nil
+	}
+	fecha, err := time.Parse("2006-01-02", valor)
+	if err != nil {
+		return nil, fmt.Errorf("fecha inválida, use AAAA-MM-DD")
+	}
+	return &fecha, nil
+}
+
+// Obtener los antecedentes clínicos de una persona
+func GetAntecedentes(c *gin.Context) {
+	personaID, ok := accesoAntecedentes(c, false)
+	if !ok {
+		return
+	}
+
+	var persona models.Persona
+	if err := initializers.GetDB().First(&persona, personaID).Error; err != nil {
+		respuestas.RespondError(c, http.StatusNotFound, "Paciente no encontrado")
+		return
+	}
+	if err := cargarAntecedentes(initializers.GetDB(), &persona); err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al obtener antecedentes: "+err.Error())
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusOK, gin.H{
+		"tipo_sangre":          persona.TipoSangre,
+		"alergias":             persona.Alergias,
+		"condiciones_cronicas": persona.CondicionesCronicas,
+		"medicacion_actual":    persona.MedicacionActual,
+		"contactos_emergencia": persona.ContactosEmergencia,
+	})
+}
+
+// Registrar el tipo de sangre
+func UpdateTipoSangre(c *gin.Context) {
+	personaID, ok := accesoAntecedentes(c, true)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		TipoSangre string `json:"tipo_sangre" binding:"required,oneof=A+ A- B+ B- AB+ AB- O+ O-"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := initializers.GetDB().Model(&models.Persona{}).Where("id = ?", personaID).Update("tipo_sangre", input.TipoSangre).Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al guardar tipo de sangre: "+err.Error())
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusOK, gin.H{"tipo_sangre": input.TipoSangre})
+}
+
+// ---------------- Alergias ----------------
+
+func aplicarAlergia(alergia *models.Alergia, input AlergiaInput) {
+	alergia.Sustancia = strings.TrimSpace(input.Sustancia)
+	alergia.MedicamentoID = input.MedicamentoID
+	alergia.Reaccion = input.Reaccion
+	alergia.Severidad = input.Severidad
+	if input.Activa != nil {
+		alergia.Activa = *input.Activa
+	}
+}
+
+func PostAlergia(c *gin.Context) {
+	personaID, ok := accesoAntecedentes(c, true)
+	if !ok {
+		return
+	}
+	var input AlergiaInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	alergia := models.Alergia{PersonaID: personaID, Activa: true, RegistradaPor: actorActual(c)}
+	aplicarAlergia(&alergia, input)
+	if err := initializers.GetDB().Create(&alergia).Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al guardar alergia: "+err.Error())
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusCreated, alergia)
+}
+
+func UpdateAlergia(c *gin.Context) {
+	personaID, ok := accesoAntecedentes(c, true)
+	if !ok {
+		return
+	}
+	var input AlergiaInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var alergia models.Alergia
+	if !antecedenteDePersona(c, personaID, &alergia) {
+		return
+	}
+	aplicarAlergia(&alergia, input)
+	if err := initializers.GetDB().Save(&alergia).Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al actualizar alergia: "+err.Error())
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusOK, alergia)
+}
+
+// Las alergias no se borran: se desactivan para conservar el antecedente
+func DeleteAlergia(c *gin.Context) {
+	personaID, ok := accesoAntecedentes(c, true)
+	if !ok {
+		return
+	}
+	var alergia models.Alergia
+	if !antecedenteDePersona(c, personaID, &alergia) {
+		return
+	}
+	if err := initializers.GetDB().Model(&alergia).Update("activa", false).Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al desactivar alergia: "+err.Error())
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusOK, gin.H{"message": "Alergia desactivada"})
+}
+
+// ---------------- Condiciones crónicas ----------------
+
+func aplicarCondicionCronica(condicion *models.CondicionCronica, input CondicionCronicaInput) (int, string) {
+	diagnosticada, err := fechaOpcional(input.DiagnosticadaEn)
+	if err != nil {
+		return http.StatusBadRequest, err.Error()
+	}
+
+	condicion.CatalogoDiagnosticoID = nil
+	condicion.Catalogo = nil
+	if input.Codigo != "" {
+		codigo, ok := normalizarCodigoCIE10(input.Codigo)
+		if !ok {
+			return http.StatusBadRequest, "Código CIE-10 inválido"
+		}
+		var catalogo models.CatalogoDiagnostico
+		if err := initializers.GetDB().Where("codigo = ?", codigo).Limit(1).Find(&catalogo).Error; err != nil {
+			return http.StatusInternalServerError, "Error al buscar diagnóstico: " + err.Error()
+		}
+		if catalogo.ID == 0 {
+			return http.StatusBadRequest, "El código " + codigo + " no está en el catálogo"
+		}
+		condicion.CatalogoDiagnosticoID = &catalogo.ID
+		condicion.Catalogo = &catalogo
+	}
+
+	condicion.Nombre = strings.TrimSpace(input.Nombre)
+	condicion.DiagnosticadaEn = diagnosticada
+	condicion.Notas = input.Notas
+	if input.Activa != nil {
+		condicion.Activa = *input.Activa
+	}
+	return 0, ""
+}
+
+func PostCondicionCronica(c *gin.Context) {
+	personaID, ok := accesoAntecedentes(c, true)
+	if !ok {
+		return
+	}
+	var input CondicionCronicaInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	condicion := models.CondicionCronica{PersonaID: personaID, Activa: true, RegistradaPor: actorActual(c)}
+	if estado, mensaje := aplicarCondicionCronica(&condicion, input); estado != 0 {
+		respuestas.RespondError(c, estado, mensaje)
+		return
+	}
+	if err := initializers.GetDB().Omit("Catalogo").Create(&condicion).Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al guardar condición: "+err.Error())
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusCreated, condicion)
+}
+
+func UpdateCondicionCronica(c *gin.Context) {
+	personaID, ok := accesoAntecedentes(c, true)
+	if !ok {
+		return
+	}
+	var input CondicionCronicaInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var condicion models.CondicionCronica
+	if !antecedenteDePersona(c, personaID, &condicion) {
+		return
+	}
+	if estado, mensaje := aplicarCondicionCronica(&condicion, input); estado != 0 {
+		respuestas.RespondError(c, estado, mensaje)
+		return
+	}
+	if err := initializers.GetDB().Omit("Catalogo").Save(&condicion).Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al actualizar condición: "+err.Error())
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusOK, condicion)
+}
+
+// Marca la condición como inactiva (resuelta o registrada por error)
+func DeleteCondicionCronica(c *gin.Context) {
+	personaID, ok := accesoAntecedentes(c, true)
+	if !ok {
+		return
+	}
+	var condicion models.CondicionCronica
+	if !antecedenteDePersona(c, personaID, &condicion) {
+		return
+	}
+	if err := initializers.GetDB().Model(&condicion).Update("activa", false).Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al desactivar condición: "+err.Error())
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusOK, gin.H{"message": "Condición desactivada"})
+}
+
+// ---------------- Medicación actual ----------------
+
+func aplicarMedicacionActual(medicacion *models.MedicacionActual, input MedicacionActualInput) error {
+	desde, err := fechaOpcional(input.Desde)
+	if err != nil {
+		return err
+	}
+	medicacion.Nombre = strings.TrimSpace(input.Nombre)
+	medicacion.MedicamentoID = input.MedicamentoID
+	medicacion.Dosis = input.Dosis
+	medicacion.Frecuencia = input.Frecuencia
+	medicacion.Via = input.Via
+	medicacion.Desde = desde
+	if input.Activa != nil {
+		medicacion.Activa = *input.Activa
+	}
+	return nil
+}
+
+func PostMedicacionActual(c *gin.Context) {
+	personaID, ok := accesoAntecedentes(c, true)
+	if !ok {
+		return
+	}
+	var input MedicacionActualInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	medicacion := models.MedicacionActual{PersonaID: personaID, Activa: true, RegistradaPor: actorActual(c)}
+	if err := aplicarMedicacionActual(&medicacion, input); err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := initializers.GetDB().Create(&medicacion).Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al guardar medicación: "+err.Error())
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusCreated, medicacion)
+}
+
+func UpdateMedicacionActual(c *gin.Context) {
+	personaID, ok := accesoAntecedentes(c, true)
+	if !ok {
+		return
+	}
+	var input MedicacionActualInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var medicacion models.MedicacionActual
+	if !antecedenteDePersona(c, personaID, &medicacion) {
+		return
+	}
+	if err := aplicarMedicacionActual(&medicacion, input); err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := initializers.GetDB().Save(&medicacion).Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al actualizar medicación: "+err.Error())
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusOK, medicacion)
+}
+
+// Marca la medicación como suspendida
+func DeleteMedicacionActual(c *gin.Context) {
+	personaID, ok := accesoAntecedentes(c, true)
+	if !ok {
+		return
+	}
+	var medicacion models.MedicacionActual
+	if !antecedenteDePersona(c, personaID, &medicacion) {
+		return
+	}
+	if err := initializers.GetDB().Model(&medicacion).Update("activa", false).Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al suspender medicación: "+err.Error())
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusOK, gin.H{"message": "Medicación suspendida"})
+}
+
+// ---------------- Contactos de emergencia ----------------
+
+func aplicarContactoEmergencia(contacto *models.ContactoEmergencia, input ContactoEmergenciaInput) {
+	contacto.Nombre = strings.TrimSpace(input.Nombre)
+	contacto.Parentesco = input.Parentesco
+	contacto.Telefono = strings.TrimSpace(input.Telefono)
+	contacto.Correo = input.Correo
+	contacto.Prioridad = input.Prioridad
+	if contacto.Prioridad == 0 {
+		contacto.Prioridad = 1
+	}
+}
+
+func PostContactoEmergencia(c *gin.Context) {
+	personaID, ok := accesoAntecedentes(c, false)
+	if !ok {
+		return
+	}
+	var input ContactoEmergenciaInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	contacto := models.ContactoEmergencia{PersonaID: personaID}
+	aplicarContactoEmergencia(&contacto, input)
+	if err := initializers.GetDB().Create(&contacto).Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al guardar contacto: "+err.Error())
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusCreated, contacto)
+}
+
+func UpdateContactoEmergencia(c *gin.Context) {
+	personaID, ok := accesoAntecedentes(c, false)
+	if !ok {
+		return
+	}
+	var input ContactoEmergenciaInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var contacto models.ContactoEmergencia
+	if !antecedenteDePersona(c, personaID, &contacto) {
+		return
+	}
+	aplicarContactoEmergencia(&contacto, input)
+	if err := initializers.GetDB().Save(&contacto).Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al actualizar contacto: "+err.Error())
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusOK, contacto)
+}
+
+func DeleteContactoEmergencia(c *gin.Context) {
+	personaID, ok := accesoAntecedentes(c, false)
+	if !ok {
+		return
+	}
+	var contacto models.ContactoEmergencia
+	if !antecedenteDePersona(c, personaID, &contacto) {
+		return
+	}
+	if err := initializers.GetDB().Delete(&contacto).Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al eliminar contacto: "+err.Error())
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusOK, gin.H{"message": "Contacto eliminado"})
+}
+
+// ---------------- Advertencias al recetar ----------------
+
+// Minúsculas y sin acentos, para comparar nombres de sustancias
+func normalizarNombre(texto string) string {
+	return strings.NewReplacer("á", "a", "é", "e", "í", "i", "ó", "o", "ú", "u", "ü", "u", "ñ", "n").
+		Replace(strings.ToLower(strings.TrimSpace(texto)))
+}
+
+func mismaSustancia(a, b string) bool {
+	a, b = normalizarNombre(a), normalizarNombre(b)
+	return a != "" && b != "" && (strings.Contains(a, b) || strings.Contains(b, a))
+}
+
+// Advertencias de una receta contra los antecedentes del paciente: alergias
+// activas al medicamento y medicamentos que ya toma
+func advertenciasReceta(db *gorm.DB, personaID uint, medicamentos []models.RecetaMedicamento) ([]string, error) {
+	persona := models.Persona{ID: personaID}
+	if err := cargarAntecedentes(db, &persona); err != nil {
+		return nil, err
+	}
+
+	var advertencias []string
+	for _, m := range medicamentos {
+		for _, a := range persona.Alergias {
+			mismoCatalogo := a.MedicamentoID != nil && m.MedicamentoID != nil && *a.MedicamentoID == *m.MedicamentoID
+			if !mismoCatalogo && !mismaSustancia(a.Sustancia, m.Nombre) {
+				continue
+			}
+			aviso := fmt.Sprintf("Alergia %s a %s: %s", a.Severidad, a.Sustancia, m.Nombre)
+			if a.Reaccion != "" {
+				aviso += " (reacción: " + a.Reaccion + ")"
+			}
+			advertencias = append(advertencias, aviso)
+		}
+		for _, actual := range persona.MedicacionActual {
+			mismoCatalogo := actual.MedicamentoID != nil && m.MedicamentoID != nil && *actual.MedicamentoID == *m.MedicamentoID
+			if mismoCatalogo || mismaSustancia(actual.Nombre, m.Nombre) {
+				advertencias = append(advertencias, fmt.Sprintf("El paciente ya toma %s %s", actual.Nombre, actual.Dosis))
+			}
+		}
+	}
+	return advertencias, nil
+}
+
+// Persona atendida en una cita: el dependiente o el titular de la cuenta
+func personaDeCita(db *gorm.DB, cita models.Cita) (uint, error) {
+	if cita.PersonaPacienteID != nil {
+		return *cita.PersonaPacienteID, nil
+	}
+	var usuario models.Usuario
+	if err := db.Select("id", "persona_id").First(&usuario, cita.PacienteID).Error; err != nil {
+		return 0, err
+	}
+	return usuario.PersonaID, nil
+}
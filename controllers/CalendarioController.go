@@ -0,0 +1,236 @@
+package controllers
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Ilimm9/CMedicas/Respuestas"
+	"github.com/Ilimm9/CMedicas/clave"
+	"github.com/Ilimm9/CMedicas/initializers"
+	"github.com/Ilimm9/CMedicas/mensajeria"
+	"github.com/Ilimm9/CMedicas/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+type FeedCalendarioInput struct {
+	Nombre     string `json:"nombre" binding:"max=100"`
+	Privacidad string `json:"privacidad" binding:"omitempty,oneof=ocupado reducida completa"`
+}
+
+// URL pública del feed (API_URL o, si no está configurada, el host de la petición)
+func urlFeedCalendario(c *gin.Context, token string) string {
+	base := strings.TrimSuffix(os.Getenv("API_URL"), "/")
+	if base == "" {
+		esquema := "http"
+		if c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https" {
+			esquema = "https"
+		}
+		base = esquema + "://" + c.Request.Host
+	}
+	return base + "/api/calendario/" + token + ".ics"
+}
+
+// Solo médicos y pacientes tienen citas propias que publicar
+func puedeTenerFeed(c *gin.Context) bool {
+	rol := c.GetString("userRol")
+	return rol == "medico" || rol == "paciente"
+}
+
+// Feed vigente del usuario autenticado indicado en :id
+func feedPropio(c *gin.Context) (models.FeedCalendario, bool) {
+	var feed models.FeedCalendario
+
+	usuarioID, ok := usuarioActualID(c)
+	if !ok {
+		respuestas.RespondError(c, http.StatusUnauthorized, "Usuario no autenticado")
+		return feed, false
+	}
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, "ID inválido")
+		return feed, false
+	}
+
+	if err := initializers.GetDB().
+		Where("id = ? AND usuario_id = ? AND revocado_en IS NULL", id, usuarioID).
+		Limit(1).Find(&feed).Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al buscar calendario: "+err.Error())
+		return feed, false
+	}
+	if feed.ID == 0 {
+		respuestas.RespondError(c, http.StatusNotFound, "Calendario no encontrado")
+		return feed, false
+	}
+	return feed, true
+}
+
+// Listar los feeds de calendario vigentes del usuario autenticado (sin la URL:
+// el token solo se muestra al crearlo o regenerarlo)
+func GetFeedsCalendario(c *gin.Context) {
+	usuarioID, ok := usuarioActualID(c)
+	if !ok {
+		respuestas.RespondError(c, http.StatusUnauthorized, "Usuario no autenticado")
+		return
+	}
+
+	var feeds []models.FeedCalendario
+	if err := initializers.GetDB().
+		Where("usuario_id = ? AND revocado_en IS NULL", usuarioID).
+		Order("creado_en").
+		Find(&feeds).Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al obtener calendarios: "+err.Error())
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusOK, feeds)
+}
+
+// Crear una URL de suscripción iCalendar con las citas del usuario
+func PostFeedCalendario(c *gin.Context) {
+	usuarioID, ok := usuarioActualID(c)
+	if !ok {
+		respuestas.RespondError(c, http.StatusUnauthorized, "Usuario no autenticado")
+		return
+	}
+	if !puedeTenerFeed(c) {
+		respuestas.RespondError(c, http.StatusForbidden, "Solo médicos y pacientes pueden suscribirse a sus citas")
+		return
+	}
+
+	var input FeedCalendarioInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	token, err := clave.GenerarTokenAleatorio()
+	if err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al generar token: "+err.Error())
+		return
+	}
+
+	feed := models.FeedCalendario{
+		UsuarioID:  usuarioID,
+		Nombre:     input.Nombre,
+		TokenHash:  clave.HashToken(token),
+		Privacidad: input.Privacidad,
+	}
+	if feed.Nombre == "" {
+		feed.Nombre = "Citas"
+	}
+	if feed.Privacidad == "" {
+		feed.Privacidad = "reducida"
+	}
+	if err := initializers.GetDB().Create(&feed).Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al crear calendario: "+err.Error())
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusCreated, gin.H{"feed": feed, "url": urlFeedCalendario(c, token)})
+}
+
+// Cambiar el nombre o la privacidad de un feed; la URL no cambia
+func UpdateFeedCalendario(c *gin.Context) {
+	feed, ok := feedPropio(c)
+	if !ok {
+		return
+	}
+
+	var input FeedCalendarioInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if input.Nombre != "" {
+		feed.Nombre = input.Nombre
+	}
+	if input.Privacidad != "" {
+		feed.Privacidad = input.Privacidad
+	}
+	if err := initializers.GetDB().Model(&feed).Updates(map[string]interface{}{
+		"nombre":     feed.Nombre,
+		"privacidad": feed.Privacidad,
+	}).Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al actualizar calendario: "+err.Error())
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusOK, feed)
+}
+
+// Emitir una URL nueva para el feed; la anterior deja de funcionar
+func RegenerarFeedCalendario(c *gin.Context) {
+	feed, ok := feedPropio(c)
+	if !ok {
+		return
+	}
+
+	token, err := clave.GenerarTokenAleatorio()
+	if err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al generar token: "+err.Error())
+		return
+	}
+	if err := initializers.GetDB().Model(&feed).Update("token_hash", clave.HashToken(token)).Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al regenerar calendario: "+err.Error())
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusOK, gin.H{"feed": feed, "url": urlFeedCalendario(c, token)})
+}
+
+// Revocar un feed: la URL deja de responder
+func DeleteFeedCalendario(c *gin.Context) {
+	feed, ok := feedPropio(c)
+	if !ok {
+		return
+	}
+
+	if err := initializers.GetDB().Model(&feed).Update("revocado_en", time.Now()).Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al revocar calendario: "+err.Error())
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusOK, gin.H{"message": "Calendario revocado"})
+}
+
+// Feed iCalendar público (/calendario/<token>.ics) que consultan Google,
+// Outlook, etc. sin iniciar sesión
+func GetFeedCalendarioICS(c *gin.Context) {
+	token := strings.TrimSuffix(c.Param("archivo"), ".ics")
+
+	var feed models.FeedCalendario
+	if err := initializers.GetDB().
+		Where("token_hash = ? AND revocado_en IS NULL", clave.HashToken(token)).
+		Limit(1).Find(&feed).Error; err != nil {
+		c.String(http.StatusInternalServerError, "Error al buscar calendario")
+		return
+	}
+	if feed.ID == 0 {
+		c.String(http.StatusNotFound, "Calendario no encontrado")
+		return
+	}
+
+	// Un usuario deshabilitado no publica su agenda aunque el feed siga vigente
+	var usuario models.Usuario
+	if err := initializers.GetDB().Select("id", "activo").First(&usuario, feed.UsuarioID).Error; err != nil || !usuario.Activo {
+		c.String(http.StatusNotFound, "Calendario no encontrado")
+		return
+	}
+
+	ics, err := mensajeria.FeedCitas(initializers.GetDB(), feed)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Error al generar calendario")
+		return
+	}
+
+	initializers.GetDB().Model(&feed).Update("ultimo_acceso", time.Now())
+
+	c.Header("Cache-Control", "private, max-age=300")
+	c.Header("Content-Disposition", `inline; filename="citas.ics"`)
+	c.Data(http.StatusOK, "text/calendar; charset=utf-8", ics)
+}
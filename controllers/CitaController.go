@@ -6,6 +6,8 @@ import (
 	"time"
 
 	"github.com/Ilimm9/CMedicas/Respuestas"
+	"github.com/Ilimm9/CMedicas/agenda"
+	"github.com/Ilimm9/CMedicas/eventos"
 	"github.com/Ilimm9/CMedicas/initializers"
 	"github.com/Ilimm9/CMedicas/models"
 
@@ -14,10 +16,36 @@ import (
 )
 
 type CitaInput struct {
-	PacienteID uint      `json:"paciente_id" binding:"required"`
-	MedicoID   uint      `json:"medico_id" binding:"required"`
-	FechaCita  time.Time `json:"fecha_cita" binding:"required"`
-	Motivo     string    `json:"motivo" binding:"required,max=500"`
+	PacienteID uint `json:"paciente_id" binding:"required"`
+	// Opcional: la cita es para un dependiente del paciente (cuenta responsable)
+	DependienteID *uint     `json:"dependiente_id"`
+	MedicoID      uint      `json:"medico_id" binding:"required"`
+	FechaCita     time.Time `json:"fecha_cita" binding:"required"`
+	Motivo        string    `json:"motivo" binding:"required,max=500"`
+}
+
+// Bloquea al médico y verifica que la fecha no se traslape con otra de sus
+// citas; si no, revierte la transacción y responde el error. Aplica a toda
+// reserva (PostCita, UpdateCita y las referencias), no solo a las de
+// disponibilidad: una cita traslapada se rechaza con 409.
+func citaSinTraslape(c *gin.Context, tx *gorm.DB, medicoID uint, fecha time.Time, citaID uint) bool {
+	if err := agenda.BloquearMedico(tx, medicoID); err != nil {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al verificar médico: "+err.Error())
+		return false
+	}
+	traslapada, err := agenda.Traslapada(tx, medicoID, fecha, citaID)
+	if err != nil {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al verificar disponibilidad: "+err.Error())
+		return false
+	}
+	if traslapada {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusConflict, "El médico ya tiene una cita en ese horario")
+		return false
+	}
+	return true
 }
 
 // Crear una nueva cita
@@ -40,6 +68,31 @@ func PostCita(c *gin.Context) {
 		return
 	}
 
+	// Un paciente solo agenda para sí mismo o para sus dependientes
+	if c.GetString("userRol") == "paciente" {
+		if userID, _ := usuarioActualID(c); userID != input.PacienteID {
+			respuestas.RespondError(c, http.StatusForbidden, "Solo puede agendar citas para usted o sus dependientes")
+			return
+		}
+	}
+
+	// El dependiente debe estar a cargo del paciente indicado
+	var personaPacienteID *uint
+	if input.DependienteID != nil {
+		var dependiente models.Dependiente
+		if err := initializers.GetDB().
+			Where("tutor_id = ? AND activa = ?", input.PacienteID, true).
+			First(&dependiente, *input.DependienteID).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				respuestas.RespondError(c, http.StatusBadRequest, "Dependiente no encontrado")
+			} else {
+				respuestas.RespondError(c, http.StatusInternalServerError, "Error al verificar dependiente: "+err.Error())
+			}
+			return
+		}
+		personaPacienteID = &dependiente.PersonaID
+	}
+
 	// Verificar que el médico existe
 	var medico models.Medico
 	if err := initializers.GetDB().First(&medico, input.MedicoID).Error; err != nil {
@@ -63,12 +116,18 @@ func PostCita(c *gin.Context) {
 		return
 	}
 
+	// Con el médico bloqueado, otra reserva simultánea no toma el mismo espacio
+	if !citaSinTraslape(c, tx, input.MedicoID, input.FechaCita, 0) {
+		return
+	}
+
 	cita := models.Cita{
-		PacienteID: input.PacienteID,
-		MedicoID:   input.MedicoID,
-		FechaCita:  input.FechaCita,
-		Motivo:     input.Motivo,
-		Estado:     "programada",
+		PacienteID:        input.PacienteID,
+		PersonaPacienteID: personaPacienteID,
+		MedicoID:          input.MedicoID,
+		FechaCita:         input.FechaCita,
+		Motivo:            input.Motivo,
+		Estado:            "programada",
 	}
 
 	if err := tx.Create(&cita).Error; err != nil {
@@ -77,26 +136,26 @@ func PostCita(c *gin.Context) {
 		return
 	}
 
+	// Confirmación al paciente y al médico (ver mensajeria.RegistrarManejadores)
+	actorID, _ := usuarioActualID(c)
+	creada := eventos.Evento{Tipo: eventos.CitaCreada, Cita: cita, ActorID: actorID}
+	if err := eventos.Emitir(tx, creada); err != nil {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al registrar evento de cita: "+err.Error())
+		return
+	}
+
 	if err := tx.Commit().Error; err != nil {
 		respuestas.RespondError(c, http.StatusInternalServerError, "Error al confirmar transacción: "+err.Error())
 		return
 	}
-
-
-// 	Enviar correo de cnfirmacion de cita creada exitosamente, o solo mostrar notificacion en app?
-// correo := notificacion.Usuario.Correo // Asegúrate de que esto esté cargado correctamente
-
-// err := clave.EnviarCorreo(correo, "Notificación "+notificacion.Tipo, notificacion.Mensaje)
-// if err != nil {
-//     // Puedes loguearlo, pero no es necesario cortar el flujo
-//     fmt.Println("Error al enviar correo:", err)
-// }
-
+	eventos.Confirmados(creada)
 
 	// Cargar relaciones para la respuesta
 	if err := initializers.GetDB().
 		Preload("Paciente").
 		Preload("Paciente.Persona").
+		Preload("PersonaPaciente").
 		Preload("Medico").
 		Preload("Medico.Usuario").
 		Preload("Medico.Usuario.Persona").
@@ -121,6 +180,7 @@ func GetCita(c *gin.Context) {
 	result := initializers.GetDB().
 		Preload("Paciente").
 		Preload("Paciente.Persona").
+		Preload("PersonaPaciente").
 		Preload("Medico").
 		Preload("Medico.Usuario").
 		Preload("Medico.Usuario.Persona").
@@ -136,6 +196,19 @@ func GetCita(c *gin.Context) {
 		return
 	}
 
+	// Los antecedentes del paciente (alergias, condiciones, medicación) se
+	// muestran al abrir la cita solo a quien puede ver su historial
+	paciente := &cita.Paciente.Persona
+	if cita.PersonaPaciente != nil {
+		paciente = cita.PersonaPaciente
+	}
+	if permitido, err := puedeVerHistorial(c, paciente.ID); err == nil && permitido {
+		if err := cargarAntecedentes(initializers.GetDB(), paciente); err != nil {
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al obtener antecedentes: "+err.Error())
+			return
+		}
+	}
+
 	respuestas.RespondSuccess(c, http.StatusOK, cita)
 }
 
@@ -177,6 +250,7 @@ func GetCitasUsuarioActual(c *gin.Context) {
 	query := initializers.GetDB().
 		Preload("Paciente").
 		Preload("Paciente.Persona").
+		Preload("PersonaPaciente").
 		Preload("Medico").
 		Preload("Medico.Usuario").
 		Preload("Medico.Usuario.Persona")
@@ -237,6 +311,7 @@ func UpdateCita(c *gin.Context) {
 		FechaCita *time.Time `json:"fecha_cita"`
 		Motivo    string     `json:"motivo" binding:"max=500"`
 		Estado    string     `json:"estado" binding:"omitempty,oneof=programada cancelada completada"`
+		MedicoID  uint       `json:"medico_id"`
 	}
 
 	if err := c.ShouldBindJSON(&input); err != nil {
@@ -261,13 +336,32 @@ func UpdateCita(c *gin.Context) {
 		return
 	}
 
+	anterior := cita
+
 	// Actualizar solo info dada
+	if input.MedicoID != 0 && input.MedicoID != cita.MedicoID {
+		var medico models.Medico
+		if err := tx.First(&medico, input.MedicoID).Error; err != nil {
+			tx.Rollback()
+			if err == gorm.ErrRecordNotFound {
+				respuestas.RespondError(c, http.StatusBadRequest, "Médico no encontrado")
+			} else {
+				respuestas.RespondError(c, http.StatusInternalServerError, "Error al verificar médico: "+err.Error())
+			}
+			return
+		}
+		cita.MedicoID = medico.ID
+	}
 	if input.FechaCita != nil {
 		if input.FechaCita.Before(time.Now()) {
 			tx.Rollback()
 			respuestas.RespondError(c, http.StatusBadRequest, "La fecha de la cita debe ser futura")
 			return
 		}
+		if !input.FechaCita.Equal(cita.FechaCita) {
+			// La confirmación era para la fecha anterior
+			cita.ConfirmadaEn = nil
+		}
 		cita.FechaCita = *input.FechaCita
 	}
 	if input.Motivo != "" {
@@ -277,16 +371,33 @@ func UpdateCita(c *gin.Context) {
 		cita.Estado = input.Estado
 	}
 
+	movida := cita.MedicoID != anterior.MedicoID || !cita.FechaCita.Equal(anterior.FechaCita) ||
+		(anterior.Estado == "cancelada" && cita.Estado != "cancelada")
+	if movida && cita.Estado != "cancelada" && !citaSinTraslape(c, tx, cita.MedicoID, cita.FechaCita, cita.ID) {
+		return
+	}
+
 	if err := tx.Save(&cita).Error; err != nil {
 		tx.Rollback()
 		respuestas.RespondError(c, http.StatusInternalServerError, "Error al actualizar cita: "+err.Error())
 		return
 	}
 
+	actorID, _ := usuarioActualID(c)
+	cambios := eventos.CambiosCita(anterior, cita, actorID)
+	for _, ev := range cambios {
+		if err := eventos.Emitir(tx, ev); err != nil {
+			tx.Rollback()
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al registrar evento de cita: "+err.Error())
+			return
+		}
+	}
+
 	if err := tx.Commit().Error; err != nil {
 		respuestas.RespondError(c, http.StatusInternalServerError, "Error al confirmar transacción: "+err.Error())
 		return
 	}
+	eventos.Confirmados(cambios...)
 
 	// Cargar datos actualizados para la respuesta
 	if err := initializers.GetDB().
@@ -331,6 +442,45 @@ func DeleteCita(c *gin.Context) {
 		return
 	}
 
+	// El expediente clínico no se borra con la cita
+	if err := tx.Model(&models.Observacion{}).Where("cita_id = ?", id).Count(&count).Error; err != nil {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al verificar observaciones: "+err.Error())
+		return
+	}
+
+	if count > 0 {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusBadRequest, "No se puede eliminar, la cita tiene observación clínica; cancélela en su lugar")
+		return
+	}
+
+	if err := tx.Model(&models.Receta{}).Where("cita_id = ?", id).Count(&count).Error; err != nil {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al verificar recetas: "+err.Error())
+		return
+	}
+
+	if count > 0 {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusBadRequest, "No se puede eliminar, la cita tiene recetas emitidas; cancélela en su lugar")
+		return
+	}
+
+	// Cancelar la cita devuelve la referencia a pendiente; borrarla la dejaría
+	// agendada sin cita
+	if err := tx.Model(&models.Referencia{}).Where("cita_id = ? OR cita_origen_id = ?", id, id).Count(&count).Error; err != nil {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al verificar referencias: "+err.Error())
+		return
+	}
+
+	if count > 0 {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusBadRequest, "No se puede eliminar, la cita tiene referencias asociadas; cancélela en su lugar")
+		return
+	}
+
 	result := tx.Delete(&models.Cita{}, id)
 	if result.Error != nil {
 		tx.Rollback()
@@ -399,6 +549,37 @@ func GetCitasPorMedico(c *gin.Context) {
 	respuestas.RespondSuccess(c, http.StatusOK, citas)
 }
 
+// Reglas para cancelar una cita, compartidas por CancelarCita y el enlace del
+// recordatorio. Devuelve 0 si se puede cancelar.
+func validarCancelacion(cita models.Cita) (int, string) {
+	// Validar que la cita no esté ya cancelada o completada
+	if cita.Estado == "cancelada" {
+		return http.StatusBadRequest, "La cita ya está cancelada"
+	}
+
+	if cita.Estado == "completada" {
+		return http.StatusBadRequest, "No se puede cancelar una cita ya completada"
+	}
+
+	// Validar que no se cancele con muy poca anticipación (< de 24 horas)
+	if time.Until(cita.FechaCita) < 24*time.Hour {
+		return http.StatusBadRequest, "No se puede cancelar con menos de 24 horas de anticipación"
+	}
+	return 0, ""
+}
+
+// Marca la cita como cancelada dentro de tx y emite el evento; quien llama debe
+// pasar el evento a eventos.Confirmados después del Commit
+func cancelarCita(tx *gorm.DB, cita *models.Cita, actorID uint) (eventos.Evento, error) {
+	if err := tx.Model(cita).Update("estado", "cancelada").Error; err != nil {
+		return eventos.Evento{}, err
+	}
+	cita.Estado = "cancelada"
+
+	ev := eventos.Evento{Tipo: eventos.CitaCancelada, Cita: *cita, ActorID: actorID}
+	return ev, eventos.Emitir(tx, ev)
+}
+
 // Cancelar una cita existente
 func CancelarCita(c *gin.Context) {
 	id, err := strconv.Atoi(c.Param("id"))
@@ -408,7 +589,7 @@ func CancelarCita(c *gin.Context) {
 	}
 
 	// informacion del usuario
-	userID, exists := c.Get("userID")
+	userID, exists := usuarioActualID(c)
 	if !exists {
 		respuestas.RespondError(c, http.StatusUnauthorized, "No se pudo identificar al usuario")
 		return
@@ -432,7 +613,7 @@ func CancelarCita(c *gin.Context) {
 	}
 
 	// Verificar permisos, el usuario o admi son los unicos que pueden cancelar
-	if cita.PacienteID != userID.(uint) {
+	if cita.PacienteID != userID {
 		userRol, _ := c.Get("userRol")
 		if userRol != "administrador" {
 			tx.Rollback()
@@ -441,53 +622,25 @@ func CancelarCita(c *gin.Context) {
 		}
 	}
 
-	// Validar que la cita no esté ya cancelada o completada
-	if cita.Estado == "cancelada" {
-		tx.Rollback()
-		respuestas.RespondError(c, http.StatusBadRequest, "La cita ya está cancelada")
-		return
-	}
-
-	if cita.Estado == "completada" {
-		tx.Rollback()
-		respuestas.RespondError(c, http.StatusBadRequest, "No se puede cancelar una cita ya completada")
-		return
-	}
-
-	// Validar que no se cancele con muy poca anticipación (< de 24 horas)
-	if time.Until(cita.FechaCita) < 24*time.Hour {
+	if estado, mensaje := validarCancelacion(cita); estado != 0 {
 		tx.Rollback()
-		respuestas.RespondError(c, http.StatusBadRequest, "No se puede cancelar con menos de 24 horas de anticipación")
+		respuestas.RespondError(c, estado, mensaje)
 		return
 	}
 
-	// Actualizar estado de la cita
-	cita.Estado = "cancelada"
-	if err := tx.Save(&cita).Error; err != nil {
+	// Cancela y notifica al paciente y al médico
+	cancelada, err := cancelarCita(tx, &cita, userID)
+	if err != nil {
 		tx.Rollback()
 		respuestas.RespondError(c, http.StatusInternalServerError, "Error al cancelar cita: "+err.Error())
 		return
 	}
 
-	// Crear notificación de cancelación
-	notificacion := models.Notificacion{
-		IDUsuario:  cita.PacienteID,
-		CitaID:     cita.ID,
-		Tipo:       "cancelación",
-		Mensaje:    "Su cita ha sido cancelada",
-		FechaEnvio: time.Now(),
-	}
-
-	if err := tx.Create(&notificacion).Error; err != nil {
-		tx.Rollback()
-		respuestas.RespondError(c, http.StatusInternalServerError, "Error al crear notificación: "+err.Error())
-		return
-	}
-
 	if err := tx.Commit().Error; err != nil {
 		respuestas.RespondError(c, http.StatusInternalServerError, "Error al confirmar transacción: "+err.Error())
 		return
 	}
+	eventos.Confirmados(cancelada)
 
 	if err := initializers.GetDB().
 		Preload("Paciente").
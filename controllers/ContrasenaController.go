@@ -0,0 +1,168 @@
+package controllers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/Ilimm9/CMedicas/Respuestas"
+	"github.com/Ilimm9/CMedicas/clave"
+	"github.com/Ilimm9/CMedicas/initializers"
+	"github.com/Ilimm9/CMedicas/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Vigencia de los enlaces enviados por correo
+var duracionTokenUsuario = map[string]time.Duration{
+	"invitacion":  72 * time.Hour,
+	"restablecer": 2 * time.Hour,
+}
+
+// Crea un token de un solo uso (invalidando los anteriores del mismo tipo) y lo devuelve en claro
+func crearTokenUsuario(tx *gorm.DB, usuarioID uint, tipo string) (string, error) {
+	token, err := clave.GenerarTokenAleatorio()
+	if err != nil {
+		return "", err
+	}
+
+	if err := tx.Model(&models.TokenUsuario{}).
+		Where("usuario_id = ? AND tipo = ? AND usado_en IS NULL", usuarioID, tipo).
+		Update("usado_en", time.Now()).Error; err != nil {
+		return "", err
+	}
+
+	registro := models.TokenUsuario{
+		UsuarioID: usuarioID,
+		Tipo:      tipo,
+		TokenHash: clave.HashToken(token),
+		ExpiraEn:  time.Now().Add(duracionTokenUsuario[tipo]),
+	}
+	if err := tx.Create(&registro).Error; err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// Envía por correo el enlace para fijar contraseña; devuelve si se pudo enviar
+func enviarCorreoToken(usuario models.Usuario, tipo, token string) bool {
+	enlace := os.Getenv("APP_URL") + "/establecer-contrasena?token=" + token
+
+	asunto := "Invitación a CMedicas"
+	mensaje := "Se creó una cuenta para usted en CMedicas.\n\n" +
+		"Defina su contraseña en el siguiente enlace (válido por 72 horas):\n" + enlace
+	if tipo == "restablecer" {
+		asunto = "Restablecer contraseña"
+		mensaje = "Un administrador solicitó restablecer su contraseña.\n\n" +
+			"Defina una nueva contraseña en el siguiente enlace (válido por 2 horas):\n" + enlace
+	}
+
+	if err := clave.EnviarCorreo(usuario.Correo, asunto, mensaje); err != nil {
+		log.Println("Error al enviar correo:", err)
+		return false
+	}
+	return true
+}
+
+// Fuerza el restablecimiento de contraseña: invalida la actual y las sesiones, y envía un enlace
+func ForzarRestablecimientoContrasena(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, "ID inválido")
+		return
+	}
+
+	tx := initializers.GetDB().Begin()
+	if tx.Error != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al iniciar transacción: "+tx.Error.Error())
+		return
+	}
+
+	var usuario models.Usuario
+	if err := tx.First(&usuario, id).Error; err != nil {
+		tx.Rollback()
+		if err == gorm.ErrRecordNotFound {
+			respuestas.RespondError(c, http.StatusNotFound, "Usuario no encontrado")
+		} else {
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al buscar usuario: "+err.Error())
+		}
+		return
+	}
+
+	if err := tx.Model(&usuario).Updates(map[string]interface{}{
+		"contrasena":     "",
+		"version_sesion": gorm.Expr("version_sesion + 1"),
+	}).Error; err != nil {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al invalidar contraseña: "+err.Error())
+		return
+	}
+
+	token, err := crearTokenUsuario(tx, usuario.ID, "restablecer")
+	if err != nil {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al generar enlace: "+err.Error())
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al confirmar transacción: "+err.Error())
+		return
+	}
+
+	enviado := enviarCorreoToken(usuario, "restablecer", token)
+
+	respuestas.RespondSuccess(c, http.StatusOK, gin.H{
+		"message":        "Contraseña invalidada, se envió un enlace para definir una nueva",
+		"correo_enviado": enviado,
+	})
+}
+
+// Define la contraseña a partir de un enlace de invitación o de restablecimiento
+func EstablecerContrasena(c *gin.Context) {
+	var input struct {
+		Token      string `json:"token" binding:"required"`
+		Contrasena string `json:"contrasena" binding:"required,min=8"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	hashedPassword, err := clave.HashPassword(input.Contrasena)
+	if err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al hashear contraseña")
+		return
+	}
+
+	err = initializers.GetDB().Transaction(func(tx *gorm.DB) error {
+		var registro models.TokenUsuario
+		if err := tx.Where("token_hash = ? AND usado_en IS NULL AND expira_en > ?", clave.HashToken(input.Token), time.Now()).
+			First(&registro).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Model(&registro).Update("usado_en", time.Now()).Error; err != nil {
+			return err
+		}
+
+		return tx.Model(&models.Usuario{}).Where("id = ?", registro.UsuarioID).Updates(map[string]interface{}{
+			"contrasena":     hashedPassword,
+			"version_sesion": gorm.Expr("version_sesion + 1"),
+		}).Error
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			respuestas.RespondError(c, http.StatusBadRequest, "Enlace inválido o expirado")
+		} else {
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al definir contraseña: "+err.Error())
+		}
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusOK, "Contraseña definida, ya puede iniciar sesión")
+}
@@ -0,0 +1,324 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Ilimm9/CMedicas/Repositories"
+	"github.com/Ilimm9/CMedicas/Respuestas"
+	"github.com/Ilimm9/CMedicas/dto"
+	"github.com/Ilimm9/CMedicas/initializers"
+	"github.com/Ilimm9/CMedicas/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type DependienteInput struct {
+	Persona    dto.PersonaInput `json:"persona" binding:"required"`
+	Parentesco string           `json:"parentesco" binding:"required,oneof=hijo padre madre conyuge tutelado otro"`
+}
+
+// Busca un dependiente activo del usuario autenticado (o de cualquiera si es administrador)
+func buscarDependienteDeUsuario(c *gin.Context, tx *gorm.DB, id int) (*models.Dependiente, bool) {
+	userID, ok := usuarioActualID(c)
+	if !ok {
+		respuestas.RespondError(c, http.StatusUnauthorized, "No se pudo identificar al usuario")
+		return nil, false
+	}
+
+	query := tx.Preload("Persona").Where("activa = ?", true)
+	if c.GetString("userRol") != "administrador" {
+		query = query.Where("tutor_id = ?", userID)
+	}
+
+	var dependiente models.Dependiente
+	if err := query.First(&dependiente, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			respuestas.RespondError(c, http.StatusNotFound, "Dependiente no encontrado")
+		} else {
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al buscar dependiente: "+err.Error())
+		}
+		return nil, false
+	}
+	return &dependiente, true
+}
+
+// Registrar un dependiente (crea su Persona) a cargo del usuario autenticado
+func PostDependiente(c *gin.Context) {
+	var input DependienteInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	userID, ok := usuarioActualID(c)
+	if !ok {
+		respuestas.RespondError(c, http.StatusUnauthorized, "No se pudo identificar al usuario")
+		return
+	}
+
+	fecha, err := time.Parse("2006-01-02", input.Persona.FechaNacimiento)
+	if err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, "Formato de fecha inválido (usa YYYY-MM-DD)")
+		return
+	}
+
+	tx := initializers.GetDB().Begin()
+	if tx.Error != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al iniciar transacción: "+tx.Error.Error())
+		return
+	}
+
+	persona := models.Persona{
+		Nombre:          input.Persona.Nombre,
+		ApellidoPaterno: input.Persona.ApellidoPaterno,
+		ApellidoMaterno: input.Persona.ApellidoMaterno,
+		Telefono:        input.Persona.Telefono,
+		FechaNacimiento: fecha,
+		Genero:          input.Persona.Genero,
+		Direccion:       input.Persona.Direccion,
+	}
+	if err := tx.Create(&persona).Error; err != nil {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al crear persona: "+err.Error())
+		return
+	}
+
+	dependiente := models.Dependiente{
+		TutorID:    userID,
+		PersonaID:  persona.ID,
+		Persona:    persona,
+		Parentesco: input.Parentesco,
+	}
+	if err := tx.Create(&dependiente).Error; err != nil {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al guardar dependiente: "+err.Error())
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al confirmar transacción: "+err.Error())
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusCreated, dependiente)
+}
+
+// Listar los dependientes activos del usuario autenticado
+func GetDependientes(c *gin.Context) {
+	userID, ok := usuarioActualID(c)
+	if !ok {
+		respuestas.RespondError(c, http.StatusUnauthorized, "No se pudo identificar al usuario")
+		return
+	}
+
+	var dependientes []models.Dependiente
+	result := initializers.GetDB().
+		Preload("Persona").
+		Where("tutor_id = ? AND activa = ?", userID, true).
+		Order("creada_en").
+		Find(&dependientes)
+
+	if result.Error != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al obtener dependientes: "+result.Error.Error())
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusOK, dependientes)
+}
+
+// Actualizar los datos personales de un dependiente
+func UpdateDependiente(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, "ID inválido")
+		return
+	}
+
+	var input DependienteInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	fecha, err := time.Parse("2006-01-02", input.Persona.FechaNacimiento)
+	if err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, "Formato de fecha inválido (usa YYYY-MM-DD)")
+		return
+	}
+
+	dependiente, ok := buscarDependienteDeUsuario(c, initializers.GetDB(), id)
+	if !ok {
+		return
+	}
+
+	persona := models.Persona{
+		Nombre:          input.Persona.Nombre,
+		ApellidoPaterno: input.Persona.ApellidoPaterno,
+		ApellidoMaterno: input.Persona.ApellidoMaterno,
+		Telefono:        input.Persona.Telefono,
+		FechaNacimiento: fecha,
+		Genero:          input.Persona.Genero,
+		Direccion:       input.Persona.Direccion,
+	}
+
+	err = initializers.GetDB().Transaction(func(tx *gorm.DB) error {
+		persona.ID = dependiente.PersonaID
+		if err := tx.Save(&persona).Error; err != nil {
+			return err
+		}
+		return tx.Model(dependiente).Update("parentesco", input.Parentesco).Error
+	})
+	if err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al actualizar dependiente: "+err.Error())
+		return
+	}
+
+	dependiente.Persona = persona
+	dependiente.Parentesco = input.Parentesco
+	respuestas.RespondSuccess(c, http.StatusOK, dependiente)
+}
+
+// Terminar la relación con un dependiente (no se permite con citas programadas)
+func DeleteDependiente(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, "ID inválido")
+		return
+	}
+
+	dependiente, ok := buscarDependienteDeUsuario(c, initializers.GetDB(), id)
+	if !ok {
+		return
+	}
+
+	var count int64
+	if err := initializers.GetDB().Model(&models.Cita{}).
+		Where("paciente_id = ? AND persona_paciente_id = ? AND estado = ?", dependiente.TutorID, dependiente.PersonaID, "programada").
+		Count(&count).Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al verificar citas: "+err.Error())
+		return
+	}
+
+	if count > 0 {
+		respuestas.RespondError(c, http.StatusBadRequest, "El dependiente tiene citas programadas")
+		return
+	}
+
+	if err := initializers.GetDB().Model(dependiente).Updates(map[string]interface{}{
+		"activa":        false,
+		"finalizada_en": time.Now(),
+	}).Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al finalizar relación: "+err.Error())
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusOK, gin.H{"message": "Dependiente desvinculado correctamente"})
+}
+
+// Transferir un dependiente a su propia cuenta (p. ej. al cumplir la mayoría de edad):
+// se crea su Usuario, se le mueven sus citas y notificaciones y se envía una invitación
+func TransferirDependiente(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, "ID inválido")
+		return
+	}
+
+	var input struct {
+		Correo string `json:"correo" binding:"required,email"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if repositories.ExisteCorreo(input.Correo) {
+		respuestas.RespondError(c, http.StatusBadRequest, "Correo ya registrado")
+		return
+	}
+
+	tx := initializers.GetDB().Begin()
+	if tx.Error != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al iniciar transacción: "+tx.Error.Error())
+		return
+	}
+
+	dependiente, ok := buscarDependienteDeUsuario(c, tx, id)
+	if !ok {
+		tx.Rollback()
+		return
+	}
+
+	// Solo un administrador puede transferir a un menor de edad
+	mayoriaEdad := dependiente.Persona.FechaNacimiento.AddDate(18, 0, 0)
+	if time.Now().Before(mayoriaEdad) && c.GetString("userRol") != "administrador" {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusBadRequest, "El dependiente aún no es mayor de edad")
+		return
+	}
+
+	usuario := models.Usuario{
+		PersonaID: dependiente.PersonaID,
+		Rol:       "paciente",
+		Correo:    input.Correo,
+	}
+	if err := tx.Create(&usuario).Error; err != nil {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusConflict, "No se pudo crear el usuario: "+err.Error())
+		return
+	}
+
+	citasDependiente := tx.Model(&models.Cita{}).Select("id").
+		Where("paciente_id = ? AND persona_paciente_id = ?", dependiente.TutorID, dependiente.PersonaID)
+
+	if err := tx.Model(&models.Notificacion{}).
+		Where("id_usuario = ? AND cita_id IN (?)", dependiente.TutorID, citasDependiente).
+		Update("id_usuario", usuario.ID).Error; err != nil {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al transferir notificaciones: "+err.Error())
+		return
+	}
+
+	if err := tx.Model(&models.Cita{}).
+		Where("paciente_id = ? AND persona_paciente_id = ?", dependiente.TutorID, dependiente.PersonaID).
+		Updates(map[string]interface{}{
+			"paciente_id":         usuario.ID,
+			"persona_paciente_id": nil,
+		}).Error; err != nil {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al transferir citas: "+err.Error())
+		return
+	}
+
+	if err := tx.Model(dependiente).Updates(map[string]interface{}{
+		"activa":        false,
+		"finalizada_en": time.Now(),
+	}).Error; err != nil {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al finalizar relación: "+err.Error())
+		return
+	}
+
+	token, err := crearTokenUsuario(tx, usuario.ID, "invitacion")
+	if err != nil {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al generar invitación: "+err.Error())
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al confirmar transacción: "+err.Error())
+		return
+	}
+
+	enviado := enviarCorreoToken(usuario, "invitacion", token)
+
+	respuestas.RespondSuccess(c, http.StatusOK, gin.H{
+		"message":            "Dependiente transferido a su propia cuenta",
+		"usuario":            usuarioResponse(usuario),
+		"invitacion_enviada": enviado,
+	})
+}
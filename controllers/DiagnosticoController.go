@@ -0,0 +1,301 @@
+package controllers
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Ilimm9/CMedicas/Respuestas"
+	"github.com/Ilimm9/CMedicas/initializers"
+	"github.com/Ilimm9/CMedicas/mensajeria"
+	"github.com/Ilimm9/CMedicas/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type DiagnosticoInput struct {
+	Codigo string `json:"codigo" binding:"required"`
+	Tipo   string `json:"tipo" binding:"required,oneof=principal secundario"`
+	Nota   string `json:"nota"`
+}
+
+// Letra, dos caracteres y hasta cuatro más tras el punto (A00, E11.9, S72.001A)
+var formatoCIE10 = regexp.MustCompile(`^[A-Z][0-9][0-9A-Z](\.[0-9A-Z]{1,4})?$`)
+
+// Lo que se escribe al autocompletar un código: "E1", "e11.9"
+var prefijoCIE10 = regexp.MustCompile(`^[A-Z][0-9][0-9A-Z]*$`)
+
+// Normaliza un código CIE-10: mayúsculas y punto tras el tercer carácter ("e119" -> "E11.9")
+func normalizarCodigoCIE10(codigo string) (string, bool) {
+	codigo = strings.ToUpper(strings.TrimSpace(codigo))
+	codigo = strings.ReplaceAll(codigo, ".", "")
+	if len(codigo) > 3 {
+		codigo = codigo[:3] + "." + codigo[3:]
+	}
+	return codigo, formatoCIE10.MatchString(codigo)
+}
+
+// Resuelve los diagnósticos capturados contra el catálogo. Debe haber un único
+// principal; un código no puede repetirse.
+func resolverDiagnosticos(db *gorm.DB, input []DiagnosticoInput) ([]models.DiagnosticoObservacion, error) {
+	var diagnosticos []models.DiagnosticoObservacion
+	if len(input) == 0 {
+		return diagnosticos, nil
+	}
+
+	principales := 0
+	vistos := map[string]bool{}
+	for _, d := range input {
+		codigo, ok := normalizarCodigoCIE10(d.Codigo)
+		if !ok {
+			return nil, fmt.Errorf("código CIE-10 inválido: %s", d.Codigo)
+		}
+		if vistos[codigo] {
+			return nil, fmt.Errorf("el código %s está repetido", codigo)
+		}
+		vistos[codigo] = true
+		if d.Tipo == "principal" {
+			principales++
+		}
+
+		var catalogo models.CatalogoDiagnostico
+		if err := db.Where("codigo = ? AND activo", codigo).Limit(1).Find(&catalogo).Error; err != nil {
+			return nil, err
+		}
+		if catalogo.ID == 0 {
+			return nil, fmt.Errorf("el código %s no está en el catálogo", codigo)
+		}
+
+		diagnosticos = append(diagnosticos, models.DiagnosticoObservacion{
+			CatalogoDiagnosticoID: catalogo.ID,
+			Catalogo:              catalogo,
+			Tipo:                  d.Tipo,
+			Nota:                  d.Nota,
+		})
+	}
+
+	if principales != 1 {
+		return nil, fmt.Errorf("indique exactamente un diagnóstico principal")
+	}
+	return diagnosticos, nil
+}
+
+// Reemplaza los diagnósticos de una observación
+func guardarDiagnosticos(tx *gorm.DB, observacion *models.Observacion, diagnosticos []models.DiagnosticoObservacion) error {
+	if err := tx.Where("observacion_id = ?", observacion.ID).Delete(&models.DiagnosticoObservacion{}).Error; err != nil {
+		return err
+	}
+	for i := range diagnosticos {
+		diagnosticos[i].ID = 0
+		diagnosticos[i].ObservacionID = observacion.ID
+	}
+	if len(diagnosticos) > 0 {
+		if err := tx.Omit("Catalogo").Create(&diagnosticos).Error; err != nil {
+			return err
+		}
+	}
+	observacion.Diagnosticos = diagnosticos
+	return nil
+}
+
+// Buscar en el catálogo por código o descripción (?q=, ?limite=), para autocompletar
+func BuscarDiagnosticos(c *gin.Context) {
+	q := strings.TrimSpace(c.Query("q"))
+	if len([]rune(q)) < 2 {
+		respuestas.RespondError(c, http.StatusBadRequest, "Escriba al menos 2 caracteres")
+		return
+	}
+	limite, err := strconv.Atoi(c.DefaultQuery("limite", "10"))
+	if err != nil || limite < 1 || limite > 50 {
+		limite = 10
+	}
+
+	consulta := initializers.GetDB().Where("activo")
+
+	// Un prefijo de código ("E11", "e11.9") busca por código; lo demás por palabras
+	prefijo := strings.ToUpper(strings.ReplaceAll(q, ".", ""))
+	if prefijoCIE10.MatchString(prefijo) {
+		consulta = consulta.Where("REPLACE(codigo, '.', '') LIKE ?", prefijo+"%").
+			Order("LENGTH(codigo), codigo")
+	} else {
+		for _, palabra := range strings.Fields(q) {
+			consulta = consulta.Where("descripcion ILIKE ?", "%"+escaparLike(palabra)+"%")
+		}
+		consulta = consulta.Order("LENGTH(descripcion), codigo")
+	}
+
+	var resultados []models.CatalogoDiagnostico
+	if err := consulta.Limit(limite).Find(&resultados).Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al buscar diagnósticos: "+err.Error())
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusOK, resultados)
+}
+
+func escaparLike(texto string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(texto)
+}
+
+// Cargar o actualizar el catálogo desde un CSV (campo "archivo") con las
+// columnas código y descripción; el encabezado es opcional. Los códigos que ya
+// existen actualizan su descripción y se reactivan.
+func ImportarCatalogoDiagnosticos(c *gin.Context) {
+	archivo, err := c.FormFile("archivo")
+	if err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, "Adjunte el archivo CSV en el campo 'archivo'")
+		return
+	}
+	f, err := archivo.Open()
+	if err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, "No se pudo leer el archivo: "+err.Error())
+		return
+	}
+	defer f.Close()
+
+	lector := csv.NewReader(f)
+	lector.FieldsPerRecord = -1
+	lector.TrimLeadingSpace = true
+	if c.PostForm("separador") == ";" {
+		lector.Comma = ';'
+	}
+
+	const tamanoLote = 500
+	var lote []models.CatalogoDiagnostico
+	var invalidas []string
+	procesados, linea := 0, 0
+
+	guardarLote := func(tx *gorm.DB) error {
+		if len(lote) == 0 {
+			return nil
+		}
+		err := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "codigo"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{"descripcion": gorm.Expr("EXCLUDED.descripcion"), "activo": true}),
+		}).Create(&lote).Error
+		procesados += len(lote)
+		lote = lote[:0]
+		return err
+	}
+
+	// Todo el archivo en una transacción: si un lote falla no queda el
+	// catálogo importado a medias
+	vistos := map[string]bool{}
+	err = initializers.GetDB().Transaction(func(tx *gorm.DB) error {
+		for {
+			registro, err := lector.Read()
+			if err == io.EOF {
+				break
+			}
+			linea++
+			if err != nil {
+				invalidas = append(invalidas, fmt.Sprintf("línea %d: %v", linea, err))
+				continue
+			}
+			if len(registro) < 2 {
+				invalidas = append(invalidas, fmt.Sprintf("línea %d: faltan columnas", linea))
+				continue
+			}
+
+			codigo, ok := normalizarCodigoCIE10(strings.TrimPrefix(registro[0], "\ufeff"))
+			descripcion := strings.TrimSpace(registro[1])
+			if !ok || descripcion == "" {
+				// La primera línea puede ser el encabezado
+				if linea > 1 {
+					invalidas = append(invalidas, fmt.Sprintf("línea %d: código o descripción inválidos", linea))
+				}
+				continue
+			}
+			// Un código repetido en el mismo lote haría fallar el upsert
+			if vistos[codigo] {
+				continue
+			}
+			vistos[codigo] = true
+
+			lote = append(lote, models.CatalogoDiagnostico{Codigo: codigo, Descripcion: descripcion, Activo: true})
+			if len(lote) == tamanoLote {
+				if err := guardarLote(tx); err != nil {
+					return err
+				}
+			}
+		}
+		return guardarLote(tx)
+	})
+	if err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al guardar catálogo, no se importó ningún código: "+err.Error())
+		return
+	}
+
+	if len(invalidas) > 100 {
+		invalidas = append(invalidas[:100], fmt.Sprintf("... y %d más", len(invalidas)-100))
+	}
+	respuestas.RespondSuccess(c, http.StatusOK, gin.H{
+		"procesados": procesados,
+		"invalidas":  invalidas,
+	})
+}
+
+// Frecuencia de diagnósticos por médico en un periodo (?desde=, ?hasta=
+// AAAA-MM-DD, ?medico_id=, ?tipo=principal|secundario)
+func GetReporteDiagnosticos(c *gin.Context) {
+	consulta := initializers.GetDB().
+		Table("diagnostico_observacions AS d").
+		Select(`ci.medico_id, p.nombre || ' ' || p.apellido_paterno AS medico,
+			cd.codigo, cd.descripcion, COUNT(*) AS total`).
+		Joins("JOIN observacions o ON o.id = d.observacion_id").
+		Joins("JOIN cita ci ON ci.id = o.cita_id").
+		Joins("JOIN catalogo_diagnosticos cd ON cd.id = d.catalogo_diagnostico_id").
+		Joins("JOIN medicos m ON m.id = ci.medico_id").
+		Joins("JOIN usuarios u ON u.id = m.usuario_id").
+		Joins("JOIN personas p ON p.id = u.persona_id").
+		Where("o.anulada_en IS NULL").
+		Group("ci.medico_id, p.nombre, p.apellido_paterno, cd.codigo, cd.descripcion").
+		Order("ci.medico_id, total DESC, cd.codigo")
+
+	for param, condicion := range map[string]string{"desde": "ci.fecha_cita >= ?", "hasta": "ci.fecha_cita < ?"} {
+		valor := c.Query(param)
+		if valor == "" {
+			continue
+		}
+		fecha, err := time.ParseInLocation("2006-01-02", valor, mensajeria.ZonaClinica())
+		if err != nil {
+			respuestas.RespondError(c, http.StatusBadRequest, "Fecha inválida en '"+param+"', use AAAA-MM-DD")
+			return
+		}
+		if param == "hasta" {
+			fecha = fecha.AddDate(0, 0, 1) // inclusivo
+		}
+		consulta = consulta.Where(condicion, fecha)
+	}
+	if medicoID := c.Query("medico_id"); medicoID != "" {
+		consulta = consulta.Where("ci.medico_id = ?", medicoID)
+	}
+	if tipo := c.Query("tipo"); tipo != "" {
+		if tipo != "principal" && tipo != "secundario" {
+			respuestas.RespondError(c, http.StatusBadRequest, "Tipo inválido")
+			return
+		}
+		consulta = consulta.Where("d.tipo = ?", tipo)
+	}
+
+	var filas []struct {
+		MedicoID    uint   `json:"medico_id"`
+		Medico      string `json:"medico"`
+		Codigo      string `json:"codigo"`
+		Descripcion string `json:"descripcion"`
+		Total       int64  `json:"total"`
+	}
+	if err := consulta.Scan(&filas).Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al generar reporte: "+err.Error())
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusOK, filas)
+}
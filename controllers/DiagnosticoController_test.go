@@ -0,0 +1,29 @@
+package controllers
+
+import "testing"
+
+func TestNormalizarCodigoCIE10(t *testing.T) {
+	casos := []struct {
+		entrada string
+		codigo  string
+		valido  bool
+	}{
+		{"A00", "A00", true},
+		{"e119", "E11.9", true},
+		{" e11.9 ", "E11.9", true},
+		{"E11.9", "E11.9", true},
+		{"s72001a", "S72.001A", true},
+		{"J4A", "J4A", true},
+		{"E1", "E1", false},
+		{"11.9", "119", false},
+		{"E11.12345", "E11.12345", false},
+		{"EE1.1", "EE1.1", false},
+		{"", "", false},
+	}
+	for _, c := range casos {
+		codigo, valido := normalizarCodigoCIE10(c.entrada)
+		if codigo != c.codigo || valido != c.valido {
+			t.Errorf("normalizarCodigoCIE10(%q) = (%q, %v), se esperaba (%q, %v)", c.entrada, codigo, valido, c.codigo, c.valido)
+		}
+	}
+}
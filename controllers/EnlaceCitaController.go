@@ -0,0 +1,238 @@
+package controllers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/Ilimm9/CMedicas/Respuestas"
+	"github.com/Ilimm9/CMedicas/clave"
+	"github.com/Ilimm9/CMedicas/eventos"
+	"github.com/Ilimm9/CMedicas/initializers"
+	"github.com/Ilimm9/CMedicas/mensajeria"
+	"github.com/Ilimm9/CMedicas/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type EnlaceCitaInput struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// Busca la cita de un enlace firmado. Devuelve un estado HTTP distinto de 0 si
+// el enlace no sirve.
+func citaDeEnlace(db *gorm.DB, token string) (models.Cita, int, string) {
+	var cita models.Cita
+
+	citaID, fecha, err := clave.ValidarTokenCita(token)
+	if err != nil {
+		return cita, http.StatusGone, "El enlace no es válido o ya venció"
+	}
+
+	if err := db.Preload("Medico.Usuario.Persona").Preload("Paciente.Persona").Preload("PersonaPaciente").First(&cita, citaID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return cita, http.StatusNotFound, "Cita no encontrada"
+		}
+		return cita, http.StatusInternalServerError, "Error al buscar cita: " + err.Error()
+	}
+
+	// El enlace es para una fecha concreta: si se reprogramó, no vale
+	if cita.FechaCita.Unix() != fecha.Unix() {
+		return cita, http.StatusGone, "La cita cambió de fecha; use el enlace del aviso más reciente"
+	}
+	return cita, 0, ""
+}
+
+func resumenCitaEnlace(cita models.Cita) gin.H {
+	paciente := cita.Paciente.Persona
+	if cita.PersonaPaciente != nil {
+		paciente = *cita.PersonaPaciente
+	}
+	_, noCancelable := validarCancelacion(cita)
+
+	return gin.H{
+		"cita_id":        cita.ID,
+		"fecha_cita":     cita.FechaCita,
+		"estado":         cita.Estado,
+		"confirmada_en":  cita.ConfirmadaEn,
+		"paciente":       paciente.Nombre + " " + paciente.ApellidoPaterno,
+		"medico":         cita.Medico.Usuario.Persona.Nombre + " " + cita.Medico.Usuario.Persona.ApellidoPaterno,
+		"especialidad":   cita.Medico.Especialidad,
+		"puede_cancelar": noCancelable == "",
+	}
+}
+
+// Datos de la cita de un enlace (?token=), para la página de confirmación
+func GetCitaEnlace(c *gin.Context) {
+	cita, estado, mensaje := citaDeEnlace(initializers.GetDB(), c.Query("token"))
+	if estado != 0 {
+		respuestas.RespondError(c, estado, mensaje)
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusOK, resumenCitaEnlace(cita))
+}
+
+// Confirmar asistencia desde el enlace del recordatorio (sin iniciar sesión)
+func ConfirmarCitaEnlace(c *gin.Context) {
+	var input EnlaceCitaInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	tx := initializers.GetDB().Begin()
+	if tx.Error != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al iniciar transacción: "+tx.Error.Error())
+		return
+	}
+
+	cita, estado, mensaje := citaDeEnlace(tx, input.Token)
+	if estado != 0 {
+		tx.Rollback()
+		respuestas.RespondError(c, estado, mensaje)
+		return
+	}
+
+	if cita.Estado != "programada" {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusConflict, "La cita ya no está programada")
+		return
+	}
+
+	// Confirmar dos veces no cambia nada
+	if cita.ConfirmadaEn != nil {
+		tx.Rollback()
+		respuestas.RespondSuccess(c, http.StatusOK, resumenCitaEnlace(cita))
+		return
+	}
+
+	ahora := time.Now()
+	if err := tx.Model(&cita).Update("confirmada_en", ahora).Error; err != nil {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al confirmar cita: "+err.Error())
+		return
+	}
+	cita.ConfirmadaEn = &ahora
+
+	confirmada := eventos.Evento{Tipo: eventos.CitaConfirmada, Cita: cita, ActorID: cita.PacienteID}
+	if err := eventos.Emitir(tx, confirmada); err != nil {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al registrar evento de cita: "+err.Error())
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al confirmar transacción: "+err.Error())
+		return
+	}
+	eventos.Confirmados(confirmada)
+
+	respuestas.RespondSuccess(c, http.StatusOK, resumenCitaEnlace(cita))
+}
+
+// Cancelar desde el enlace del recordatorio, con las mismas reglas que CancelarCita
+func CancelarCitaEnlace(c *gin.Context) {
+	var input EnlaceCitaInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	tx := initializers.GetDB().Begin()
+	if tx.Error != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al iniciar transacción: "+tx.Error.Error())
+		return
+	}
+
+	cita, estado, mensaje := citaDeEnlace(tx, input.Token)
+	if estado != 0 {
+		tx.Rollback()
+		respuestas.RespondError(c, estado, mensaje)
+		return
+	}
+
+	if estado, mensaje := validarCancelacion(cita); estado != 0 {
+		tx.Rollback()
+		respuestas.RespondError(c, estado, mensaje)
+		return
+	}
+
+	// El enlace solo llega a la cuenta responsable del paciente
+	cancelada, err := cancelarCita(tx, &cita, cita.PacienteID)
+	if err != nil {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al cancelar cita: "+err.Error())
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al confirmar transacción: "+err.Error())
+		return
+	}
+	eventos.Confirmados(cancelada)
+
+	respuestas.RespondSuccess(c, http.StatusOK, resumenCitaEnlace(cita))
+}
+
+// Citas de un día (?fecha=AAAA-MM-DD, por defecto mañana en la zona de la
+// clínica) confirmadas contra sin confirmar, con la lista de pendientes
+func GetMetricaConfirmaciones(c *gin.Context) {
+	zona := mensajeria.ZonaClinica()
+
+	dia := time.Now().In(zona).AddDate(0, 0, 1)
+	if fecha := c.Query("fecha"); fecha != "" {
+		var err error
+		if dia, err = time.ParseInLocation("2006-01-02", fecha, zona); err != nil {
+			respuestas.RespondError(c, http.StatusBadRequest, "Fecha inválida, use AAAA-MM-DD")
+			return
+		}
+	}
+	inicio := time.Date(dia.Year(), dia.Month(), dia.Day(), 0, 0, 0, 0, zona)
+	fin := inicio.AddDate(0, 0, 1)
+
+	var citas []models.Cita
+	if err := initializers.GetDB().
+		Preload("Paciente.Persona").
+		Preload("PersonaPaciente").
+		Preload("Medico.Usuario.Persona").
+		Where("fecha_cita >= ? AND fecha_cita < ? AND estado = ?", inicio, fin, "programada").
+		Order("fecha_cita").
+		Find(&citas).Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al obtener citas: "+err.Error())
+		return
+	}
+
+	var canceladas int64
+	if err := initializers.GetDB().Model(&models.Cita{}).
+		Where("fecha_cita >= ? AND fecha_cita < ? AND estado = ?", inicio, fin, "cancelada").
+		Count(&canceladas).Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al contar citas: "+err.Error())
+		return
+	}
+
+	confirmadas := 0
+	sinConfirmar := []gin.H{}
+	for _, cita := range citas {
+		if cita.ConfirmadaEn != nil {
+			confirmadas++
+			continue
+		}
+		sinConfirmar = append(sinConfirmar, resumenCitaEnlace(cita))
+	}
+
+	tasa := 0.0
+	if len(citas) > 0 {
+		tasa = float64(confirmadas) / float64(len(citas))
+	}
+
+	respuestas.RespondSuccess(c, http.StatusOK, gin.H{
+		"fecha":               inicio.Format("2006-01-02"),
+		"programadas":         len(citas),
+		"confirmadas":         confirmadas,
+		"sin_confirmar":       len(citas) - confirmadas,
+		"canceladas":          canceladas,
+		"tasa_confirmacion":   tasa,
+		"citas_sin_confirmar": sinConfirmar,
+	})
+}
@@ -0,0 +1,284 @@
+package controllers
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/Ilimm9/CMedicas/Respuestas"
+	"github.com/Ilimm9/CMedicas/initializers"
+	"github.com/Ilimm9/CMedicas/mensajeria"
+	"github.com/Ilimm9/CMedicas/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Citas de una persona: las suyas como dependiente y, si tiene cuenta, las
+// que agendó para sí misma
+func citasDePersona(db *gorm.DB, personaID uint) *gorm.DB {
+	return db.Where("(cita.persona_paciente_id = ?) OR (cita.persona_paciente_id IS NULL AND cita.paciente_id IN (SELECT id FROM usuarios WHERE persona_id = ?))",
+		personaID, personaID)
+}
+
+// Puede ver el historial de una persona: el administrador, la propia persona o
+// su tutor, y los médicos que la han atendido o la tienen agendada
+func puedeVerHistorial(c *gin.Context, personaID uint) (bool, error) {
+	usuarioID, ok := usuarioActualID(c)
+	if !ok {
+		return false, nil
+	}
+	db := initializers.GetDB()
+
+	switch c.GetString("userRol") {
+	case "administrador":
+		return true, nil
+	case "paciente":
+		var usuario models.Usuario
+		if err := db.Select("id", "persona_id").First(&usuario, usuarioID).Error; err != nil {
+			return false, err
+		}
+		if usuario.PersonaID == personaID {
+			return true, nil
+		}
+		var dependientes int64
+		err := db.Model(&models.Dependiente{}).
+			Where("tutor_id = ? AND persona_id = ? AND activa = ?", usuarioID, personaID, true).
+			Count(&dependientes).Error
+		return dependientes > 0, err
+	case "medico":
+		medico, ok := medicoActual(c)
+		if !ok {
+			return false, nil
+		}
+		var citas int64
+		err := citasDePersona(db.Model(&models.Cita{}), personaID).
+			Where("medico_id = ? AND estado <> ?", medico.ID, "cancelada").
+			Count(&citas).Error
+		return citas > 0, err
+	}
+	return false, nil
+}
+
+func resumenObservacionHistorial(observacion models.Observacion) gin.H {
+	diagnosticos := make([]gin.H, 0, len(observacion.Diagnosticos))
+	for _, d := range observacion.Diagnosticos {
+		diagnosticos = append(diagnosticos, gin.H{
+			"codigo":      d.Catalogo.Codigo,
+			"descripcion": d.Catalogo.Descripcion,
+			"tipo":        d.Tipo,
+			"nota":        d.Nota,
+		})
+	}
+	adendas := make([]gin.H, 0, len(observacion.Adendas))
+	for _, a := range observacion.Adendas {
+		adendas = append(adendas, gin.H{
+			"medico_id": a.MedicoID,
+			"texto":     a.Texto,
+			"creada_en": a.CreadaEn,
+		})
+	}
+	return gin.H{
+		"id":                 observacion.ID,
+		"version":            observacion.Version,
+		"firmada_en":         observacion.FirmadaEn,
+		"anulada_en":         observacion.AnuladaEn,
+		"motivo_anulacion":   observacion.MotivoAnulacion,
+		"fecha_registro":     observacion.FechaRegistro,
+		"motivo_consulta":    observacion.MotivoConsulta,
+		"observaciones":      observacion.Observaciones,
+		"exploracion_fisica": observacion.ExploracionFisica,
+		"evaluacion":         observacion.Evaluacion,
+		"plan":               observacion.Plan,
+		"diagnostico":        observacion.Diagnostico,
+		"signos_vitales":     observacion.SignosVitales,
+		"diagnosticos":       diagnosticos,
+		"adendas":            adendas,
+	}
+}
+
+func resumenRecetaHistorial(receta models.Receta) gin.H {
+	return gin.H{
+		"id":                  receta.ID,
+		"codigo_verificacion": receta.CodigoVerificacion,
+		"estado":              receta.Estado,
+		"emitida_en":          receta.EmitidaEn,
+		"indicaciones":        receta.Indicaciones,
+		"medicamentos":        receta.Medicamentos,
+	}
+}
+
+// Historial clínico de una persona en orden cronológico: antecedentes (en
+// los datos del paciente) y citas con su observación, diagnósticos y recetas. Filtros: ?medico_id=, ?especialidad=,
+// ?desde= y ?hasta= (AAAA-MM-DD), ?orden=desc para ver primero lo reciente.
+func GetHistorialPaciente(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("persona_id"))
+	if err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, "ID inválido")
+		return
+	}
+	personaID := uint(id)
+
+	permitido, err := puedeVerHistorial(c, personaID)
+	if err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al verificar acceso: "+err.Error())
+		return
+	}
+	// Sin acceso se responde igual que si no existiera
+	if !permitido {
+		respuestas.RespondError(c, http.StatusNotFound, "Paciente no encontrado")
+		return
+	}
+
+	var persona models.Persona
+	if err := initializers.GetDB().First(&persona, personaID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			respuestas.RespondError(c, http.StatusNotFound, "Paciente no encontrado")
+		} else {
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al buscar paciente: "+err.Error())
+		}
+		return
+	}
+
+	if err := cargarAntecedentes(initializers.GetDB(), &persona); err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al obtener antecedentes: "+err.Error())
+		return
+	}
+
+	orden := "fecha_cita"
+	if c.Query("orden") == "desc" {
+		orden = "fecha_cita DESC"
+	}
+	consulta := citasDePersona(initializers.GetDB(), personaID).
+		Preload("Medico.Usuario.Persona").
+		Order(orden)
+
+	if medicoID := c.Query("medico_id"); medicoID != "" {
+		consulta = consulta.Where("cita.medico_id = ?", medicoID)
+	}
+	if especialidad := c.Query("especialidad"); especialidad != "" {
+		consulta = consulta.Where("cita.medico_id IN ("+subconsultaMedicosEspecialidad+")", especialidad, especialidad)
+	}
+	for param, condicion := range map[string]string{"desde": "cita.fecha_cita >= ?", "hasta": "cita.fecha_cita < ?"} {
+		valor := c.Query(param)
+		if valor == "" {
+			continue
+		}
+		fecha, err := time.ParseInLocation("2006-01-02", valor, mensajeria.ZonaClinica())
+		if err != nil {
+			respuestas.RespondError(c, http.StatusBadRequest, "Fecha inválida en '"+param+"', use AAAA-MM-DD")
+			return
+		}
+		if param == "hasta" {
+			fecha = fecha.AddDate(0, 0, 1) // inclusivo
+		}
+		consulta = consulta.Where(condicion, fecha)
+	}
+
+	var citas []models.Cita
+	if err := consulta.Find(&citas).Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al obtener citas: "+err.Error())
+		return
+	}
+
+	citaIDs := make([]uint, 0, len(citas))
+	for _, cita := range citas {
+		citaIDs = append(citaIDs, cita.ID)
+	}
+
+	var observaciones []models.Observacion
+	var recetas []models.Receta
+	if len(citaIDs) > 0 {
+		if err := initializers.GetDB().
+			Preload("Diagnosticos.Catalogo").
+			Preload("Adendas", func(db *gorm.DB) *gorm.DB { return db.Order("creada_en") }).
+			Where("cita_id IN ?", citaIDs).
+			Find(&observaciones).Error; err != nil {
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al obtener observaciones: "+err.Error())
+			return
+		}
+		if err := initializers.GetDB().
+			Preload("Medicamentos", func(db *gorm.DB) *gorm.DB { return db.Order("id") }).
+			Where("cita_id IN ?", citaIDs).
+			Order("emitida_en").
+			Find(&recetas).Error; err != nil {
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al obtener recetas: "+err.Error())
+			return
+		}
+	}
+
+	observacionPorCita := map[uint]models.Observacion{}
+	for _, o := range observaciones {
+		observacionPorCita[o.CitaID] = o
+	}
+	recetasPorCita := map[uint][]gin.H{}
+	for _, r := range recetas {
+		recetasPorCita[r.CitaID] = append(recetasPorCita[r.CitaID], resumenRecetaHistorial(r))
+	}
+
+	// Resumen de diagnósticos: cuántas veces y entre qué fechas
+	type resumenDiagnostico struct {
+		Codigo      string    `json:"codigo"`
+		Descripcion string    `json:"descripcion"`
+		Veces       int       `json:"veces"`
+		Primera     time.Time `json:"primera"`
+		Ultima      time.Time `json:"ultima"`
+	}
+	porCodigo := map[string]*resumenDiagnostico{}
+
+	entradas := make([]gin.H, 0, len(citas))
+	for _, cita := range citas {
+		medico := cita.Medico.Usuario.Persona
+		entrada := gin.H{
+			"cita_id":    cita.ID,
+			"fecha_cita": cita.FechaCita,
+			"estado":     cita.Estado,
+			"motivo":     cita.Motivo,
+			"medico": gin.H{
+				"id":           cita.MedicoID,
+				"nombre":       medico.Nombre + " " + medico.ApellidoPaterno,
+				"especialidad": cita.Medico.Especialidad,
+			},
+			"observacion": nil,
+			"recetas":     []gin.H{},
+		}
+		if o, ok := observacionPorCita[cita.ID]; ok {
+			entrada["observacion"] = resumenObservacionHistorial(o)
+			// Una observación anulada se muestra, pero sus diagnósticos no cuentan
+			if o.AnuladaEn != nil {
+				o.Diagnosticos = nil
+			}
+			for _, d := range o.Diagnosticos {
+				r, ok := porCodigo[d.Catalogo.Codigo]
+				if !ok {
+					r = &resumenDiagnostico{Codigo: d.Catalogo.Codigo, Descripcion: d.Catalogo.Descripcion, Primera: cita.FechaCita, Ultima: cita.FechaCita}
+					porCodigo[d.Catalogo.Codigo] = r
+				}
+				r.Veces++
+				if cita.FechaCita.Before(r.Primera) {
+					r.Primera = cita.FechaCita
+				}
+				if cita.FechaCita.After(r.Ultima) {
+					r.Ultima = cita.FechaCita
+				}
+			}
+		}
+		if rs, ok := recetasPorCita[cita.ID]; ok {
+			entrada["recetas"] = rs
+		}
+		entradas = append(entradas, entrada)
+	}
+
+	diagnosticos := make([]resumenDiagnostico, 0, len(porCodigo))
+	for _, r := range porCodigo {
+		diagnosticos = append(diagnosticos, *r)
+	}
+	sort.Slice(diagnosticos, func(i, j int) bool { return diagnosticos[i].Ultima.After(diagnosticos[j].Ultima) })
+
+	respuestas.RespondSuccess(c, http.StatusOK, gin.H{
+		"paciente":     persona,
+		"diagnosticos": diagnosticos,
+		"citas":        entradas,
+	})
+}
@@ -0,0 +1,22 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/Ilimm9/CMedicas/Respuestas"
+	"github.com/Ilimm9/CMedicas/clave"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Publica las llaves públicas de firma de JWT (formato estándar JWKS, sin envoltura)
+func GetJWKS(c *gin.Context) {
+	llaves, err := clave.JWKS()
+	if err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al cargar llaves: "+err.Error())
+		return
+	}
+
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, gin.H{"keys": llaves})
+}
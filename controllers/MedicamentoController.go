@@ -0,0 +1,125 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Ilimm9/CMedicas/Respuestas"
+	"github.com/Ilimm9/CMedicas/initializers"
+	"github.com/Ilimm9/CMedicas/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type MedicamentoInput struct {
+	Nombre       string `json:"nombre" binding:"required,max=200"`
+	Presentacion string `json:"presentacion" binding:"max=200"`
+	Via          string `json:"via" binding:"omitempty,oneof=oral sublingual tópica oftálmica ótica nasal inhalada rectal vaginal transdérmica intramuscular intravenosa subcutánea"`
+}
+
+// Buscar medicamentos activos por nombre o presentación (?q=, ?limite=)
+func BuscarMedicamentos(c *gin.Context) {
+	limite, err := strconv.Atoi(c.DefaultQuery("limite", "10"))
+	if err != nil || limite < 1 || limite > 50 {
+		limite = 10
+	}
+
+	consulta := initializers.GetDB().Where("activo")
+	for _, palabra := range strings.Fields(c.Query("q")) {
+		patron := "%" + escaparLike(palabra) + "%"
+		consulta = consulta.Where("(nombre ILIKE ? OR presentacion ILIKE ?)", patron, patron)
+	}
+
+	var medicamentos []models.Medicamento
+	if err := consulta.Order("nombre, presentacion").Limit(limite).Find(&medicamentos).Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al buscar medicamentos: "+err.Error())
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusOK, medicamentos)
+}
+
+// Agregar un medicamento al catálogo
+func PostMedicamento(c *gin.Context) {
+	var input MedicamentoInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	medicamento := models.Medicamento{
+		Nombre:       strings.TrimSpace(input.Nombre),
+		Presentacion: strings.TrimSpace(input.Presentacion),
+		Via:          input.Via,
+		Activo:       true,
+	}
+	if err := initializers.GetDB().Create(&medicamento).Error; err != nil {
+		if strings.Contains(err.Error(), "duplicate key") {
+			respuestas.RespondError(c, http.StatusConflict, "El medicamento ya está en el catálogo")
+		} else {
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al guardar medicamento: "+err.Error())
+		}
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusCreated, medicamento)
+}
+
+// Actualizar un medicamento del catálogo; las recetas ya emitidas no cambian
+func UpdateMedicamento(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, "ID inválido")
+		return
+	}
+
+	var input MedicamentoInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var medicamento models.Medicamento
+	if err := initializers.GetDB().First(&medicamento, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			respuestas.RespondError(c, http.StatusNotFound, "Medicamento no encontrado")
+		} else {
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al buscar medicamento: "+err.Error())
+		}
+		return
+	}
+
+	medicamento.Nombre = strings.TrimSpace(input.Nombre)
+	medicamento.Presentacion = strings.TrimSpace(input.Presentacion)
+	medicamento.Via = input.Via
+	medicamento.Activo = true
+	if err := initializers.GetDB().Save(&medicamento).Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al actualizar medicamento: "+err.Error())
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusOK, medicamento)
+}
+
+// Retirar un medicamento del catálogo (deja de ofrecerse; las recetas lo conservan)
+func DeleteMedicamento(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, "ID inválido")
+		return
+	}
+
+	result := initializers.GetDB().Model(&models.Medicamento{}).Where("id = ?", id).Update("activo", false)
+	if result.Error != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al retirar medicamento: "+result.Error.Error())
+		return
+	}
+	if result.RowsAffected == 0 {
+		respuestas.RespondError(c, http.StatusNotFound, "Medicamento no encontrado")
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusOK, gin.H{"message": "Medicamento retirado del catálogo"})
+}
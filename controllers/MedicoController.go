@@ -3,9 +3,12 @@ package controllers
 import (
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/Ilimm9/CMedicas/Repositories"
 	"github.com/Ilimm9/CMedicas/Respuestas"
+	"github.com/Ilimm9/CMedicas/dto"
 	"github.com/Ilimm9/CMedicas/initializers"
 	"github.com/Ilimm9/CMedicas/models"
 
@@ -18,6 +21,188 @@ type MedicoInput struct {
 	Especialidad string `json:"especialidad" binding:"required,max=100"`
 }
 
+type HorarioBloqueInput struct {
+	DiaSemana  string    `json:"dia_semana" binding:"required,oneof=Lunes Martes Miércoles Jueves Viernes Sábado Domingo"`
+	HoraInicio time.Time `json:"hora_inicio" binding:"required"`
+	HoraFin    time.Time `json:"hora_fin" binding:"required"`
+}
+
+type AltaMedicoInput struct {
+	Persona        dto.PersonaInput     `json:"persona" binding:"required"`
+	Correo         string               `json:"correo" binding:"required,email"`
+	Especialidades []string             `json:"especialidades" binding:"required,min=1,dive,required,max=100"`
+	Cedula         string               `json:"cedula_profesional" binding:"max=20"`
+	Horarios       []HorarioBloqueInput `json:"horarios" binding:"dive"`
+}
+
+// Asocia las especialidades al médico, creándolas en el catálogo si no existen
+func asignarEspecialidades(tx *gorm.DB, medico *models.Medico, nombres []string) error {
+	especialidades := make([]models.Especialidad, 0, len(nombres))
+	for _, nombre := range nombres {
+		especialidad := models.Especialidad{Nombre: strings.TrimSpace(nombre)}
+		if err := tx.Where("nombre = ?", especialidad.Nombre).FirstOrCreate(&especialidad).Error; err != nil {
+			return err
+		}
+		especialidades = append(especialidades, especialidad)
+	}
+	return tx.Model(medico).Association("Especialidades").Replace(especialidades)
+}
+
+// Elimina al médico junto con sus especialidades asociadas; la tabla de unión
+// no borra en cascada y rechazaría el borrado
+func eliminarMedico(tx *gorm.DB, medicoID uint) (int64, error) {
+	if err := tx.Model(&models.Medico{ID: medicoID}).Association("Especialidades").Clear(); err != nil {
+		return 0, err
+	}
+	result := tx.Delete(&models.Medico{}, medicoID)
+	return result.RowsAffected, result.Error
+}
+
+// Valida que los bloques de horario sean coherentes y no se traslapen en el mismo día
+func validarBloquesHorario(bloques []HorarioBloqueInput) string {
+	for i, b := range bloques {
+		if !b.HoraFin.After(b.HoraInicio) {
+			return "La hora de fin debe ser posterior a la hora de inicio (" + b.DiaSemana + ")"
+		}
+		for _, otro := range bloques[:i] {
+			if otro.DiaSemana == b.DiaSemana && b.HoraInicio.Before(otro.HoraFin) && otro.HoraInicio.Before(b.HoraFin) {
+				return "Los horarios del " + b.DiaSemana + " se traslapan"
+			}
+		}
+	}
+	return ""
+}
+
+// AltaMedico da de alta a un médico en una sola operación atómica: Persona,
+// Usuario con rol medico, Medico con especialidades, horarios e invitación.
+// La invitación se envía después del commit; si el correo falla, el médico
+// queda registrado con invitacion_enviada en false y el administrador la
+// reenvía con restablecer-contrasena.
+func AltaMedico(c *gin.Context) {
+	var input AltaMedicoInput
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	fechaNac, err := time.Parse("2006-01-02", input.Persona.FechaNacimiento)
+	if err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, "Formato de fecha inválido (usa YYYY-MM-DD)")
+		return
+	}
+
+	if msg := validarBloquesHorario(input.Horarios); msg != "" {
+		respuestas.RespondError(c, http.StatusBadRequest, msg)
+		return
+	}
+
+	if repositories.ExisteCorreo(input.Correo) {
+		respuestas.RespondError(c, http.StatusBadRequest, "Correo ya registrado")
+		return
+	}
+
+	tx := initializers.GetDB().Begin()
+	if tx.Error != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al iniciar transacción: "+tx.Error.Error())
+		return
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+			panic(r)
+		}
+	}()
+
+	persona := models.Persona{
+		Nombre:          input.Persona.Nombre,
+		ApellidoPaterno: input.Persona.ApellidoPaterno,
+		ApellidoMaterno: input.Persona.ApellidoMaterno,
+		Telefono:        input.Persona.Telefono,
+		FechaNacimiento: fechaNac,
+		Genero:          input.Persona.Genero,
+		Direccion:       input.Persona.Direccion,
+	}
+	if err := tx.Create(&persona).Error; err != nil {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al crear persona: "+err.Error())
+		return
+	}
+
+	usuario := models.Usuario{
+		PersonaID: persona.ID,
+		Rol:       "medico",
+		Correo:    input.Correo,
+	}
+	if err := tx.Create(&usuario).Error; err != nil {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusConflict, "No se pudo crear el usuario: "+err.Error())
+		return
+	}
+
+	medico := models.Medico{
+		UsuarioID:         usuario.ID,
+		Especialidad:      strings.TrimSpace(input.Especialidades[0]),
+		CedulaProfesional: strings.TrimSpace(input.Cedula),
+	}
+	if err := tx.Create(&medico).Error; err != nil {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al guardar médico: "+err.Error())
+		return
+	}
+
+	if err := asignarEspecialidades(tx, &medico, input.Especialidades); err != nil {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al asignar especialidades: "+err.Error())
+		return
+	}
+
+	for _, bloque := range input.Horarios {
+		horario := models.Horario{
+			MedicoID:   medico.ID,
+			DiaSemana:  bloque.DiaSemana,
+			HoraInicio: bloque.HoraInicio,
+			HoraFin:    bloque.HoraFin,
+		}
+		if err := tx.Create(&horario).Error; err != nil {
+			tx.Rollback()
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al guardar horario: "+err.Error())
+			return
+		}
+	}
+
+	token, err := crearTokenUsuario(tx, usuario.ID, "invitacion")
+	if err != nil {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al generar invitación: "+err.Error())
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al confirmar transacción: "+err.Error())
+		return
+	}
+
+	// Fuera de la transacción, para no retener los bloqueos durante el envío
+	enviado := enviarCorreoToken(usuario, "invitacion", token)
+
+	if err := initializers.GetDB().
+		Preload("Usuario").
+		Preload("Usuario.Persona").
+		Preload("Especialidades").
+		Preload("Horarios").
+		First(&medico, medico.ID).Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al cargar datos del médico: "+err.Error())
+		return
+	}
+
+	medico.Usuario.Contrasena = ""
+	respuestas.RespondSuccess(c, http.StatusCreated, gin.H{
+		"medico":             medico,
+		"invitacion_enviada": enviado,
+	})
+}
+
 // PostMedico crea un nuevo médico
 func PostMedico(c *gin.Context) {
 	var input MedicoInput
@@ -60,6 +245,12 @@ func PostMedico(c *gin.Context) {
 		return
 	}
 
+	if err := asignarEspecialidades(tx, &medico, []string{input.Especialidad}); err != nil {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al asignar especialidad: "+err.Error())
+		return
+	}
+
 	if err := tx.Commit().Error; err != nil {
 		respuestas.RespondError(c, http.StatusInternalServerError, "Error al confirmar transacción: "+err.Error())
 		return
@@ -86,6 +277,7 @@ func GetMedico(c *gin.Context) {
 	result := initializers.GetDB().
 		Preload("Usuario").
 		Preload("Usuario.Persona").
+		Preload("Especialidades").
 		Preload("Horarios").
 		First(&medico, id)
 
@@ -107,6 +299,7 @@ func GetAllMedicos(c *gin.Context) {
 	result := initializers.GetDB().
 		Preload("Usuario").
 		Preload("Usuario.Persona").
+		Preload("Especialidades").
 		Find(&medicos)
 
 	if result.Error != nil {
@@ -127,6 +320,9 @@ func UpdateMedico(c *gin.Context) {
 
 	var input struct {
 		Especialidad string `json:"especialidad" binding:"max=100"`
+		// Si se envía, reemplaza todas las especialidades; la primera es la principal
+		Especialidades []string `json:"especialidades" binding:"omitempty,min=1,dive,required,max=100"`
+		Cedula         string   `json:"cedula_profesional" binding:"max=20"`
 	}
 
 	if err := c.ShouldBindJSON(&input); err != nil {
@@ -151,24 +347,55 @@ func UpdateMedico(c *gin.Context) {
 		return
 	}
 
+	// Especialidades asociadas: la lista completa si se envía; si solo cambia la
+	// principal, la anterior se reemplaza y se conservan las demás
+	var especialidades []string
+	if len(input.Especialidades) > 0 {
+		especialidades = input.Especialidades
+	} else if nueva := strings.TrimSpace(input.Especialidad); nueva != "" {
+		var actuales []models.Especialidad
+		if err := tx.Model(&medico).Association("Especialidades").Find(&actuales); err != nil {
+			tx.Rollback()
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al buscar especialidades: "+err.Error())
+			return
+		}
+		especialidades = []string{nueva}
+		for _, e := range actuales {
+			if e.Nombre != medico.Especialidad && e.Nombre != nueva {
+				especialidades = append(especialidades, e.Nombre)
+			}
+		}
+	}
+
 	// Actualizar solo los campos proporcionados
-	if input.Especialidad != "" {
-		medico.Especialidad = input.Especialidad
+	if len(especialidades) > 0 {
+		medico.Especialidad = strings.TrimSpace(especialidades[0])
+	}
+	if input.Cedula != "" {
+		medico.CedulaProfesional = input.Cedula
 	}
 
-	if err := tx.Save(&medico).Error; err != nil {
+	if err := tx.Omit("Especialidades").Save(&medico).Error; err != nil {
 		tx.Rollback()
 		respuestas.RespondError(c, http.StatusInternalServerError, "Error al actualizar médico: "+err.Error())
 		return
 	}
 
+	if len(especialidades) > 0 {
+		if err := asignarEspecialidades(tx, &medico, especialidades); err != nil {
+			tx.Rollback()
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al asignar especialidades: "+err.Error())
+			return
+		}
+	}
+
 	if err := tx.Commit().Error; err != nil {
 		respuestas.RespondError(c, http.StatusInternalServerError, "Error al confirmar transacción: "+err.Error())
 		return
 	}
 
 	// Cargar datos actualizados para la respuesta
-	if err := initializers.GetDB().Preload("Usuario").Preload("Usuario.Persona").First(&medico, medico.ID).Error; err != nil {
+	if err := initializers.GetDB().Preload("Usuario").Preload("Usuario.Persona").Preload("Especialidades").First(&medico, medico.ID).Error; err != nil {
 		respuestas.RespondError(c, http.StatusInternalServerError, "Error al cargar datos actualizados: "+err.Error())
 		return
 	}
@@ -216,14 +443,14 @@ func DeleteMedico(c *gin.Context) {
 		return
 	}
 
-	result := tx.Delete(&models.Medico{}, id)
-	if result.Error != nil {
+	eliminados, err := eliminarMedico(tx, uint(id))
+	if err != nil {
 		tx.Rollback()
-		respuestas.RespondError(c, http.StatusInternalServerError, "Error al eliminar médico: "+result.Error.Error())
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al eliminar médico: "+err.Error())
 		return
 	}
 
-	if result.RowsAffected == 0 {
+	if eliminados == 0 {
 		tx.Rollback()
 		respuestas.RespondError(c, http.StatusNotFound, "Médico no encontrado")
 		return
@@ -288,3 +515,9 @@ func GetMedicosDisponibles(c *gin.Context) {
 
 	respuestas.RespondSuccess(c, http.StatusOK, medicos)
 }
+
+// IDs de los médicos con una especialidad, principal o adicional; recibe el
+// nombre dos veces
+const subconsultaMedicosEspecialidad = `SELECT id FROM medicos WHERE especialidad = ?
+	UNION
+	SELECT me.medico_id FROM medico_especialidades me JOIN especialidads e ON e.id = me.especialidad_id WHERE e.nombre = ?`
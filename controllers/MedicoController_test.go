@@ -0,0 +1,40 @@
+package controllers
+
+import (
+	"strings"
+	"testing"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestEliminarMedicoLiberaEspecialidades(t *testing.T) {
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost"}), &gorm.Config{
+		DryRun:                 true,
+		SkipDefaultTransaction: true,
+		DisableAutomaticPing:   true,
+		Logger:                 logger.Discard,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var sentencias []string
+	registrar := func(tx *gorm.DB) { sentencias = append(sentencias, tx.Statement.SQL.String()) }
+	db.Callback().Delete().After("gorm:delete").Register("prueba:registrar", registrar)
+
+	if _, err := eliminarMedico(db, 7); err != nil {
+		t.Fatalf("error inesperado: %v", err)
+	}
+
+	if len(sentencias) != 2 {
+		t.Fatalf("sentencias = %q, se esperaban 2", sentencias)
+	}
+	if !strings.Contains(sentencias[0], `"medico_especialidades"`) {
+		t.Errorf("primero debe borrar la tabla de unión, se obtuvo %q", sentencias[0])
+	}
+	if !strings.Contains(sentencias[1], `"medicos"`) {
+		t.Errorf("después debe borrar el médico, se obtuvo %q", sentencias[1])
+	}
+}
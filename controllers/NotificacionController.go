@@ -1,14 +1,13 @@
 package controllers
 
 import (
-	"fmt"
 	"net/http"
 	"strconv"
 	"time"
 
 	"github.com/Ilimm9/CMedicas/Respuestas"
-	"github.com/Ilimm9/CMedicas/clave"
 	"github.com/Ilimm9/CMedicas/initializers"
+	"github.com/Ilimm9/CMedicas/mensajeria"
 	"github.com/Ilimm9/CMedicas/models"
 
 	"github.com/gin-gonic/gin"
@@ -18,8 +17,10 @@ import (
 type NotificacionInput struct {
 	IDUsuario uint   `json:"id_usuario" binding:"required"`
 	CitaID    uint   `json:"cita_id" binding:"required"`
-	Tipo      string `json:"tipo" binding:"required"`
-	Mensaje   string `json:"mensaje" binding:"required"`
+	Tipo      string `json:"tipo" binding:"required,oneof=confirmación recordatorio cancelación reprogramación completada reasignación"`
+	// Si se omite, el texto sale de la plantilla del tipo en el idioma del usuario
+	Mensaje   string `json:"mensaje" binding:"omitempty,max=500"`
+	Asunto    string `json:"asunto" binding:"omitempty,max=200"`
 }
 
 // Crear notificación
@@ -43,8 +44,8 @@ func PostNotificacion(c *gin.Context) {
 	}
 
 	// Verificar que la cita existe
-	var cita models.Cita
-	if err := initializers.GetDB().First(&cita, input.CitaID).Error; err != nil {
+	cita, err := mensajeria.CargarCitaParaPlantilla(initializers.GetDB(), input.CitaID)
+	if err != nil {
 		if err == gorm.ErrRecordNotFound {
 			respuestas.RespondError(c, http.StatusBadRequest, "Cita no encontrada")
 		} else {
@@ -60,14 +61,29 @@ func PostNotificacion(c *gin.Context) {
 	}
 
 	notificacion := models.Notificacion{
-		IDUsuario:  input.IDUsuario,
-		CitaID:     input.CitaID,
-		Tipo:       input.Tipo,
-		Mensaje:    input.Mensaje,
-		FechaEnvio: time.Now(),
+		IDUsuario: input.IDUsuario,
+		CitaID:    input.CitaID,
+		Tipo:      input.Tipo,
+		Asunto:    input.Asunto,
+		Mensaje:   input.Mensaje,
+	}
+	if input.Mensaje == "" {
+		destino := "paciente"
+		if input.IDUsuario == cita.Medico.UsuarioID {
+			destino = "medico"
+		}
+		notificacion, err = mensajeria.ComponerNotificacion(tx, input.IDUsuario, input.Tipo, destino, cita, nil)
+		if err != nil {
+			tx.Rollback()
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al componer notificación: "+err.Error())
+			return
+		}
 	}
+	notificacion.FechaEnvio = time.Now()
 
-	if err := tx.Create(&notificacion).Error; err != nil {
+	// El envío lo hace el trabajador de mensajería, fuera de la petición, y respeta
+	// las preferencias del usuario (tipos desactivados, horas de silencio)
+	if err := mensajeria.Encolar(tx, &notificacion); err != nil {
 		tx.Rollback()
 		respuestas.RespondError(c, http.StatusInternalServerError, "Error al guardar notificación: "+err.Error())
 		return
@@ -90,18 +106,6 @@ func PostNotificacion(c *gin.Context) {
 		return
 	}
 
-	// Enviar correo si hay correo del usuario
-	if notificacion.Usuario.Correo != "" {
-		err := clave.EnviarCorreo(
-			notificacion.Usuario.Correo,
-			"Notificación "+notificacion.Tipo,
-			notificacion.Mensaje,
-		)
-		if err != nil {
-			fmt.Println("Error al enviar correo:", err)
-		}
-	}
-
 	respuestas.RespondSuccess(c, http.StatusCreated, notificacion)
 }
 
@@ -193,7 +197,7 @@ func UpdateNotificacion(c *gin.Context) {
 	}
 
 	var input struct {
-		Tipo    string `json:"tipo" binding:"omitempty,oneof=confirmación recordatorio cancelación"`
+		Tipo    string `json:"tipo" binding:"omitempty,oneof=confirmación recordatorio cancelación reprogramación completada reasignación"`
 		Mensaje string `json:"mensaje" binding:"omitempty,max=500"`
 	}
 
@@ -286,11 +290,222 @@ func DeleteNotificacion(c *gin.Context) {
 	respuestas.RespondSuccess(c, http.StatusOK, gin.H{"message": "Notificación eliminada correctamente"})
 }
 
-// func GetNotificacionesUsuario(c *gin.Context) {
-//     idUsuario := c.Param("id") // o extraído del token
+// Listar notificaciones por estado de entrega (por defecto, las fallidas)
+func GetNotificacionesPorEstado(c *gin.Context) {
+	estado := c.DefaultQuery("estado", "fallida")
+
+	var notificaciones []models.Notificacion
+	result := initializers.GetDB().
+		Preload("Usuario").
+		Preload("Usuario.Persona").
+		Where("estado = ?", estado).
+		Order("proximo_intento DESC").
+		Find(&notificaciones)
+
+	if result.Error != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al obtener notificaciones: "+result.Error.Error())
+		return
+	}
+
+	for i := range notificaciones {
+		notificaciones[i].Usuario.Contrasena = ""
+	}
+
+	respuestas.RespondSuccess(c, http.StatusOK, notificaciones)
+}
+
+// Volver a poner en cola una notificación fallida
+func ReintentarNotificacion(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, "ID inválido")
+		return
+	}
+
+	filas, err := mensajeria.Reintentar(initializers.GetDB(), uint(id))
+	if err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al reintentar notificación: "+err.Error())
+		return
+	}
+
+	if filas == 0 {
+		respuestas.RespondError(c, http.StatusNotFound, "No hay una notificación fallida con ese ID")
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusOK, gin.H{"message": "Notificación puesta en cola nuevamente"})
+}
+
+// ================== Bandeja del usuario autenticado ==================
+// Todas las consultas filtran por id_usuario del token: nadie ve filas ajenas.
 
-//     var notificaciones []models.Notificacion
-//     db.Where("id_usuario = ?", idUsuario).Order("fecha_envio DESC").Find(&notificaciones)
+const (
+	porPaginaDef = 20
+	porPaginaMax = 100
+)
 
-//     c.JSON(http.StatusOK, notificaciones)
-// }
+// Página (?pagina=1) y tamaño (?por_pagina=20) de un listado
+func paginacion(c *gin.Context) (int, int) {
+	pagina, err := strconv.Atoi(c.DefaultQuery("pagina", "1"))
+	if err != nil || pagina < 1 {
+		pagina = 1
+	}
+	porPagina, err := strconv.Atoi(c.DefaultQuery("por_pagina", strconv.Itoa(porPaginaDef)))
+	if err != nil || porPagina < 1 {
+		porPagina = porPaginaDef
+	}
+	if porPagina > porPaginaMax {
+		porPagina = porPaginaMax
+	}
+	return pagina, porPagina
+}
+
+// Notificaciones visibles en la bandeja: solo las entregadas. Las pendientes
+// (incluidas las retenidas por horas de silencio), fallidas o descartadas
+// todavía no le llegaron al usuario
+func bandejaUsuario(usuarioID uint) *gorm.DB {
+	return initializers.GetDB().Model(&models.Notificacion{}).
+		Where("id_usuario = ? AND estado = ?", usuarioID, "enviada")
+}
+
+// Listar las notificaciones propias. Filtros: ?tipo=, ?no_leidas=true,
+// ?archivadas=true (por defecto solo las no archivadas)
+func GetNotificacionesUsuarioActual(c *gin.Context) {
+	usuarioID, ok := usuarioActualID(c)
+	if !ok {
+		respuestas.RespondError(c, http.StatusUnauthorized, "Usuario no autenticado")
+		return
+	}
+	pagina, porPagina := paginacion(c)
+
+	consulta := bandejaUsuario(usuarioID)
+	if c.Query("archivadas") == "true" {
+		consulta = consulta.Where("archivada_en IS NOT NULL")
+	} else {
+		consulta = consulta.Where("archivada_en IS NULL")
+	}
+	if tipo := c.Query("tipo"); tipo != "" {
+		consulta = consulta.Where("tipo = ?", tipo)
+	}
+	if c.Query("no_leidas") == "true" {
+		consulta = consulta.Where("leida_en IS NULL")
+	}
+
+	var total int64
+	if err := consulta.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al contar notificaciones: "+err.Error())
+		return
+	}
+
+	var notificaciones []models.Notificacion
+	if err := consulta.
+		Order("fecha_envio DESC").
+		Offset((pagina - 1) * porPagina).
+		Limit(porPagina).
+		Find(&notificaciones).Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al obtener notificaciones: "+err.Error())
+		return
+	}
+
+	var noLeidas int64
+	if err := bandejaUsuario(usuarioID).Where("leida_en IS NULL AND archivada_en IS NULL").Count(&noLeidas).Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al contar notificaciones: "+err.Error())
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusOK, gin.H{
+		"notificaciones": notificaciones,
+		"total":          total,
+		"no_leidas":      noLeidas,
+		"pagina":         pagina,
+		"por_pagina":     porPagina,
+	})
+}
+
+// Número de notificaciones sin leer (para el indicador de la interfaz)
+func GetConteoNoLeidas(c *gin.Context) {
+	usuarioID, ok := usuarioActualID(c)
+	if !ok {
+		respuestas.RespondError(c, http.StatusUnauthorized, "Usuario no autenticado")
+		return
+	}
+
+	var noLeidas int64
+	if err := bandejaUsuario(usuarioID).Where("leida_en IS NULL AND archivada_en IS NULL").Count(&noLeidas).Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al contar notificaciones: "+err.Error())
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusOK, gin.H{"no_leidas": noLeidas})
+}
+
+// Aplica cambios a una notificación propia; las marcas de tiempo ya puestas se conservan
+func marcarNotificacionPropia(c *gin.Context, cambios map[string]interface{}, mensaje string) {
+	usuarioID, ok := usuarioActualID(c)
+	if !ok {
+		respuestas.RespondError(c, http.StatusUnauthorized, "Usuario no autenticado")
+		return
+	}
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, "ID inválido")
+		return
+	}
+
+	var notificacion models.Notificacion
+	if err := bandejaUsuario(usuarioID).Where("id = ?", id).First(&notificacion).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			respuestas.RespondError(c, http.StatusNotFound, "Notificación no encontrada")
+		} else {
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al buscar notificación: "+err.Error())
+		}
+		return
+	}
+
+	if err := initializers.GetDB().Model(&notificacion).Updates(cambios).Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al actualizar notificación: "+err.Error())
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusOK, gin.H{"message": mensaje})
+}
+
+// Marcar una notificación propia como leída
+func MarcarNotificacionLeida(c *gin.Context) {
+	ahora := time.Now()
+	marcarNotificacionPropia(c, map[string]interface{}{
+		"leida_en": gorm.Expr("COALESCE(leida_en, ?)", ahora),
+	}, "Notificación marcada como leída")
+}
+
+// Archivar una notificación propia (también cuenta como leída)
+func ArchivarNotificacion(c *gin.Context) {
+	ahora := time.Now()
+	marcarNotificacionPropia(c, map[string]interface{}{
+		"leida_en":     gorm.Expr("COALESCE(leida_en, ?)", ahora),
+		"archivada_en": gorm.Expr("COALESCE(archivada_en, ?)", ahora),
+	}, "Notificación archivada")
+}
+
+// Marcar todas las notificaciones propias como leídas (opcionalmente solo las de ?tipo=)
+func MarcarTodasNotificacionesLeidas(c *gin.Context) {
+	usuarioID, ok := usuarioActualID(c)
+	if !ok {
+		respuestas.RespondError(c, http.StatusUnauthorized, "Usuario no autenticado")
+		return
+	}
+
+	consulta := bandejaUsuario(usuarioID).Where("leida_en IS NULL")
+	if tipo := c.Query("tipo"); tipo != "" {
+		consulta = consulta.Where("tipo = ?", tipo)
+	}
+
+	result := consulta.Update("leida_en", time.Now())
+	if result.Error != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al actualizar notificaciones: "+result.Error.Error())
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusOK, gin.H{"marcadas": result.RowsAffected})
+}
@@ -1,22 +1,290 @@
 package controllers
 
 import (
+	"encoding/json"
+	"fmt"
+	"math"
 	"net/http"
+	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/Ilimm9/CMedicas/Respuestas"
+	"github.com/Ilimm9/CMedicas/clave"
+	"github.com/Ilimm9/CMedicas/eventos"
 	"github.com/Ilimm9/CMedicas/initializers"
 	"github.com/Ilimm9/CMedicas/models"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type ObservacionInput struct {
 	CitaID        uint   `json:"cita_id" binding:"required"`
-	Observaciones string `json:"observaciones" binding:"required"`
+	Observaciones string `json:"observaciones"`
 	Diagnostico   string `json:"diagnostico"`
+	// Nota estructurada; basta con las observaciones en texto libre o con alguna sección
+	MotivoConsulta    string              `json:"motivo_consulta"`
+	ExploracionFisica string              `json:"exploracion_fisica"`
+	Evaluacion        string              `json:"evaluacion"`
+	Plan              string              `json:"plan"`
+	SignosVitales     *SignosVitalesInput `json:"signos_vitales"`
+	Diagnosticos      []DiagnosticoInput  `json:"diagnosticos" binding:"omitempty,dive"`
+}
+
+// Signos vitales con su unidad; se guardan en mmHg, lpm, °C, kg y cm
+type SignosVitalesInput struct {
+	PresionSistolica   *int     `json:"presion_sistolica"`
+	PresionDiastolica  *int     `json:"presion_diastolica"`
+	FrecuenciaCardiaca *int     `json:"frecuencia_cardiaca"`
+	Temperatura        *float64 `json:"temperatura"`
+	UnidadTemperatura  string   `json:"unidad_temperatura" binding:"omitempty,oneof=C F"`
+	Peso               *float64 `json:"peso"`
+	UnidadPeso         string   `json:"unidad_peso" binding:"omitempty,oneof=kg lb"`
+	Talla              *float64 `json:"talla"`
+	UnidadTalla        string   `json:"unidad_talla" binding:"omitempty,oneof=cm m in"`
+}
+
+// Rangos fisiológicamente posibles; fuera de ellos se asume un error de captura
+var rangosSignosVitales = map[string][2]float64{
+	"presión sistólica":   {50, 300},
+	"presión diastólica":  {20, 200},
+	"frecuencia cardiaca": {20, 250},
+	"temperatura":         {30, 45},
+	"peso":                {0.3, 500},
+	"talla":               {20, 260},
+}
+
+func validarRango(nombre string, valor float64) error {
+	r := rangosSignosVitales[nombre]
+	if valor < r[0] || valor > r[1] {
+		return fmt.Errorf("%s fuera de rango (%g a %g)", nombre, r[0], r[1])
+	}
+	return nil
+}
+
+// Aplica los signos vitales capturados sobre los anteriores (los omitidos se
+// conservan), convierte unidades, valida rangos y recalcula el IMC
+func aplicarSignosVitales(anteriores models.SignosVitales, input *SignosVitalesInput) (models.SignosVitales, error) {
+	signos := anteriores
+	if input == nil {
+		return signos, nil
+	}
+
+	if input.PresionSistolica != nil {
+		signos.PresionSistolica = input.PresionSistolica
+	}
+	if input.PresionDiastolica != nil {
+		signos.PresionDiastolica = input.PresionDiastolica
+	}
+	if input.FrecuenciaCardiaca != nil {
+		signos.FrecuenciaCardiaca = input.FrecuenciaCardiaca
+	}
+	if input.Temperatura != nil {
+		t := *input.Temperatura
+		if input.UnidadTemperatura == "F" {
+			t = (t - 32) * 5 / 9
+		}
+		t = redondear(t, 1)
+		signos.Temperatura = &t
+	}
+	if input.Peso != nil {
+		p := *input.Peso
+		if input.UnidadPeso == "lb" {
+			p *= 0.45359237
+		}
+		p = redondear(p, 2)
+		signos.Peso = &p
+	}
+	if input.Talla != nil {
+		t := *input.Talla
+		switch input.UnidadTalla {
+		case "m":
+			t *= 100
+		case "in":
+			t *= 2.54
+		}
+		t = redondear(t, 1)
+		signos.Talla = &t
+	}
+
+	enteros := map[string]*int{
+		"presión sistólica":   signos.PresionSistolica,
+		"presión diastólica":  signos.PresionDiastolica,
+		"frecuencia cardiaca": signos.FrecuenciaCardiaca,
+	}
+	for nombre, valor := range enteros {
+		if valor != nil {
+			if err := validarRango(nombre, float64(*valor)); err != nil {
+				return signos, err
+			}
+		}
+	}
+	decimales := map[string]*float64{
+		"temperatura": signos.Temperatura,
+		"peso":        signos.Peso,
+		"talla":       signos.Talla,
+	}
+	for nombre, valor := range decimales {
+		if valor != nil {
+			if err := validarRango(nombre, *valor); err != nil {
+				return signos, err
+			}
+		}
+	}
+
+	if (signos.PresionSistolica == nil) != (signos.PresionDiastolica == nil) {
+		return signos, fmt.Errorf("indique la presión sistólica y la diastólica")
+	}
+	if signos.PresionSistolica != nil && *signos.PresionSistolica <= *signos.PresionDiastolica {
+		return signos, fmt.Errorf("la presión sistólica debe ser mayor que la diastólica")
+	}
+
+	signos.IMC = nil
+	if signos.Peso != nil && signos.Talla != nil {
+		metros := *signos.Talla / 100
+		imc := redondear(*signos.Peso/(metros*metros), 1)
+		signos.IMC = &imc
+	}
+	return signos, nil
+}
+
+func redondear(valor float64, decimales int) float64 {
+	factor := math.Pow(10, float64(decimales))
+	return math.Round(valor*factor) / factor
+}
+
+func contenidoObservacion(observacion models.Observacion) models.ContenidoObservacion {
+	diagnosticos := []models.DiagnosticoVersionado{}
+	for _, d := range observacion.Diagnosticos {
+		diagnosticos = append(diagnosticos, models.DiagnosticoVersionado{
+			Codigo:      d.Catalogo.Codigo,
+			Descripcion: d.Catalogo.Descripcion,
+			Tipo:        d.Tipo,
+			Nota:        d.Nota,
+		})
+	}
+	return models.ContenidoObservacion{
+		Observaciones:     observacion.Observaciones,
+		Diagnostico:       observacion.Diagnostico,
+		MotivoConsulta:    observacion.MotivoConsulta,
+		ExploracionFisica: observacion.ExploracionFisica,
+		Evaluacion:        observacion.Evaluacion,
+		Plan:              observacion.Plan,
+		SignosVitales:     observacion.SignosVitales,
+		Diagnosticos:      diagnosticos,
+	}
+}
+
+// Guarda la versión actual de la observación. Si ya existe (observaciones
+// anteriores al versionado que aún no tenían copia) no se duplica.
+func registrarVersionObservacion(tx *gorm.DB, observacion models.Observacion, editadaPor *uint) error {
+	contenido, err := json.Marshal(contenidoObservacion(observacion))
+	if err != nil {
+		return err
+	}
+	return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.ObservacionVersion{
+		ObservacionID: observacion.ID,
+		Version:       observacion.Version,
+		Contenido:     string(contenido),
+		EditadaPor:    editadaPor,
+	}).Error
+}
+
+// La observación es de una cita del médico
+func observacionDeMedico(db *gorm.DB, observacion models.Observacion, medico models.Medico) (bool, error) {
+	var cita models.Cita
+	if err := db.Select("id", "medico_id").First(&cita, observacion.CitaID).Error; err != nil {
+		return false, err
+	}
+	return cita.MedicoID == medico.ID, nil
+}
+
+// Sigue dentro del plazo de edición; las anteriores a este plazo no lo tienen
+func observacionEditable(observacion models.Observacion) bool {
+	return observacion.EditableHasta != nil && time.Now().Before(*observacion.EditableHasta)
+}
+
+// Observación de :id si el usuario autenticado puede ver el historial del paciente
+func observacionVisible(c *gin.Context) (models.Observacion, bool) {
+	var observacion models.Observacion
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, "ID inválido")
+		return observacion, false
+	}
+
+	if err := initializers.GetDB().Preload("Cita").First(&observacion, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			respuestas.RespondError(c, http.StatusNotFound, "Observación no encontrada")
+		} else {
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al buscar observación: "+err.Error())
+		}
+		return observacion, false
+	}
+
+	personaID, err := personaDeCita(initializers.GetDB(), observacion.Cita)
+	if err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al buscar paciente: "+err.Error())
+		return observacion, false
+	}
+	permitido, err := puedeVerHistorial(c, personaID)
+	if err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al verificar acceso: "+err.Error())
+		return observacion, false
+	}
+	// Sin acceso se responde igual que si no existiera
+	if !permitido {
+		respuestas.RespondError(c, http.StatusNotFound, "Observación no encontrada")
+		return observacion, false
+	}
+	return observacion, true
+}
+
+// Pide la contraseña del médico antes de firmar a su nombre
+func confirmarContrasenaMedico(c *gin.Context, medico models.Medico, contrasena string) (models.Usuario, bool) {
+	var usuario models.Usuario
+	if err := initializers.GetDB().Select("id", "contrasena").First(&usuario, medico.UsuarioID).Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al buscar usuario: "+err.Error())
+		return usuario, false
+	}
+	if !clave.CheckPasswordHash(contrasena, usuario.Contrasena) {
+		respuestas.RespondError(c, http.StatusUnauthorized, "Credenciales inválidas")
+		return usuario, false
+	}
+	return usuario, true
+}
+
+// Usuario autenticado que registra el cambio (nil si no se conoce)
+func actorActual(c *gin.Context) *uint {
+	if id, ok := usuarioActualID(c); ok {
+		return &id
+	}
+	return nil
+}
+
+// Plazo en que el médico puede corregir su observación
+// (OBSERVACION_VENTANA_EDICION, p. ej. "48h"; 24 horas por omisión)
+func ventanaEdicionObservacion() time.Duration {
+	if d, err := time.ParseDuration(os.Getenv("OBSERVACION_VENTANA_EDICION")); err == nil && d > 0 {
+		return d
+	}
+	return 24 * time.Hour
+}
+
+func precargarObservacion(db *gorm.DB) *gorm.DB {
+	return db.
+		Preload("Cita").
+		Preload("Cita.Paciente").
+		Preload("Cita.Paciente.Persona").
+		Preload("Cita.Medico").
+		Preload("Cita.Medico.Usuario").
+		Preload("Cita.Medico.Usuario.Persona").
+		Preload("Diagnosticos.Catalogo").
+		Preload("Adendas", func(db *gorm.DB) *gorm.DB { return db.Order("creada_en") })
 }
 
 // Crear  observación
@@ -28,6 +296,46 @@ func PostObservacion(c *gin.Context) {
 		return
 	}
 
+	crearObservacion(c, input, nil)
+}
+
+// El médico registra la observación de una de sus citas
+func PostObservacionMedico(c *gin.Context) {
+	medico, ok := medicoActual(c)
+	if !ok {
+		respuestas.RespondError(c, http.StatusForbidden, "Solo los médicos pueden registrar observaciones")
+		return
+	}
+
+	var input ObservacionInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	crearObservacion(c, input, &medico)
+}
+
+// Crea la observación de la cita; con medico solo si la cita es suya
+func crearObservacion(c *gin.Context, input ObservacionInput, medico *models.Medico) {
+	if input.Observaciones == "" && input.MotivoConsulta == "" && input.ExploracionFisica == "" &&
+		input.Evaluacion == "" && input.Plan == "" && input.SignosVitales == nil && len(input.Diagnosticos) == 0 {
+		respuestas.RespondError(c, http.StatusBadRequest, "La observación está vacía")
+		return
+	}
+
+	signos, err := aplicarSignosVitales(models.SignosVitales{}, input.SignosVitales)
+	if err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, "Signos vitales inválidos: "+err.Error())
+		return
+	}
+
+	diagnosticos, err := resolverDiagnosticos(initializers.GetDB(), input.Diagnosticos)
+	if err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, "Diagnósticos inválidos: "+err.Error())
+		return
+	}
+
 	// Verificar que la cita existe
 	var cita models.Cita
 	if err := initializers.GetDB().First(&cita, input.CitaID).Error; err != nil {
@@ -39,8 +347,16 @@ func PostObservacion(c *gin.Context) {
 		return
 	}
 
+	if medico != nil && cita.MedicoID != medico.ID {
+		respuestas.RespondError(c, http.StatusForbidden, "Solo el médico de la cita puede registrar su observación")
+		return
+	}
+
+	// Al registrar la observación el médico da por atendida una cita ya iniciada
+	completar := medico != nil && cita.Estado == "programada" && !cita.FechaCita.After(time.Now())
+
 	// Verificar que la cita esté en estado "completada"
-	if cita.Estado != "completada" {
+	if cita.Estado != "completada" && !completar {
 		respuestas.RespondError(c, http.StatusBadRequest, "Solo se pueden agregar observaciones a citas completadas")
 		return
 	}
@@ -51,16 +367,60 @@ func PostObservacion(c *gin.Context) {
 		return
 	}
 
+	var cambios []eventos.Evento
+	if completar {
+		anterior := cita
+		cita.Estado = "completada"
+		if err := tx.Model(&cita).Update("estado", cita.Estado).Error; err != nil {
+			tx.Rollback()
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al completar cita: "+err.Error())
+			return
+		}
+		actorID, _ := usuarioActualID(c)
+		cambios = eventos.CambiosCita(anterior, cita, actorID)
+		for _, ev := range cambios {
+			if err := eventos.Emitir(tx, ev); err != nil {
+				tx.Rollback()
+				respuestas.RespondError(c, http.StatusInternalServerError, "Error al registrar evento de cita: "+err.Error())
+				return
+			}
+		}
+	}
+
 	observacion := models.Observacion{
-		CitaID:        input.CitaID,
-		Observaciones: input.Observaciones,
-		Diagnostico:   input.Diagnostico,
-		FechaRegistro: time.Now(),
+		CitaID:            input.CitaID,
+		Observaciones:     input.Observaciones,
+		Diagnostico:       input.Diagnostico,
+		FechaRegistro:     time.Now(),
+		MotivoConsulta:    input.MotivoConsulta,
+		ExploracionFisica: input.ExploracionFisica,
+		Evaluacion:        input.Evaluacion,
+		Plan:              input.Plan,
+		SignosVitales:     signos,
+		Version:           1,
 	}
+	editableHasta := observacion.FechaRegistro.Add(ventanaEdicionObservacion())
+	observacion.EditableHasta = &editableHasta
 
 	if err := tx.Create(&observacion).Error; err != nil {
 		tx.Rollback()
-		respuestas.RespondError(c, http.StatusInternalServerError, "Error al guardar observación: "+err.Error())
+		if strings.Contains(err.Error(), "duplicate key") {
+			respuestas.RespondError(c, http.StatusConflict, "La cita ya tiene observación")
+		} else {
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al guardar observación: "+err.Error())
+		}
+		return
+	}
+
+	if err := guardarDiagnosticos(tx, &observacion, diagnosticos); err != nil {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al guardar diagnósticos: "+err.Error())
+		return
+	}
+
+	if err := registrarVersionObservacion(tx, observacion, actorActual(c)); err != nil {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al guardar versión: "+err.Error())
 		return
 	}
 
@@ -68,14 +428,9 @@ func PostObservacion(c *gin.Context) {
 		respuestas.RespondError(c, http.StatusInternalServerError, "Error al confirmar transacción: "+err.Error())
 		return
 	}
+	eventos.Confirmados(cambios...)
 
-	if err := initializers.GetDB().
-		Preload("Cita").
-		Preload("Cita.Paciente").
-		Preload("Cita.Paciente.Persona").
-		Preload("Cita.Medico").
-		Preload("Cita.Medico.Usuario").
-		Preload("Cita.Medico.Usuario.Persona").
+	if err := precargarObservacion(initializers.GetDB()).
 		First(&observacion, observacion.ID).Error; err != nil {
 		respuestas.RespondError(c, http.StatusInternalServerError, "Error al cargar datos de la observación: "+err.Error())
 		return
@@ -84,52 +439,41 @@ func PostObservacion(c *gin.Context) {
 	respuestas.RespondSuccess(c, http.StatusCreated, observacion)
 }
 
-// Obtener una observación por ID
-func GetObservacion(c *gin.Context) {
-	id, err := strconv.Atoi(c.Param("id"))
+// Observación de una cita específica
+func GetObservacionPorCita(c *gin.Context) {
+	citaID, err := strconv.Atoi(c.Param("cita_id"))
 	if err != nil {
-		respuestas.RespondError(c, http.StatusBadRequest, "ID inválido")
+		respuestas.RespondError(c, http.StatusBadRequest, "ID de cita inválido")
 		return
 	}
 
-	var observacion models.Observacion
-	result := initializers.GetDB().
-		Preload("Cita").
-		Preload("Cita.Paciente").
-		Preload("Cita.Paciente.Persona").
-		Preload("Cita.Medico").
-		Preload("Cita.Medico.Usuario").
-		Preload("Cita.Medico.Usuario.Persona").
-		First(&observacion, id)
-
-	if result.Error != nil {
-		if result.Error == gorm.ErrRecordNotFound {
-			respuestas.RespondError(c, http.StatusNotFound, "Observación no encontrada")
+	var cita models.Cita
+	if err := initializers.GetDB().First(&cita, citaID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			respuestas.RespondError(c, http.StatusNotFound, "No se encontró observación para esta cita")
 		} else {
-			respuestas.RespondError(c, http.StatusInternalServerError, "Error al buscar observación: "+result.Error.Error())
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al buscar cita: "+err.Error())
 		}
 		return
 	}
-
-	respuestas.RespondSuccess(c, http.StatusOK, observacion)
-}
-
-// Observación de una cita específica
-func GetObservacionPorCita(c *gin.Context) {
-	citaID, err := strconv.Atoi(c.Param("cita_id"))
+	personaID, err := personaDeCita(initializers.GetDB(), cita)
 	if err != nil {
-		respuestas.RespondError(c, http.StatusBadRequest, "ID de cita inválido")
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al buscar paciente: "+err.Error())
+		return
+	}
+	permitido, err := puedeVerHistorial(c, personaID)
+	if err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al verificar acceso: "+err.Error())
+		return
+	}
+	// Sin acceso se responde igual que si no existiera
+	if !permitido {
+		respuestas.RespondError(c, http.StatusNotFound, "No se encontró observación para esta cita")
 		return
 	}
 
 	var observacion models.Observacion
-	result := initializers.GetDB().
-		Preload("Cita").
-		Preload("Cita.Paciente").
-		Preload("Cita.Paciente.Persona").
-		Preload("Cita.Medico").
-		Preload("Cita.Medico.Usuario").
-		Preload("Cita.Medico.Usuario.Persona").
+	result := precargarObservacion(initializers.GetDB()).
 		Where("cita_id = ?", citaID).
 		First(&observacion)
 
@@ -145,32 +489,66 @@ func GetObservacionPorCita(c *gin.Context) {
 	respuestas.RespondSuccess(c, http.StatusOK, observacion)
 }
 
-// Actualizar una observación
+type ActualizarObservacionInput struct {
+	Observaciones     string              `json:"observaciones"`
+	Diagnostico       string              `json:"diagnostico"`
+	MotivoConsulta    string              `json:"motivo_consulta"`
+	ExploracionFisica string              `json:"exploracion_fisica"`
+	Evaluacion        string              `json:"evaluacion"`
+	Plan              string              `json:"plan"`
+	SignosVitales     *SignosVitalesInput `json:"signos_vitales"`
+	// Si se envía, reemplaza los diagnósticos codificados (lista vacía los quita)
+	Diagnosticos *[]DiagnosticoInput `json:"diagnosticos" binding:"omitempty,dive"`
+}
+
+// Actualizar una observación (administrador), dentro de la ventana de edición
 func UpdateObservacion(c *gin.Context) {
-	id, err := strconv.Atoi(c.Param("id"))
-	if err != nil {
-		respuestas.RespondError(c, http.StatusBadRequest, "ID inválido")
+	var input ActualizarObservacionInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	var input struct {
-		Observaciones string `json:"observaciones"`
-		Diagnostico   string `json:"diagnostico"`
+	actualizarObservacion(c, input, nil)
+}
+
+// El médico corrige su observación mientras siga abierta
+func UpdateObservacionMedico(c *gin.Context) {
+	medico, ok := medicoActual(c)
+	if !ok {
+		respuestas.RespondError(c, http.StatusForbidden, "Solo los médicos pueden editar observaciones")
+		return
 	}
 
+	var input ActualizarObservacionInput
 	if err := c.ShouldBindJSON(&input); err != nil {
 		respuestas.RespondError(c, http.StatusBadRequest, err.Error())
 		return
 	}
 
+	actualizarObservacion(c, input, &medico)
+}
+
+// Aplica los cambios a la observación :id; con medico solo si la cita es suya
+// y no ha vencido el plazo de edición
+func actualizarObservacion(c *gin.Context, input ActualizarObservacionInput, medico *models.Medico) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, "ID inválido")
+		return
+	}
+
 	tx := initializers.GetDB().Begin()
 	if tx.Error != nil {
 		respuestas.RespondError(c, http.StatusInternalServerError, "Error al iniciar transacción: "+tx.Error.Error())
 		return
 	}
 
+	// Bloqueada hasta el commit para que dos ediciones no tomen el mismo número de versión
 	var observacion models.Observacion
-	if err := tx.First(&observacion, id).Error; err != nil {
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Preload("Diagnosticos.Catalogo").
+		First(&observacion, id).Error; err != nil {
 		tx.Rollback()
 		if err == gorm.ErrRecordNotFound {
 			respuestas.RespondError(c, http.StatusNotFound, "Observación no encontrada")
@@ -180,6 +558,45 @@ func UpdateObservacion(c *gin.Context) {
 		return
 	}
 
+	if observacion.AnuladaEn != nil {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusConflict, "La observación está anulada")
+		return
+	}
+	if observacion.FirmadaEn != nil {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusLocked, "La observación está firmada; registre una adenda")
+		return
+	}
+
+	if medico != nil {
+		propia, err := observacionDeMedico(tx, observacion, *medico)
+		if err != nil {
+			tx.Rollback()
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al verificar cita: "+err.Error())
+			return
+		}
+		if !propia {
+			tx.Rollback()
+			respuestas.RespondError(c, http.StatusForbidden, "Solo el médico de la cita puede editar su observación")
+			return
+		}
+	}
+	// Pasada la ventana de edición tampoco el administrador puede cambiarla
+	if !observacionEditable(observacion) {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusLocked, "La observación está cerrada; registre una adenda")
+		return
+	}
+
+	// Las observaciones anteriores al versionado guardan su contenido original
+	// antes del primer cambio
+	if err := registrarVersionObservacion(tx, observacion, nil); err != nil {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al guardar versión: "+err.Error())
+		return
+	}
+
 	// Actualizar solo los campos proporcionados
 	if input.Observaciones != "" {
 		observacion.Observaciones = input.Observaciones
@@ -187,26 +604,59 @@ func UpdateObservacion(c *gin.Context) {
 	if input.Diagnostico != "" {
 		observacion.Diagnostico = input.Diagnostico
 	}
+	if input.MotivoConsulta != "" {
+		observacion.MotivoConsulta = input.MotivoConsulta
+	}
+	if input.ExploracionFisica != "" {
+		observacion.ExploracionFisica = input.ExploracionFisica
+	}
+	if input.Evaluacion != "" {
+		observacion.Evaluacion = input.Evaluacion
+	}
+	if input.Plan != "" {
+		observacion.Plan = input.Plan
+	}
+	signos, err := aplicarSignosVitales(observacion.SignosVitales, input.SignosVitales)
+	if err != nil {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusBadRequest, "Signos vitales inválidos: "+err.Error())
+		return
+	}
+	observacion.SignosVitales = signos
+	if input.Diagnosticos != nil {
+		diagnosticos, err := resolverDiagnosticos(tx, *input.Diagnosticos)
+		if err != nil {
+			tx.Rollback()
+			respuestas.RespondError(c, http.StatusBadRequest, "Diagnósticos inválidos: "+err.Error())
+			return
+		}
+		if err := guardarDiagnosticos(tx, &observacion, diagnosticos); err != nil {
+			tx.Rollback()
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al guardar diagnósticos: "+err.Error())
+			return
+		}
+	}
+	observacion.Version++
 
-	if err := tx.Save(&observacion).Error; err != nil {
+	if err := tx.Omit("Diagnosticos").Save(&observacion).Error; err != nil {
 		tx.Rollback()
 		respuestas.RespondError(c, http.StatusInternalServerError, "Error al actualizar observación: "+err.Error())
 		return
 	}
 
+	if err := registrarVersionObservacion(tx, observacion, actorActual(c)); err != nil {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al guardar versión: "+err.Error())
+		return
+	}
+
 	if err := tx.Commit().Error; err != nil {
 		respuestas.RespondError(c, http.StatusInternalServerError, "Error al confirmar transacción: "+err.Error())
 		return
 	}
 
 	// Cargar datos actualizados para la respuesta
-	if err := initializers.GetDB().
-		Preload("Cita").
-		Preload("Cita.Paciente").
-		Preload("Cita.Paciente.Persona").
-		Preload("Cita.Medico").
-		Preload("Cita.Medico.Usuario").
-		Preload("Cita.Medico.Usuario.Persona").
+	if err := precargarObservacion(initializers.GetDB()).
 		First(&observacion, observacion.ID).Error; err != nil {
 		respuestas.RespondError(c, http.StatusInternalServerError, "Error al cargar datos actualizados: "+err.Error())
 		return
@@ -215,30 +665,280 @@ func UpdateObservacion(c *gin.Context) {
 	respuestas.RespondSuccess(c, http.StatusOK, observacion)
 }
 
-// Eliminar una observación
-func DeleteObservacion(c *gin.Context) {
+// Anular una observación. No se borra: conserva sus versiones y adendas y
+// deja de contar en reportes
+func AnularObservacion(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, "ID inválido")
+		return
+	}
+
+	var input struct {
+		Motivo string `json:"motivo" binding:"required,max=500"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var observacion models.Observacion
+	if err := initializers.GetDB().First(&observacion, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			respuestas.RespondError(c, http.StatusNotFound, "Observación no encontrada")
+		} else {
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al buscar observación: "+err.Error())
+		}
+		return
+	}
+
+	ahora := time.Now()
+	result := initializers.GetDB().Model(&observacion).
+		Where("anulada_en IS NULL").
+		Updates(map[string]interface{}{
+			"anulada_en":       ahora,
+			"anulada_por":      actorActual(c),
+			"motivo_anulacion": input.Motivo,
+		})
+	if result.Error != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al anular observación: "+result.Error.Error())
+		return
+	}
+	if result.RowsAffected == 0 {
+		respuestas.RespondError(c, http.StatusConflict, "La observación ya estaba anulada")
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusOK, gin.H{"message": "Observación anulada"})
+}
+
+// Lo que cubre la firma de una adenda
+func contenidoAdenda(adenda models.AdendaObservacion) []byte {
+	contenido, _ := json.Marshal(struct {
+		ObservacionID uint
+		MedicoID      uint
+		Texto         string
+		CreadaEn      string
+	}{adenda.ObservacionID, adenda.MedicoID, adenda.Texto, adenda.CreadaEn.UTC().Format(time.RFC3339)})
+	return contenido
+}
+
+// El médico de la cita agrega una adenda firmada; vale también con la
+// observación cerrada. Se confirma con la contraseña, que hace las veces de firma.
+func PostAdendaObservacion(c *gin.Context) {
+	medico, ok := medicoActual(c)
+	if !ok {
+		respuestas.RespondError(c, http.StatusForbidden, "Solo los médicos pueden agregar adendas")
+		return
+	}
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, "ID inválido")
+		return
+	}
+
+	var input struct {
+		Texto      string `json:"texto" binding:"required,max=10000"`
+		Contrasena string `json:"contrasena" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	texto := strings.TrimSpace(input.Texto)
+	if texto == "" {
+		respuestas.RespondError(c, http.StatusBadRequest, "La adenda está vacía")
+		return
+	}
+
+	usuario, ok := confirmarContrasenaMedico(c, medico, input.Contrasena)
+	if !ok {
+		return
+	}
+
+	var observacion models.Observacion
+	if err := initializers.GetDB().First(&observacion, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			respuestas.RespondError(c, http.StatusNotFound, "Observación no encontrada")
+		} else {
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al buscar observación: "+err.Error())
+		}
+		return
+	}
+	propia, err := observacionDeMedico(initializers.GetDB(), observacion, medico)
+	if err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al verificar cita: "+err.Error())
+		return
+	}
+	if !propia {
+		respuestas.RespondError(c, http.StatusForbidden, "Solo el médico de la cita puede agregar adendas")
+		return
+	}
+	if observacion.AnuladaEn != nil {
+		respuestas.RespondError(c, http.StatusConflict, "La observación está anulada")
+		return
+	}
+
+	// Al segundo: la base no guarda nanosegundos y la fecha forma parte de lo firmado
+	adenda := models.AdendaObservacion{
+		ObservacionID: observacion.ID,
+		MedicoID:      medico.ID,
+		Texto:         texto,
+		CreadaEn:      time.Now().UTC().Truncate(time.Second),
+	}
+	adenda.Firma, err = clave.FirmarDocumento("adenda", usuario.ID, contenidoAdenda(adenda))
+	if err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al firmar adenda: "+err.Error())
+		return
+	}
+
+	if err := initializers.GetDB().Omit("Medico").Create(&adenda).Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al guardar adenda: "+err.Error())
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusCreated, adenda)
+}
+
+// Adendas de una observación con la verificación de su firma
+func GetAdendasObservacion(c *gin.Context) {
+	observacion, ok := observacionVisible(c)
+	if !ok {
+		return
+	}
+
+	var adendas []models.AdendaObservacion
+	if err := initializers.GetDB().
+		Preload("Medico.Usuario.Persona").
+		Where("observacion_id = ?", observacion.ID).
+		Order("creada_en").
+		Find(&adendas).Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al obtener adendas: "+err.Error())
+		return
+	}
+
+	resultado := make([]gin.H, 0, len(adendas))
+	for _, a := range adendas {
+		firmante, _, err := clave.VerificarFirmaDocumento(a.Firma, "adenda", contenidoAdenda(a))
+		persona := a.Medico.Usuario.Persona
+		resultado = append(resultado, gin.H{
+			"id":           a.ID,
+			"texto":        a.Texto,
+			"creada_en":    a.CreadaEn,
+			"medico":       gin.H{"id": a.MedicoID, "nombre": persona.Nombre + " " + persona.ApellidoPaterno},
+			"firma_valida": err == nil && firmante == a.Medico.UsuarioID,
+		})
+	}
+
+	respuestas.RespondSuccess(c, http.StatusOK, resultado)
+}
+
+// Lo que cubre la firma de una observación: la versión firmada y la fecha
+func contenidoFirmaObservacion(observacionID uint, version int, contenido models.ContenidoObservacion, firmadaEn time.Time) []byte {
+	firmado, _ := json.Marshal(struct {
+		ObservacionID uint
+		Version       int
+		Contenido     models.ContenidoObservacion
+		FirmadaEn     string
+	}{observacionID, version, contenido, firmadaEn.UTC().Format(time.RFC3339)})
+	return firmado
+}
+
+// El médico firma su observación: queda cerrada para todos y los cambios
+// posteriores solo pueden ser adendas
+func FirmarObservacion(c *gin.Context) {
+	medico, ok := medicoActual(c)
+	if !ok {
+		respuestas.RespondError(c, http.StatusForbidden, "Solo los médicos pueden firmar observaciones")
+		return
+	}
+
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
 		respuestas.RespondError(c, http.StatusBadRequest, "ID inválido")
 		return
 	}
 
+	var input struct {
+		Contrasena string `json:"contrasena" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	usuario, ok := confirmarContrasenaMedico(c, medico, input.Contrasena)
+	if !ok {
+		return
+	}
+
 	tx := initializers.GetDB().Begin()
 	if tx.Error != nil {
 		respuestas.RespondError(c, http.StatusInternalServerError, "Error al iniciar transacción: "+tx.Error.Error())
 		return
 	}
 
-	result := tx.Delete(&models.Observacion{}, id)
-	if result.Error != nil {
+	var observacion models.Observacion
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Preload("Diagnosticos.Catalogo").
+		First(&observacion, id).Error; err != nil {
 		tx.Rollback()
-		respuestas.RespondError(c, http.StatusInternalServerError, "Error al eliminar observación: "+result.Error.Error())
+		if err == gorm.ErrRecordNotFound {
+			respuestas.RespondError(c, http.StatusNotFound, "Observación no encontrada")
+		} else {
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al buscar observación: "+err.Error())
+		}
 		return
 	}
 
-	if result.RowsAffected == 0 {
+	propia, err := observacionDeMedico(tx, observacion, medico)
+	if err != nil {
 		tx.Rollback()
-		respuestas.RespondError(c, http.StatusNotFound, "Observación no encontrada")
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al verificar cita: "+err.Error())
+		return
+	}
+	if !propia {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusForbidden, "Solo el médico de la cita puede firmar su observación")
+		return
+	}
+	if observacion.AnuladaEn != nil {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusConflict, "La observación está anulada")
+		return
+	}
+	if observacion.FirmadaEn != nil {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusConflict, "La observación ya está firmada")
+		return
+	}
+
+	// Las observaciones anteriores al versionado guardan su contenido antes de firmarse
+	if err := registrarVersionObservacion(tx, observacion, nil); err != nil {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al guardar versión: "+err.Error())
+		return
+	}
+
+	// Al segundo, como las adendas: la fecha forma parte de lo firmado
+	firmadaEn := time.Now().UTC().Truncate(time.Second)
+	firma, err := clave.FirmarDocumento("observacion", usuario.ID,
+		contenidoFirmaObservacion(observacion.ID, observacion.Version, contenidoObservacion(observacion), firmadaEn))
+	if err != nil {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al firmar observación: "+err.Error())
+		return
+	}
+
+	if err := tx.Model(&observacion).Updates(map[string]interface{}{
+		"firmada_en":     firmadaEn,
+		"firmada_por":    usuario.ID,
+		"firma":          firma,
+		"editable_hasta": firmadaEn,
+	}).Error; err != nil {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al firmar observación: "+err.Error())
 		return
 	}
 
@@ -247,5 +947,11 @@ func DeleteObservacion(c *gin.Context) {
 		return
 	}
 
-	respuestas.RespondSuccess(c, http.StatusOK, gin.H{"message": "Observación eliminada correctamente"})
+	if err := precargarObservacion(initializers.GetDB()).
+		First(&observacion, observacion.ID).Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al cargar datos de la observación: "+err.Error())
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusOK, observacion)
 }
@@ -0,0 +1,104 @@
+package controllers
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/Ilimm9/CMedicas/models"
+)
+
+func entero(v int) *int          { return &v }
+func decimal(v float64) *float64 { return &v }
+
+func valorDecimal(v *float64) string {
+	if v == nil {
+		return "nil"
+	}
+	return strconv.FormatFloat(*v, 'g', -1, 64)
+}
+
+func TestAplicarSignosVitales(t *testing.T) {
+	casos := []struct {
+		nombre      string
+		anteriores  models.SignosVitales
+		input       *SignosVitalesInput
+		temperatura string
+		peso        string
+		talla       string
+		imc         string
+		error       bool
+	}{
+		{
+			nombre:      "sin captura conserva los anteriores",
+			anteriores:  models.SignosVitales{Temperatura: decimal(36.5)},
+			input:       nil,
+			temperatura: "36.5", peso: "nil", talla: "nil", imc: "nil",
+		},
+		{
+			nombre:      "unidades del sistema métrico",
+			input:       &SignosVitalesInput{Temperatura: decimal(37.25), Peso: decimal(70), Talla: decimal(175)},
+			temperatura: "37.3", peso: "70", talla: "175", imc: "22.9",
+		},
+		{
+			nombre:      "Fahrenheit, libras y pulgadas",
+			input:       &SignosVitalesInput{Temperatura: decimal(98.6), UnidadTemperatura: "F", Peso: decimal(154), UnidadPeso: "lb", Talla: decimal(69), UnidadTalla: "in"},
+			temperatura: "37", peso: "69.85", talla: "175.3", imc: "22.7",
+		},
+		{
+			nombre:      "talla en metros",
+			input:       &SignosVitalesInput{Talla: decimal(1.6), UnidadTalla: "m"},
+			temperatura: "nil", peso: "nil", talla: "160", imc: "nil",
+		},
+		{
+			nombre:      "el IMC usa el peso anterior",
+			anteriores:  models.SignosVitales{Peso: decimal(80)},
+			input:       &SignosVitalesInput{Talla: decimal(200)},
+			temperatura: "nil", peso: "80", talla: "200", imc: "20",
+		},
+		{
+			nombre: "temperatura fuera de rango",
+			input:  &SignosVitalesInput{Temperatura: decimal(50)},
+			error:  true,
+		},
+		{
+			nombre: "talla en metros capturada como centímetros",
+			input:  &SignosVitalesInput{Talla: decimal(170), UnidadTalla: "m"},
+			error:  true,
+		},
+		{
+			nombre: "presión incompleta",
+			input:  &SignosVitalesInput{PresionSistolica: entero(120)},
+			error:  true,
+		},
+		{
+			nombre: "sistólica menor que diastólica",
+			input:  &SignosVitalesInput{PresionSistolica: entero(70), PresionDiastolica: entero(110)},
+			error:  true,
+		},
+		{
+			nombre: "frecuencia cardiaca fuera de rango",
+			input:  &SignosVitalesInput{FrecuenciaCardiaca: entero(400)},
+			error:  true,
+		},
+	}
+
+	for _, c := range casos {
+		t.Run(c.nombre, func(t *testing.T) {
+			signos, err := aplicarSignosVitales(c.anteriores, c.input)
+			if c.error {
+				if err == nil {
+					t.Fatal("se esperaba un error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error inesperado: %v", err)
+			}
+			obtenidos := [4]string{valorDecimal(signos.Temperatura), valorDecimal(signos.Peso), valorDecimal(signos.Talla), valorDecimal(signos.IMC)}
+			esperados := [4]string{c.temperatura, c.peso, c.talla, c.imc}
+			if obtenidos != esperados {
+				t.Errorf("temperatura, peso, talla, IMC = %v, se esperaba %v", obtenidos, esperados)
+			}
+		})
+	}
+}
@@ -0,0 +1,184 @@
+package controllers
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/Ilimm9/CMedicas/Respuestas"
+	"github.com/Ilimm9/CMedicas/initializers"
+	"github.com/Ilimm9/CMedicas/mensajeria"
+	"github.com/Ilimm9/CMedicas/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type PlantillaInput struct {
+	Tipo    string `json:"tipo" binding:"required,oneof=confirmación recordatorio cancelación reprogramación completada reasignación"`
+	Destino string `json:"destino" binding:"required,oneof=paciente medico medico_anterior"`
+	Idioma  string `json:"idioma" binding:"required,oneof=es en"`
+	Asunto  string `json:"asunto" binding:"required,max=200"`
+	Texto   string `json:"texto" binding:"required"`
+	HTML    string `json:"html"`
+}
+
+type VistaPreviaPlantillaInput struct {
+	Tipo    string `json:"tipo" binding:"required,oneof=confirmación recordatorio cancelación reprogramación completada reasignación"`
+	Destino string `json:"destino" binding:"required,oneof=paciente medico medico_anterior"`
+	Idioma  string `json:"idioma" binding:"required,oneof=es en"`
+	// Texto a probar sin guardarlo; si se omite se usa la plantilla vigente
+	Asunto string `json:"asunto"`
+	Texto  string `json:"texto"`
+	HTML   string `json:"html"`
+	// Cita real para llenar los datos; si se omite se usan datos de ejemplo
+	CitaID uint `json:"cita_id"`
+}
+
+type plantillaResponse struct {
+	models.PlantillaNotificacion
+	Personalizada bool `json:"personalizada"`
+}
+
+// Listar las plantillas vigentes de un idioma (?idioma=es), indicando cuáles
+// fueron personalizadas
+func GetPlantillas(c *gin.Context) {
+	idioma := c.DefaultQuery("idioma", mensajeria.Idiomas[0])
+	if !mensajeria.IdiomaValido(idioma) {
+		respuestas.RespondError(c, http.StatusBadRequest, "Idioma no soportado")
+		return
+	}
+
+	var plantillas []plantillaResponse
+	for _, predeterminada := range mensajeria.PlantillasPredeterminadas(idioma) {
+		vigente, personalizada, err := mensajeria.PlantillaVigente(initializers.GetDB(), predeterminada.Tipo, predeterminada.Destino, idioma)
+		if err != nil {
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al obtener plantillas: "+err.Error())
+			return
+		}
+		plantillas = append(plantillas, plantillaResponse{*vigente, personalizada})
+	}
+
+	sort.Slice(plantillas, func(i, j int) bool {
+		if plantillas[i].Tipo != plantillas[j].Tipo {
+			return plantillas[i].Tipo < plantillas[j].Tipo
+		}
+		return plantillas[i].Destino < plantillas[j].Destino
+	})
+
+	respuestas.RespondSuccess(c, http.StatusOK, gin.H{
+		"plantillas": plantillas,
+		"campos":     []string{"Paciente", "Medico", "Especialidad", "Fecha", "Hora", "Direccion", "FechaAnterior", "HoraAnterior", "MedicoAnterior", "EnlaceConfirmar", "EnlaceCancelar"},
+	})
+}
+
+// Crear o reemplazar la plantilla de un tipo, destino e idioma
+func PutPlantilla(c *gin.Context) {
+	var input PlantillaInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	plantilla := models.PlantillaNotificacion{
+		Tipo:    input.Tipo,
+		Destino: input.Destino,
+		Idioma:  input.Idioma,
+		Asunto:  input.Asunto,
+		Texto:   input.Texto,
+		HTML:    input.HTML,
+	}
+	if adminID, ok := usuarioActualID(c); ok {
+		plantilla.ActualizadaPor = &adminID
+	}
+
+	// Una plantilla que no renderiza no se guarda: fallaría al enviar
+	if _, err := mensajeria.RenderizarPlantilla(plantilla, mensajeria.DatosEjemplo(input.Idioma)); err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, "Plantilla inválida: "+err.Error())
+		return
+	}
+
+	if err := initializers.GetDB().
+		Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "tipo"}, {Name: "destino"}, {Name: "idioma"}},
+			DoUpdates: clause.AssignmentColumns([]string{"asunto", "texto", "html", "actualizada_en", "actualizada_por"}),
+		}).
+		Create(&plantilla).Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al guardar plantilla: "+err.Error())
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusOK, plantilla)
+}
+
+// Eliminar una plantilla personalizada; vuelve a usarse la predeterminada
+func DeletePlantilla(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, "ID inválido")
+		return
+	}
+
+	result := initializers.GetDB().Delete(&models.PlantillaNotificacion{}, id)
+	if result.Error != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al eliminar plantilla: "+result.Error.Error())
+		return
+	}
+	if result.RowsAffected == 0 {
+		respuestas.RespondError(c, http.StatusNotFound, "Plantilla no encontrada")
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusOK, gin.H{"message": "Plantilla restablecida a la predeterminada"})
+}
+
+// Renderizar una plantilla (guardada o en edición) con datos de ejemplo o de una cita
+func VistaPreviaPlantilla(c *gin.Context) {
+	var input VistaPreviaPlantillaInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	plantilla, _, err := mensajeria.PlantillaVigente(initializers.GetDB(), input.Tipo, input.Destino, input.Idioma)
+	if err != nil {
+		respuestas.RespondError(c, http.StatusNotFound, err.Error())
+		return
+	}
+	if input.Texto != "" {
+		plantilla = &models.PlantillaNotificacion{
+			Tipo:    input.Tipo,
+			Destino: input.Destino,
+			Idioma:  input.Idioma,
+			Asunto:  input.Asunto,
+			Texto:   input.Texto,
+			HTML:    input.HTML,
+		}
+	}
+
+	datos := mensajeria.DatosEjemplo(input.Idioma)
+	if input.CitaID != 0 {
+		cita, err := mensajeria.CargarCitaParaPlantilla(initializers.GetDB(), input.CitaID)
+		if err != nil {
+			if err == gorm.ErrRecordNotFound {
+				respuestas.RespondError(c, http.StatusNotFound, "Cita no encontrada")
+			} else {
+				respuestas.RespondError(c, http.StatusInternalServerError, "Error al buscar cita: "+err.Error())
+			}
+			return
+		}
+		if datos, err = mensajeria.DatosCita(initializers.GetDB(), cita, nil, input.Idioma); err != nil {
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al preparar datos: "+err.Error())
+			return
+		}
+	}
+
+	contenido, err := mensajeria.RenderizarPlantilla(*plantilla, datos)
+	if err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, "Plantilla inválida: "+err.Error())
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusOK, contenido)
+}
@@ -0,0 +1,328 @@
+package controllers
+
+import (
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Ilimm9/CMedicas/Respuestas"
+	"github.com/Ilimm9/CMedicas/clave"
+	"github.com/Ilimm9/CMedicas/initializers"
+	"github.com/Ilimm9/CMedicas/mensajeria"
+	"github.com/Ilimm9/CMedicas/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm/clause"
+)
+
+// Los campos omitidos no se modifican; una cadena vacía en las horas de silencio
+// las desactiva
+type PreferenciaNotificacionInput struct {
+	Canales           []string  `json:"canales" binding:"omitempty,min=1,dive,oneof=correo sms whatsapp push"`
+	Idioma            string    `json:"idioma" binding:"omitempty,oneof=es en"`
+	TiposDesactivados *[]string `json:"tipos_desactivados" binding:"omitempty,dive,oneof=confirmación recordatorio cancelación reprogramación completada reasignación"`
+	SilencioInicio    *string   `json:"silencio_inicio"`
+	SilencioFin       *string   `json:"silencio_fin"`
+	ZonaHoraria       *string   `json:"zona_horaria"`
+	// Incluir el motivo de la cita en la invitación de calendario
+	IncluirMotivoCalendario *bool `json:"incluir_motivo_calendario"`
+}
+
+type BajaNotificacionesInput struct {
+	Token string `json:"token" binding:"required"`
+	// Tipo del que se da de baja; "todas" desactiva todos los tipos
+	Tipo string `json:"tipo" binding:"required"`
+}
+
+type SuscripcionPushInput struct {
+	Endpoint string `json:"endpoint" binding:"required,url"`
+	Keys     struct {
+		P256dh string `json:"p256dh" binding:"required"`
+		Auth   string `json:"auth" binding:"required"`
+	} `json:"keys" binding:"required"`
+}
+
+func preferenciasResponse(pref models.PreferenciaNotificacion, idioma string) gin.H {
+	return gin.H{
+		"canales":                   mensajeria.ListaPreferencia(pref.Canales),
+		"disponibles":               mensajeria.CanalesValidos,
+		"idioma":                    idioma,
+		"idiomas":                   mensajeria.Idiomas,
+		"tipos":                     mensajeria.TiposNotificacion,
+		"tipos_desactivados":        mensajeria.ListaPreferencia(pref.TiposDesactivados),
+		"silencio_inicio":           pref.SilencioInicio,
+		"silencio_fin":              pref.SilencioFin,
+		"zona_horaria":              pref.ZonaHoraria,
+		"incluir_motivo_calendario": pref.IncluirMotivoCalendario,
+	}
+}
+
+// Obtener las preferencias de notificación del usuario autenticado
+func GetPreferenciasNotificacion(c *gin.Context) {
+	usuarioID, ok := usuarioActualID(c)
+	if !ok {
+		respuestas.RespondError(c, http.StatusUnauthorized, "Usuario no autenticado")
+		return
+	}
+
+	pref, err := mensajeria.PreferenciasUsuario(usuarioID)
+	if err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al obtener preferencias: "+err.Error())
+		return
+	}
+
+	var usuario models.Usuario
+	if err := initializers.GetDB().Select("id", "idioma").First(&usuario, usuarioID).Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al obtener preferencias: "+err.Error())
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusOK, preferenciasResponse(pref, usuario.Idioma))
+}
+
+// Guardar las preferencias de notificación: orden de canales (el primero es el
+// preferido y los demás se usan si el anterior falla), idioma, tipos
+// desactivados, horas de silencio y privacidad de la invitación de calendario
+func UpdatePreferenciasNotificacion(c *gin.Context) {
+	usuarioID, ok := usuarioActualID(c)
+	if !ok {
+		respuestas.RespondError(c, http.StatusUnauthorized, "Usuario no autenticado")
+		return
+	}
+
+	var input PreferenciaNotificacionInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	for _, hora := range []*string{input.SilencioInicio, input.SilencioFin} {
+		if hora != nil && *hora != "" && !mensajeria.HoraValida(*hora) {
+			respuestas.RespondError(c, http.StatusBadRequest, "Las horas de silencio deben tener formato HH:MM")
+			return
+		}
+	}
+	if input.ZonaHoraria != nil && *input.ZonaHoraria != "" {
+		if _, err := time.LoadLocation(*input.ZonaHoraria); err != nil {
+			respuestas.RespondError(c, http.StatusBadRequest, "Zona horaria inválida")
+			return
+		}
+	}
+
+	tx := initializers.GetDB().Begin()
+	if tx.Error != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al iniciar transacción: "+tx.Error.Error())
+		return
+	}
+
+	var pref models.PreferenciaNotificacion
+	if err := tx.Where(models.PreferenciaNotificacion{UsuarioID: usuarioID}).
+		Attrs(models.PreferenciaNotificacion{Canales: "correo"}).
+		FirstOrCreate(&pref).Error; err != nil {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al guardar preferencias: "+err.Error())
+		return
+	}
+
+	if len(input.Canales) > 0 {
+		pref.Canales = strings.Join(sinRepetidos(input.Canales), ",")
+	}
+	if input.TiposDesactivados != nil {
+		pref.TiposDesactivados = strings.Join(sinRepetidos(*input.TiposDesactivados), ",")
+	}
+	if input.SilencioInicio != nil {
+		pref.SilencioInicio = *input.SilencioInicio
+	}
+	if input.SilencioFin != nil {
+		pref.SilencioFin = *input.SilencioFin
+	}
+	if input.ZonaHoraria != nil {
+		pref.ZonaHoraria = *input.ZonaHoraria
+	}
+	if input.IncluirMotivoCalendario != nil {
+		pref.IncluirMotivoCalendario = *input.IncluirMotivoCalendario
+	}
+	if (pref.SilencioInicio == "") != (pref.SilencioFin == "") {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusBadRequest, "Indique el inicio y el fin de las horas de silencio")
+		return
+	}
+
+	if err := tx.Save(&pref).Error; err != nil {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al guardar preferencias: "+err.Error())
+		return
+	}
+
+	if input.Idioma != "" {
+		if err := tx.Model(&models.Usuario{}).Where("id = ?", usuarioID).Update("idioma", input.Idioma).Error; err != nil {
+			tx.Rollback()
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al guardar idioma: "+err.Error())
+			return
+		}
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al confirmar transacción: "+err.Error())
+		return
+	}
+
+	GetPreferenciasNotificacion(c)
+}
+
+// Quita repetidos respetando el orden
+func sinRepetidos(valores []string) []string {
+	vistos := map[string]bool{}
+	var resultado []string
+	for _, v := range valores {
+		if !vistos[v] {
+			vistos[v] = true
+			resultado = append(resultado, v)
+		}
+	}
+	return resultado
+}
+
+// Baja desde el enlace de un correo (sin sesión): desactiva el tipo indicado
+// o todos los tipos
+func BajaNotificaciones(c *gin.Context) {
+	var input BajaNotificacionesInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	tipos := []string{input.Tipo}
+	if input.Tipo == "todas" {
+		tipos = mensajeria.TiposNotificacion
+	} else if !contiene(mensajeria.TiposNotificacion, input.Tipo) {
+		respuestas.RespondError(c, http.StatusBadRequest, "Tipo de notificación inválido")
+		return
+	}
+
+	// El token es el de la notificación que trajo el enlace; los correos más
+	// antiguos traen el que se guardaba en las preferencias
+	hash := clave.HashToken(input.Token)
+	var usuarios []uint
+	if err := initializers.GetDB().Model(&models.Notificacion{}).
+		Where("token_baja_hash = ?", hash).Limit(1).Pluck("id_usuario", &usuarios).Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al buscar notificación: "+err.Error())
+		return
+	}
+	if len(usuarios) == 0 {
+		if err := initializers.GetDB().Model(&models.PreferenciaNotificacion{}).
+			Where("token_baja_hash = ?", hash).Limit(1).Pluck("usuario_id", &usuarios).Error; err != nil {
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al buscar preferencias: "+err.Error())
+			return
+		}
+	}
+	if len(usuarios) == 0 {
+		respuestas.RespondError(c, http.StatusNotFound, "Enlace de baja inválido")
+		return
+	}
+
+	pref, err := mensajeria.PreferenciasUsuario(usuarios[0])
+	if err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al buscar preferencias: "+err.Error())
+		return
+	}
+
+	desactivados := sinRepetidos(append(mensajeria.ListaPreferencia(pref.TiposDesactivados), tipos...))
+	pref.TiposDesactivados = strings.Join(desactivados, ",")
+	if pref.ID == 0 {
+		err = initializers.GetDB().Create(&pref).Error
+	} else {
+		err = initializers.GetDB().Model(&pref).Update("tipos_desactivados", pref.TiposDesactivados).Error
+	}
+	if err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al guardar preferencias: "+err.Error())
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusOK, gin.H{
+		"message":            "Ya no recibirá estas notificaciones",
+		"tipos_desactivados": desactivados,
+	})
+}
+
+func contiene(lista []string, valor string) bool {
+	for _, v := range lista {
+		if v == valor {
+			return true
+		}
+	}
+	return false
+}
+
+// Clave pública VAPID para que el navegador cree la suscripción push
+func GetClavePublicaPush(c *gin.Context) {
+	clave := os.Getenv("VAPID_PUBLICA")
+	if clave == "" {
+		respuestas.RespondError(c, http.StatusNotFound, "Las notificaciones push no están configuradas")
+		return
+	}
+	respuestas.RespondSuccess(c, http.StatusOK, gin.H{"clave_publica": clave})
+}
+
+// Registrar la suscripción push de un navegador
+func PostSuscripcionPush(c *gin.Context) {
+	usuarioID, ok := usuarioActualID(c)
+	if !ok {
+		respuestas.RespondError(c, http.StatusUnauthorized, "Usuario no autenticado")
+		return
+	}
+
+	var input SuscripcionPushInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Un mismo endpoint puede pasar a otra cuenta si se inicia sesión con otro usuario
+	suscripcion := models.SuscripcionPush{
+		UsuarioID: usuarioID,
+		Endpoint:  input.Endpoint,
+		P256dh:    input.Keys.P256dh,
+		Auth:      input.Keys.Auth,
+	}
+	if err := initializers.GetDB().
+		Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "endpoint"}}, DoUpdates: clause.AssignmentColumns([]string{"usuario_id", "p256dh", "auth"})}).
+		Create(&suscripcion).Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al guardar suscripción: "+err.Error())
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusCreated, gin.H{"message": "Suscripción registrada"})
+}
+
+// Eliminar la suscripción push de un navegador
+func DeleteSuscripcionPush(c *gin.Context) {
+	usuarioID, ok := usuarioActualID(c)
+	if !ok {
+		respuestas.RespondError(c, http.StatusUnauthorized, "Usuario no autenticado")
+		return
+	}
+
+	var input struct {
+		Endpoint string `json:"endpoint" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	resultado := initializers.GetDB().
+		Where("usuario_id = ? AND endpoint = ?", usuarioID, input.Endpoint).
+		Delete(&models.SuscripcionPush{})
+	if resultado.Error != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al eliminar suscripción: "+resultado.Error.Error())
+		return
+	}
+	if resultado.RowsAffected == 0 {
+		respuestas.RespondError(c, http.StatusNotFound, "Suscripción no encontrada")
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusOK, gin.H{"message": "Suscripción eliminada"})
+}
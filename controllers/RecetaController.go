@@ -0,0 +1,442 @@
+package controllers
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Ilimm9/CMedicas/Respuestas"
+	"github.com/Ilimm9/CMedicas/documentos"
+	"github.com/Ilimm9/CMedicas/initializers"
+	"github.com/Ilimm9/CMedicas/mensajeria"
+	"github.com/Ilimm9/CMedicas/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type RecetaMedicamentoInput struct {
+	// Del catálogo; si se omite, el medicamento se captura a mano con nombre
+	MedicamentoID *uint  `json:"medicamento_id"`
+	Nombre        string `json:"nombre" binding:"max=200"`
+	Presentacion  string `json:"presentacion" binding:"max=200"`
+	Dosis         string `json:"dosis" binding:"required,max=100"`
+	Via           string `json:"via" binding:"required,oneof=oral sublingual tópica oftálmica ótica nasal inhalada rectal vaginal transdérmica intramuscular intravenosa subcutánea"`
+	Frecuencia    string `json:"frecuencia" binding:"required,max=100"`
+	DuracionDias  int    `json:"duracion_dias" binding:"required,min=1,max=365"`
+	Cantidad      string `json:"cantidad" binding:"max=100"`
+	Indicaciones  string `json:"indicaciones"`
+}
+
+type RecetaInput struct {
+	CitaID       uint                     `json:"cita_id" binding:"required"`
+	Indicaciones string                   `json:"indicaciones"`
+	Medicamentos []RecetaMedicamentoInput `json:"medicamentos" binding:"required,min=1,dive"`
+	// Emitir aunque choque con alergias o con la medicación actual del paciente
+	ConfirmarAdvertencias bool `json:"confirmar_advertencias"`
+}
+
+// Sin caracteres que se confundan al dictarlos o leerlos impresos (0/O, 1/I)
+const alfabetoVerificacion = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// Código de verificación de una receta: "XXXX-XXXX-XXXX"
+func codigoVerificacionReceta() (string, error) {
+	var b strings.Builder
+	for i := 0; i < 12; i++ {
+		if i > 0 && i%4 == 0 {
+			b.WriteByte('-')
+		}
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(alfabetoVerificacion))))
+		if err != nil {
+			return "", err
+		}
+		b.WriteByte(alfabetoVerificacion[n.Int64()])
+	}
+	return b.String(), nil
+}
+
+// Médico asociado al usuario autenticado
+func medicoActual(c *gin.Context) (models.Medico, bool) {
+	var medico models.Medico
+	usuarioID, ok := usuarioActualID(c)
+	if !ok || c.GetString("userRol") != "medico" {
+		return medico, false
+	}
+	if err := initializers.GetDB().Where("usuario_id = ?", usuarioID).Limit(1).Find(&medico).Error; err != nil || medico.ID == 0 {
+		return medico, false
+	}
+	return medico, true
+}
+
+// El administrador, el paciente responsable o el médico de la cita
+func puedeVerReceta(c *gin.Context, receta models.Receta) bool {
+	switch c.GetString("userRol") {
+	case "administrador":
+		return true
+	case "paciente":
+		usuarioID, ok := usuarioActualID(c)
+		return ok && receta.PacienteID == usuarioID
+	case "medico":
+		medico, ok := medicoActual(c)
+		return ok && receta.MedicoID == medico.ID
+	}
+	return false
+}
+
+// Del usuario de un médico solo se cargan los datos para mostrar su nombre;
+// recetas y referencias las ve el paciente
+func usuarioPublico(db *gorm.DB) *gorm.DB {
+	return db.Select("id", "persona_id", "rol")
+}
+
+func precargarReceta(db *gorm.DB) *gorm.DB {
+	return db.
+		Preload("Medico.Usuario", usuarioPublico).
+		Preload("Medico.Usuario.Persona").
+		Preload("Medicamentos", func(db *gorm.DB) *gorm.DB { return db.Order("id") }).
+		Preload("Cita.Paciente.Persona").
+		Preload("Cita.PersonaPaciente")
+}
+
+// Receta de :id visible para el usuario autenticado
+func recetaAccesible(c *gin.Context) (models.Receta, bool) {
+	var receta models.Receta
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, "ID inválido")
+		return receta, false
+	}
+
+	if err := precargarReceta(initializers.GetDB()).First(&receta, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			respuestas.RespondError(c, http.StatusNotFound, "Receta no encontrada")
+		} else {
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al buscar receta: "+err.Error())
+		}
+		return receta, false
+	}
+
+	// Sin acceso se responde igual que si no existiera
+	if !puedeVerReceta(c, receta) {
+		respuestas.RespondError(c, http.StatusNotFound, "Receta no encontrada")
+		return receta, false
+	}
+	return receta, true
+}
+
+// Persona atendida: el dependiente si la cita es suya, si no el titular
+func pacienteReceta(receta models.Receta) models.Persona {
+	if receta.Cita.PersonaPaciente != nil {
+		return *receta.Cita.PersonaPaciente
+	}
+	return receta.Cita.Paciente.Persona
+}
+
+// Emitir una receta para una cita. Solo el médico de la cita (o un administrador).
+func PostReceta(c *gin.Context) {
+	var input RecetaInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	rol := c.GetString("userRol")
+	if rol != "medico" && rol != "administrador" {
+		respuestas.RespondError(c, http.StatusForbidden, "Solo los médicos pueden emitir recetas")
+		return
+	}
+
+	var cita models.Cita
+	if err := initializers.GetDB().First(&cita, input.CitaID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			respuestas.RespondError(c, http.StatusBadRequest, "Cita no encontrada")
+		} else {
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al verificar cita: "+err.Error())
+		}
+		return
+	}
+	if rol == "medico" {
+		medico, ok := medicoActual(c)
+		if !ok || medico.ID != cita.MedicoID {
+			respuestas.RespondError(c, http.StatusForbidden, "Solo el médico de la cita puede emitir la receta")
+			return
+		}
+	}
+	if cita.Estado == "cancelada" {
+		respuestas.RespondError(c, http.StatusBadRequest, "No se puede emitir una receta para una cita cancelada")
+		return
+	}
+
+	tx := initializers.GetDB().Begin()
+	if tx.Error != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al iniciar transacción: "+tx.Error.Error())
+		return
+	}
+
+	receta := models.Receta{
+		CitaID:       cita.ID,
+		MedicoID:     cita.MedicoID,
+		PacienteID:   cita.PacienteID,
+		Indicaciones: input.Indicaciones,
+		Estado:       "vigente",
+	}
+
+	var observacion models.Observacion
+	if err := tx.Select("id").Where("cita_id = ?", cita.ID).Limit(1).Find(&observacion).Error; err != nil {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al buscar observación: "+err.Error())
+		return
+	}
+	if observacion.ID != 0 {
+		receta.ObservacionID = &observacion.ID
+	}
+
+	for i, m := range input.Medicamentos {
+		item := models.RecetaMedicamento{
+			Nombre:       strings.TrimSpace(m.Nombre),
+			Presentacion: strings.TrimSpace(m.Presentacion),
+			Dosis:        m.Dosis,
+			Via:          m.Via,
+			Frecuencia:   m.Frecuencia,
+			DuracionDias: m.DuracionDias,
+			Cantidad:     m.Cantidad,
+			Indicaciones: m.Indicaciones,
+		}
+		if m.MedicamentoID != nil {
+			var medicamento models.Medicamento
+			if err := tx.Where("id = ? AND activo", *m.MedicamentoID).Limit(1).Find(&medicamento).Error; err != nil {
+				tx.Rollback()
+				respuestas.RespondError(c, http.StatusInternalServerError, "Error al buscar medicamento: "+err.Error())
+				return
+			}
+			if medicamento.ID == 0 {
+				tx.Rollback()
+				respuestas.RespondError(c, http.StatusBadRequest, fmt.Sprintf("Medicamento %d: no está en el catálogo", i+1))
+				return
+			}
+			item.MedicamentoID = &medicamento.ID
+			item.Nombre = medicamento.Nombre
+			if item.Presentacion == "" {
+				item.Presentacion = medicamento.Presentacion
+			}
+		}
+		if item.Nombre == "" {
+			tx.Rollback()
+			respuestas.RespondError(c, http.StatusBadRequest, fmt.Sprintf("Medicamento %d: indique el medicamento del catálogo o su nombre", i+1))
+			return
+		}
+		receta.Medicamentos = append(receta.Medicamentos, item)
+	}
+
+	// Alergias y duplicados se muestran al médico; debe confirmar para emitir
+	personaID, err := personaDeCita(tx, cita)
+	if err != nil {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al buscar paciente: "+err.Error())
+		return
+	}
+	advertencias, err := advertenciasReceta(tx, personaID, receta.Medicamentos)
+	if err != nil {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al revisar antecedentes: "+err.Error())
+		return
+	}
+	if len(advertencias) > 0 && !input.ConfirmarAdvertencias {
+		tx.Rollback()
+		c.JSON(http.StatusConflict, gin.H{
+			"success":      false,
+			"error":        "La receta tiene advertencias; reenvíela con confirmar_advertencias para emitirla",
+			"advertencias": advertencias,
+		})
+		return
+	}
+
+	codigo, err := codigoVerificacionReceta()
+	if err != nil {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al generar código: "+err.Error())
+		return
+	}
+	receta.CodigoVerificacion = codigo
+
+	if err := tx.Omit("Medico", "Cita").Create(&receta).Error; err != nil {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al guardar receta: "+err.Error())
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al confirmar transacción: "+err.Error())
+		return
+	}
+
+	if err := precargarReceta(initializers.GetDB()).First(&receta, receta.ID).Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al cargar datos de la receta: "+err.Error())
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusCreated, receta)
+}
+
+// Recetas del usuario autenticado: las propias (y de sus dependientes) si es
+// paciente, las emitidas si es médico, todas si es administrador (?cita_id=)
+func GetRecetas(c *gin.Context) {
+	usuarioID, ok := usuarioActualID(c)
+	if !ok {
+		respuestas.RespondError(c, http.StatusUnauthorized, "Usuario no autenticado")
+		return
+	}
+
+	consulta := precargarReceta(initializers.GetDB()).Order("emitida_en DESC")
+	switch c.GetString("userRol") {
+	case "paciente":
+		consulta = consulta.Where("paciente_id = ?", usuarioID)
+	case "medico":
+		medico, ok := medicoActual(c)
+		if !ok {
+			respuestas.RespondError(c, http.StatusNotFound, "No se encontró médico asociado a este usuario")
+			return
+		}
+		consulta = consulta.Where("medico_id = ?", medico.ID)
+	case "administrador":
+	default:
+		respuestas.RespondError(c, http.StatusForbidden, "Rol no autorizado para ver recetas")
+		return
+	}
+	if citaID := c.Query("cita_id"); citaID != "" {
+		consulta = consulta.Where("cita_id = ?", citaID)
+	}
+
+	var recetas []models.Receta
+	if err := consulta.Find(&recetas).Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al obtener recetas: "+err.Error())
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusOK, recetas)
+}
+
+// Obtener una receta
+func GetReceta(c *gin.Context) {
+	receta, ok := recetaAccesible(c)
+	if !ok {
+		return
+	}
+	respuestas.RespondSuccess(c, http.StatusOK, receta)
+}
+
+// Descargar la receta en PDF
+func GetRecetaPDF(c *gin.Context) {
+	receta, ok := recetaAccesible(c)
+	if !ok {
+		return
+	}
+
+	pdf, err := documentos.RecetaPDF(receta, pacienteReceta(receta), mensajeria.ZonaClinica())
+	if err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al generar PDF: "+err.Error())
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf(`inline; filename="receta-%d.pdf"`, receta.ID))
+	c.Data(http.StatusOK, "application/pdf", pdf)
+}
+
+// Anular una receta emitida por error. Solo su médico o un administrador.
+func AnularReceta(c *gin.Context) {
+	receta, ok := recetaAccesible(c)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Motivo string `json:"motivo" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if c.GetString("userRol") == "paciente" {
+		respuestas.RespondError(c, http.StatusForbidden, "Solo el médico puede anular la receta")
+		return
+	}
+	if receta.Estado == "anulada" {
+		respuestas.RespondError(c, http.StatusConflict, "La receta ya está anulada")
+		return
+	}
+
+	ahora := time.Now()
+	if err := initializers.GetDB().Model(&receta).Updates(map[string]interface{}{
+		"estado":           "anulada",
+		"anulada_en":       ahora,
+		"motivo_anulacion": input.Motivo,
+	}).Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al anular receta: "+err.Error())
+		return
+	}
+	receta.Estado = "anulada"
+	receta.AnuladaEn = &ahora
+	receta.MotivoAnulacion = input.Motivo
+
+	respuestas.RespondSuccess(c, http.StatusOK, receta)
+}
+
+// Verificar una receta por su código (sin sesión, para farmacias). Solo se
+// muestra lo necesario para surtirla.
+func VerificarReceta(c *gin.Context) {
+	codigo := strings.ToUpper(strings.TrimSpace(c.Param("codigo")))
+
+	var receta models.Receta
+	if err := precargarReceta(initializers.GetDB()).
+		Where("codigo_verificacion = ?", codigo).
+		Limit(1).Find(&receta).Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al verificar receta: "+err.Error())
+		return
+	}
+	if receta.ID == 0 {
+		respuestas.RespondError(c, http.StatusNotFound, "No existe una receta con ese código")
+		return
+	}
+
+	medico := receta.Medico.Usuario.Persona
+	medicamentos := make([]gin.H, 0, len(receta.Medicamentos))
+	for _, m := range receta.Medicamentos {
+		medicamentos = append(medicamentos, gin.H{
+			"nombre":        m.Nombre,
+			"presentacion":  m.Presentacion,
+			"dosis":         m.Dosis,
+			"via":           m.Via,
+			"frecuencia":    m.Frecuencia,
+			"duracion_dias": m.DuracionDias,
+			"cantidad":      m.Cantidad,
+		})
+	}
+
+	respuestas.RespondSuccess(c, http.StatusOK, gin.H{
+		"codigo":             receta.CodigoVerificacion,
+		"estado":             receta.Estado,
+		"emitida_en":         receta.EmitidaEn,
+		"medico":             medico.Nombre + " " + medico.ApellidoPaterno + " " + medico.ApellidoMaterno,
+		"especialidad":       receta.Medico.Especialidad,
+		"cedula_profesional": receta.Medico.CedulaProfesional,
+		"paciente":           inicialesPersona(pacienteReceta(receta)),
+		"medicamentos":       medicamentos,
+	})
+}
+
+// Iniciales del paciente: la farmacia confirma la identidad sin ver el nombre completo
+func inicialesPersona(persona models.Persona) string {
+	var partes []string
+	for _, nombre := range []string{persona.Nombre, persona.ApellidoPaterno, persona.ApellidoMaterno} {
+		if nombre = strings.TrimSpace(nombre); nombre != "" {
+			partes = append(partes, string([]rune(nombre)[:1])+".")
+		}
+	}
+	return strings.Join(partes, " ")
+}
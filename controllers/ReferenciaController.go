@@ -0,0 +1,457 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Ilimm9/CMedicas/Respuestas"
+	"github.com/Ilimm9/CMedicas/agenda"
+	"github.com/Ilimm9/CMedicas/eventos"
+	"github.com/Ilimm9/CMedicas/initializers"
+	"github.com/Ilimm9/CMedicas/mensajeria"
+	"github.com/Ilimm9/CMedicas/models"
+	"github.com/Ilimm9/CMedicas/referencias"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type ReferenciaInput struct {
+	CitaID uint `json:"cita_id" binding:"required"`
+	// Destino: un médico en particular o cualquier médico de la especialidad
+	MedicoID     *uint  `json:"medico_id"`
+	Especialidad string `json:"especialidad" binding:"max=100"`
+	Motivo       string `json:"motivo" binding:"required,max=2000"`
+	Prioridad    string `json:"prioridad" binding:"omitempty,oneof=rutina preferente urgente"`
+}
+
+const maxDiasDisponibilidad = 60
+
+func precargarReferencia(db *gorm.DB) *gorm.DB {
+	return db.
+		Preload("MedicoOrigen.Usuario", usuarioPublico).
+		Preload("MedicoOrigen.Usuario.Persona").
+		Preload("MedicoDestino.Usuario", usuarioPublico).
+		Preload("MedicoDestino.Usuario.Persona").
+		Preload("Cita")
+}
+
+// El administrador, el paciente responsable y los médicos que refieren o
+// reciben (el elegido o el de la cita agendada)
+func puedeVerReferencia(c *gin.Context, referencia models.Referencia) bool {
+	switch c.GetString("userRol") {
+	case "administrador":
+		return true
+	case "paciente":
+		usuarioID, ok := usuarioActualID(c)
+		return ok && referencia.PacienteID == usuarioID
+	case "medico":
+		medico, ok := medicoActual(c)
+		if !ok {
+			return false
+		}
+		return referencia.MedicoOrigenID == medico.ID ||
+			(referencia.MedicoDestinoID != nil && *referencia.MedicoDestinoID == medico.ID) ||
+			(referencia.Cita != nil && referencia.Cita.MedicoID == medico.ID)
+	}
+	return false
+}
+
+// Referencia de :id visible para el usuario autenticado
+func referenciaAccesible(c *gin.Context) (models.Referencia, bool) {
+	var referencia models.Referencia
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, "ID inválido")
+		return referencia, false
+	}
+
+	if err := precargarReferencia(initializers.GetDB()).First(&referencia, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			respuestas.RespondError(c, http.StatusNotFound, "Referencia no encontrada")
+		} else {
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al buscar referencia: "+err.Error())
+		}
+		return referencia, false
+	}
+	// Sin acceso se responde igual que si no existiera
+	if !puedeVerReferencia(c, referencia) {
+		respuestas.RespondError(c, http.StatusNotFound, "Referencia no encontrada")
+		return referencia, false
+	}
+	return referencia, true
+}
+
+// Médicos a los que se puede agendar la referencia: el elegido o los de la especialidad
+func medicosReferencia(db *gorm.DB, referencia models.Referencia) ([]models.Medico, error) {
+	var medicos []models.Medico
+	consulta := db.Preload("Usuario.Persona")
+	if referencia.MedicoDestinoID != nil {
+		consulta = consulta.Where("id = ?", *referencia.MedicoDestinoID)
+	} else {
+		consulta = consulta.Where("id IN ("+subconsultaMedicosEspecialidad+")", referencia.Especialidad, referencia.Especialidad)
+	}
+	err := consulta.Where("id <> ?", referencia.MedicoOrigenID).Order("id").Find(&medicos).Error
+	return medicos, err
+}
+
+// Referir al paciente de una cita con otro médico o especialidad (el médico
+// de la cita o un administrador)
+func PostReferencia(c *gin.Context) {
+	var input ReferenciaInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	input.Especialidad = strings.TrimSpace(input.Especialidad)
+	if input.MedicoID == nil && input.Especialidad == "" {
+		respuestas.RespondError(c, http.StatusBadRequest, "Indique la especialidad o el médico al que se refiere")
+		return
+	}
+
+	rol := c.GetString("userRol")
+	if rol != "medico" && rol != "administrador" {
+		respuestas.RespondError(c, http.StatusForbidden, "Solo los médicos pueden emitir referencias")
+		return
+	}
+
+	var cita models.Cita
+	if err := initializers.GetDB().First(&cita, input.CitaID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			respuestas.RespondError(c, http.StatusBadRequest, "Cita no encontrada")
+		} else {
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al verificar cita: "+err.Error())
+		}
+		return
+	}
+	if rol == "medico" {
+		medico, ok := medicoActual(c)
+		if !ok || medico.ID != cita.MedicoID {
+			respuestas.RespondError(c, http.StatusForbidden, "Solo el médico de la cita puede emitir la referencia")
+			return
+		}
+	}
+	if cita.Estado == "cancelada" {
+		respuestas.RespondError(c, http.StatusBadRequest, "No se puede referir desde una cita cancelada")
+		return
+	}
+
+	referencia := models.Referencia{
+		CitaOrigenID:      cita.ID,
+		MedicoOrigenID:    cita.MedicoID,
+		PacienteID:        cita.PacienteID,
+		PersonaPacienteID: cita.PersonaPacienteID,
+		Especialidad:      input.Especialidad,
+		Motivo:            strings.TrimSpace(input.Motivo),
+		Prioridad:         input.Prioridad,
+		Estado:            referencias.Pendiente,
+	}
+	if referencia.Prioridad == "" {
+		referencia.Prioridad = "rutina"
+	}
+	referencia.VenceEn = time.Now().Add(referencias.Vigencia(referencia.Prioridad))
+
+	if input.MedicoID != nil {
+		var destino models.Medico
+		if err := initializers.GetDB().First(&destino, *input.MedicoID).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				respuestas.RespondError(c, http.StatusBadRequest, "Médico no encontrado")
+			} else {
+				respuestas.RespondError(c, http.StatusInternalServerError, "Error al verificar médico: "+err.Error())
+			}
+			return
+		}
+		if destino.ID == cita.MedicoID {
+			respuestas.RespondError(c, http.StatusBadRequest, "No se puede referir al mismo médico")
+			return
+		}
+		referencia.MedicoDestinoID = &destino.ID
+		if referencia.Especialidad == "" {
+			referencia.Especialidad = destino.Especialidad
+		}
+	} else {
+		var medicos int64
+		if err := initializers.GetDB().Model(&models.Medico{}).
+			Where("id IN ("+subconsultaMedicosEspecialidad+")", referencia.Especialidad, referencia.Especialidad).
+			Where("id <> ?", cita.MedicoID).
+			Count(&medicos).Error; err != nil {
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al verificar especialidad: "+err.Error())
+			return
+		}
+		if medicos == 0 {
+			respuestas.RespondError(c, http.StatusBadRequest, "No hay otros médicos con la especialidad '"+referencia.Especialidad+"'")
+			return
+		}
+	}
+
+	var observacion models.Observacion
+	if err := initializers.GetDB().Select("id").Where("cita_id = ? AND anulada_en IS NULL", cita.ID).Limit(1).Find(&observacion).Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al buscar observación: "+err.Error())
+		return
+	}
+	if observacion.ID != 0 {
+		referencia.ObservacionID = &observacion.ID
+	}
+
+	if err := initializers.GetDB().Omit("MedicoOrigen", "MedicoDestino", "Cita").Create(&referencia).Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al guardar referencia: "+err.Error())
+		return
+	}
+
+	if err := precargarReferencia(initializers.GetDB()).First(&referencia, referencia.ID).Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al cargar datos de la referencia: "+err.Error())
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusCreated, referencia)
+}
+
+// Referencias del usuario: las suyas si es paciente; las que emitió o recibió
+// si es médico; todas si es administrador (?estado=)
+func GetReferencias(c *gin.Context) {
+	usuarioID, ok := usuarioActualID(c)
+	if !ok {
+		respuestas.RespondError(c, http.StatusUnauthorized, "Usuario no autenticado")
+		return
+	}
+
+	consulta := precargarReferencia(initializers.GetDB()).Order("creada_en DESC")
+	switch c.GetString("userRol") {
+	case "paciente":
+		consulta = consulta.Where("paciente_id = ?", usuarioID)
+	case "medico":
+		medico, ok := medicoActual(c)
+		if !ok {
+			respuestas.RespondError(c, http.StatusNotFound, "No se encontró médico asociado a este usuario")
+			return
+		}
+		consulta = consulta.Where("medico_origen_id = ? OR medico_destino_id = ? OR cita_id IN (SELECT id FROM cita WHERE medico_id = ?)",
+			medico.ID, medico.ID, medico.ID)
+	case "administrador":
+	default:
+		respuestas.RespondError(c, http.StatusForbidden, "Rol no autorizado para ver referencias")
+		return
+	}
+	if estado := c.Query("estado"); estado != "" {
+		consulta = consulta.Where("estado = ?", estado)
+	}
+
+	var lista []models.Referencia
+	if err := consulta.Find(&lista).Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al obtener referencias: "+err.Error())
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusOK, lista)
+}
+
+// Obtener una referencia
+func GetReferencia(c *gin.Context) {
+	referencia, ok := referenciaAccesible(c)
+	if !ok {
+		return
+	}
+	respuestas.RespondSuccess(c, http.StatusOK, referencia)
+}
+
+// Horarios libres para agendar una referencia pendiente, por médico
+// (?desde=AAAA-MM-DD, hoy por omisión; ?dias=, 14 por omisión y hasta 60)
+func GetDisponibilidadReferencia(c *gin.Context) {
+	referencia, ok := referenciaAccesible(c)
+	if !ok {
+		return
+	}
+	if referencia.Estado != referencias.Pendiente {
+		respuestas.RespondError(c, http.StatusConflict, "La referencia está "+referencia.Estado)
+		return
+	}
+
+	desde, hasta, ok := rangoDisponibilidad(c)
+	if !ok {
+		return
+	}
+
+	medicos, err := medicosReferencia(initializers.GetDB(), referencia)
+	if err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al buscar médicos: "+err.Error())
+		return
+	}
+
+	resultado := make([]gin.H, 0, len(medicos))
+	for _, m := range medicos {
+		espacios, err := agenda.Disponibilidad(initializers.GetDB(), m.ID, desde, hasta)
+		if err != nil {
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al calcular disponibilidad: "+err.Error())
+			return
+		}
+		if espacios == nil {
+			espacios = []time.Time{}
+		}
+		persona := m.Usuario.Persona
+		resultado = append(resultado, gin.H{
+			"medico": gin.H{
+				"id":           m.ID,
+				"nombre":       persona.Nombre + " " + persona.ApellidoPaterno,
+				"especialidad": m.Especialidad,
+			},
+			"horarios": espacios,
+		})
+	}
+
+	respuestas.RespondSuccess(c, http.StatusOK, resultado)
+}
+
+// Rango de ?desde= y ?dias= en la zona de la clínica
+func rangoDisponibilidad(c *gin.Context) (time.Time, time.Time, bool) {
+	zona := mensajeria.ZonaClinica()
+	ahora := time.Now().In(zona)
+	desde := time.Date(ahora.Year(), ahora.Month(), ahora.Day(), 0, 0, 0, 0, zona)
+	if v := c.Query("desde"); v != "" {
+		fecha, err := time.ParseInLocation("2006-01-02", v, zona)
+		if err != nil {
+			respuestas.RespondError(c, http.StatusBadRequest, "Fecha inválida en 'desde', use AAAA-MM-DD")
+			return desde, desde, false
+		}
+		desde = fecha
+	}
+	dias, err := strconv.Atoi(c.DefaultQuery("dias", "14"))
+	if err != nil || dias < 1 || dias > maxDiasDisponibilidad {
+		respuestas.RespondError(c, http.StatusBadRequest, "'dias' debe estar entre 1 y 60")
+		return desde, desde, false
+	}
+	return desde, desde.AddDate(0, 0, dias), true
+}
+
+// El paciente agenda la referencia en un horario libre del médico referido
+// (o de uno de la especialidad). Crea la cita y la referencia queda agendada.
+func AgendarReferencia(c *gin.Context) {
+	referencia, ok := referenciaAccesible(c)
+	if !ok {
+		return
+	}
+	rol := c.GetString("userRol")
+	if rol != "paciente" && rol != "administrador" {
+		respuestas.RespondError(c, http.StatusForbidden, "Solo el paciente puede agendar su referencia")
+		return
+	}
+
+	var input struct {
+		MedicoID  uint      `json:"medico_id"`
+		FechaCita time.Time `json:"fecha_cita" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	medicos, err := medicosReferencia(initializers.GetDB(), referencia)
+	if err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al buscar médicos: "+err.Error())
+		return
+	}
+	var medicoID uint
+	for _, m := range medicos {
+		if input.MedicoID == m.ID || (input.MedicoID == 0 && len(medicos) == 1) {
+			medicoID = m.ID
+		}
+	}
+	if medicoID == 0 {
+		respuestas.RespondError(c, http.StatusBadRequest, "Elija un médico de la referencia (medico_id)")
+		return
+	}
+
+	tx := initializers.GetDB().Begin()
+	if tx.Error != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al iniciar transacción: "+tx.Error.Error())
+		return
+	}
+
+	// Bloqueos: la referencia no se agenda dos veces y el espacio del médico
+	// no lo toma otra reserva simultánea (PostCita y UpdateCita toman el mismo
+	// bloqueo del médico)
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&referencia, referencia.ID).Error; err != nil {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al buscar referencia: "+err.Error())
+		return
+	}
+	if referencia.Estado != referencias.Pendiente {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusConflict, "La referencia está "+referencia.Estado)
+		return
+	}
+	// La pasada de expiración corre cada minuto; el plazo se revisa aquí
+	if !time.Now().Before(referencia.VenceEn) {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusConflict, "La referencia está "+referencias.Expirada)
+		return
+	}
+	if err := agenda.BloquearMedico(tx, medicoID); err != nil {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al verificar médico: "+err.Error())
+		return
+	}
+	libre, err := agenda.EspacioLibre(tx, medicoID, input.FechaCita)
+	if err != nil {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al verificar disponibilidad: "+err.Error())
+		return
+	}
+	if !libre {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusConflict, "El horario ya no está disponible")
+		return
+	}
+
+	motivo := []rune("Referencia (" + referencia.Especialidad + "): " + referencia.Motivo)
+	if len(motivo) > 500 {
+		motivo = motivo[:500]
+	}
+	cita := models.Cita{
+		PacienteID:        referencia.PacienteID,
+		PersonaPacienteID: referencia.PersonaPacienteID,
+		MedicoID:          medicoID,
+		FechaCita:         input.FechaCita,
+		Motivo:            string(motivo),
+		Estado:            "programada",
+	}
+	if err := tx.Create(&cita).Error; err != nil {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al guardar cita: "+err.Error())
+		return
+	}
+
+	actorID, _ := usuarioActualID(c)
+	creada := eventos.Evento{Tipo: eventos.CitaCreada, Cita: cita, ActorID: actorID}
+	if err := eventos.Emitir(tx, creada); err != nil {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al registrar evento de cita: "+err.Error())
+		return
+	}
+
+	if err := tx.Model(&referencia).Updates(map[string]interface{}{
+		"estado":      referencias.Agendada,
+		"cita_id":     cita.ID,
+		"agendada_en": time.Now(),
+	}).Error; err != nil {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al actualizar referencia: "+err.Error())
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al confirmar transacción: "+err.Error())
+		return
+	}
+	eventos.Confirmados(creada)
+
+	if err := precargarReferencia(initializers.GetDB()).First(&referencia, referencia.ID).Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al cargar datos de la referencia: "+err.Error())
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusCreated, referencia)
+}
@@ -0,0 +1,551 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Ilimm9/CMedicas/Respuestas"
+	"github.com/Ilimm9/CMedicas/clave"
+	"github.com/Ilimm9/CMedicas/initializers"
+	"github.com/Ilimm9/CMedicas/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	duracionDesafioLogin   = 5 * time.Minute
+	maxIntentosDesafio     = 5
+	numCodigosRecuperacion = 10
+	// Fallos seguidos por usuario (en cualquier desafío) antes del bloqueo temporal
+	maxFallosSegundoFactor       = 10
+	duracionBloqueoSegundoFactor = 15 * time.Minute
+)
+
+// Crea un desafío de login pendiente y devuelve el token en claro
+func crearDesafioLogin(usuarioID uint) (string, error) {
+	token, err := clave.GenerarTokenAleatorio()
+	if err != nil {
+		return "", err
+	}
+
+	desafio := models.DesafioLogin{
+		UsuarioID: usuarioID,
+		TokenHash: clave.HashToken(token),
+		ExpiraEn:  time.Now().Add(duracionDesafioLogin),
+	}
+	if err := initializers.GetDB().Create(&desafio).Error; err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// Busca un desafío vigente y carga su usuario. La fila queda bloqueada hasta el
+// fin de la transacción para que verificaciones simultáneas no compartan un intento.
+func buscarDesafioVigente(tx *gorm.DB, token string) (*models.DesafioLogin, error) {
+	var desafio models.DesafioLogin
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Preload("Usuario").
+		Where("token_hash = ? AND usado_en IS NULL AND expira_en > ?", clave.HashToken(token), time.Now()).
+		First(&desafio).Error
+	if err != nil {
+		return nil, err
+	}
+	if desafio.Intentos >= maxIntentosDesafio {
+		return nil, errors.New("demasiados intentos")
+	}
+	return &desafio, nil
+}
+
+// El usuario acumuló demasiados fallos del segundo factor
+func segundoFactorBloqueado(usuario *models.Usuario) bool {
+	return usuario.TOTPBloqueadoHasta != nil && time.Now().Before(*usuario.TOTPBloqueadoHasta)
+}
+
+func responderSegundoFactorBloqueado(c *gin.Context) {
+	respuestas.RespondError(c, http.StatusTooManyRequests, "Demasiados intentos fallidos; intente más tarde")
+}
+
+// Cuenta un fallo del segundo factor; al llegar al máximo bloquea al usuario un
+// tiempo, así iniciar otro desafío con la contraseña no da intentos nuevos
+func registrarFalloSegundoFactor(db *gorm.DB, usuarioID uint) error {
+	return db.Model(&models.Usuario{}).Where("id = ?", usuarioID).Updates(map[string]interface{}{
+		"totp_fallos": gorm.Expr("CASE WHEN totp_fallos + 1 >= ? THEN 0 ELSE totp_fallos + 1 END", maxFallosSegundoFactor),
+		"totp_bloqueado_hasta": gorm.Expr("CASE WHEN totp_fallos + 1 >= ? THEN ?::timestamptz ELSE totp_bloqueado_hasta END",
+			maxFallosSegundoFactor, time.Now().Add(duracionBloqueoSegundoFactor)),
+	}).Error
+}
+
+// Valida un código TOTP del usuario y registra el paso aceptado, de modo que el
+// mismo código no sirva otra vez; los códigos inválidos cuentan como fallo
+func aceptarCodigoTOTP(db *gorm.DB, usuario *models.Usuario, codigo string) (bool, error) {
+	paso, ok := clave.ValidarCodigoTOTP(usuario.TOTPSecreto, codigo, time.Now(), usuario.TOTPUltimoPaso)
+	if !ok {
+		return false, registrarFalloSegundoFactor(db, usuario.ID)
+	}
+
+	// Condicionado al último paso para que dos verificaciones simultáneas no
+	// acepten el mismo código
+	result := db.Model(&models.Usuario{}).
+		Where("id = ? AND totp_ultimo_paso < ?", usuario.ID, paso).
+		Updates(map[string]interface{}{"totp_ultimo_paso": paso, "totp_fallos": 0})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return false, registrarFalloSegundoFactor(db, usuario.ID)
+	}
+	usuario.TOTPUltimoPaso = paso
+	return true, nil
+}
+
+// Genera y guarda un nuevo juego de códigos de recuperación (reemplaza los anteriores)
+func regenerarCodigosRecuperacion(tx *gorm.DB, usuarioID uint) ([]string, error) {
+	codigos, err := clave.GenerarCodigosRecuperacion(numCodigosRecuperacion)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Where("usuario_id = ?", usuarioID).Delete(&models.CodigoRecuperacion{}).Error; err != nil {
+		return nil, err
+	}
+
+	registros := make([]models.CodigoRecuperacion, 0, len(codigos))
+	for _, codigo := range codigos {
+		registros = append(registros, models.CodigoRecuperacion{
+			UsuarioID: usuarioID,
+			Hash:      clave.HashToken(codigo),
+		})
+	}
+	if err := tx.Create(&registros).Error; err != nil {
+		return nil, err
+	}
+	return codigos, nil
+}
+
+// Marca el segundo factor como activo y emite los códigos de recuperación
+func activarSegundoFactor(tx *gorm.DB, usuario *models.Usuario) ([]string, error) {
+	ahora := time.Now()
+	usuario.TOTPActivo = true
+	usuario.TOTPActivadoEn = &ahora
+	if err := tx.Model(usuario).Updates(map[string]interface{}{
+		"totp_activo":      true,
+		"totp_activado_en": ahora,
+	}).Error; err != nil {
+		return nil, err
+	}
+	return regenerarCodigosRecuperacion(tx, usuario.ID)
+}
+
+// Consume un código de recuperación no usado; devuelve false si no coincide
+func usarCodigoRecuperacion(tx *gorm.DB, usuarioID uint, codigo string) (bool, error) {
+	result := tx.Model(&models.CodigoRecuperacion{}).
+		Where("usuario_id = ? AND hash = ? AND usado_en IS NULL", usuarioID, clave.HashToken(clave.NormalizarCodigoRecuperacion(codigo))).
+		Update("usado_en", time.Now())
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// Valida el código TOTP del usuario autenticado; responde el error y devuelve
+// false si está bloqueado o el código no es válido
+func validarCodigoUsuario(c *gin.Context, usuario *models.Usuario, codigo string) bool {
+	if segundoFactorBloqueado(usuario) {
+		responderSegundoFactorBloqueado(c)
+		return false
+	}
+	valido, err := aceptarCodigoTOTP(initializers.GetDB(), usuario, codigo)
+	if err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al verificar código: "+err.Error())
+		return false
+	}
+	if !valido {
+		respuestas.RespondError(c, http.StatusUnauthorized, "Código inválido")
+		return false
+	}
+	return true
+}
+
+// Inicia el enrolamiento de un usuario que aún no tiene segundo factor activo
+func iniciarEnrolamiento(usuario *models.Usuario) (gin.H, error) {
+	secreto, err := clave.GenerarSecretoTOTP()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := initializers.GetDB().Model(usuario).Update("totp_secreto", secreto).Error; err != nil {
+		return nil, err
+	}
+
+	return gin.H{
+		"secreto":     secreto,
+		"uri_otpauth": clave.URIProvisionamientoTOTP(secreto, usuario.Correo),
+		"mensaje":     "Escanee la URI como código QR y confirme con un código de 6 dígitos",
+	}, nil
+}
+
+// Enrolamiento forzado durante el login (rol con segundo factor obligatorio)
+func EnrolarSegundoFactorDesafio(c *gin.Context) {
+	var input struct {
+		Desafio string `json:"desafio" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	desafio, err := buscarDesafioVigente(initializers.GetDB(), input.Desafio)
+	if err != nil {
+		respuestas.RespondError(c, http.StatusUnauthorized, "Desafío inválido o expirado")
+		return
+	}
+
+	if desafio.Usuario.TOTPActivo {
+		respuestas.RespondError(c, http.StatusConflict, "El segundo factor ya está activo")
+		return
+	}
+
+	datos, err := iniciarEnrolamiento(&desafio.Usuario)
+	if err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al generar secreto: "+err.Error())
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusOK, datos)
+}
+
+// Segundo paso del login: valida el código TOTP (o de recuperación) y emite el JWT
+func VerificarSegundoFactor(c *gin.Context) {
+	var input struct {
+		Desafio            string `json:"desafio" binding:"required"`
+		Codigo             string `json:"codigo"`
+		CodigoRecuperacion string `json:"codigo_recuperacion"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if input.Codigo == "" && input.CodigoRecuperacion == "" {
+		respuestas.RespondError(c, http.StatusBadRequest, "Debe enviar codigo o codigo_recuperacion")
+		return
+	}
+
+	tx := initializers.GetDB().Begin()
+	if tx.Error != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al iniciar transacción: "+tx.Error.Error())
+		return
+	}
+
+	desafio, err := buscarDesafioVigente(tx, input.Desafio)
+	if err != nil {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusUnauthorized, "Desafío inválido o expirado")
+		return
+	}
+	usuario := desafio.Usuario
+
+	if !usuario.Activo {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusForbidden, "La cuenta está deshabilitada")
+		return
+	}
+
+	if usuario.TOTPSecreto == "" {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusBadRequest, "Debe completar el enrolamiento del segundo factor")
+		return
+	}
+
+	if segundoFactorBloqueado(&usuario) {
+		tx.Rollback()
+		responderSegundoFactorBloqueado(c)
+		return
+	}
+
+	valido := false
+	if input.Codigo != "" {
+		valido, err = aceptarCodigoTOTP(tx, &usuario, input.Codigo)
+	} else if usuario.TOTPActivo {
+		valido, err = usarCodigoRecuperacion(tx, usuario.ID, input.CodigoRecuperacion)
+		if err == nil {
+			if valido {
+				err = tx.Model(&usuario).Update("totp_fallos", 0).Error
+			} else {
+				err = registrarFalloSegundoFactor(tx, usuario.ID)
+			}
+		}
+	}
+	if err != nil {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al verificar código: "+err.Error())
+		return
+	}
+
+	if !valido {
+		// El intento fallido se confirma con el desafío aún bloqueado, así el
+		// siguiente intento ya lo cuenta
+		if err := tx.Model(desafio).Update("intentos", gorm.Expr("intentos + 1")).Error; err != nil {
+			tx.Rollback()
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al registrar intento: "+err.Error())
+			return
+		}
+		if err := tx.Commit().Error; err != nil {
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al confirmar transacción: "+err.Error())
+			return
+		}
+		respuestas.RespondError(c, http.StatusUnauthorized, "Código inválido")
+		return
+	}
+
+	// Si el usuario estaba enrolándose, este código confirma el enrolamiento
+	var codigos []string
+	if !usuario.TOTPActivo {
+		codigos, err = activarSegundoFactor(tx, &usuario)
+		if err != nil {
+			tx.Rollback()
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al activar segundo factor: "+err.Error())
+			return
+		}
+	}
+
+	if err := tx.Model(desafio).Update("usado_en", time.Now()).Error; err != nil {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al cerrar desafío: "+err.Error())
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al confirmar transacción: "+err.Error())
+		return
+	}
+
+	token, err := clave.GenerateJWT(usuario.ID, usuario.Rol, usuario.VersionSesion)
+	if err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al generar token")
+		return
+	}
+
+	initializers.GetDB().Preload("Persona").First(&usuario, usuario.ID)
+	usuario.Contrasena = ""
+
+	respuesta := gin.H{
+		"token":   token,
+		"usuario": usuario,
+	}
+	if codigos != nil {
+		respuesta["codigos_recuperacion"] = codigos
+	}
+	respuestas.RespondSuccess(c, http.StatusOK, respuesta)
+}
+
+// Enrolamiento voluntario del usuario autenticado
+func EnrolarSegundoFactor(c *gin.Context) {
+	usuario, ok := cargarUsuarioActual(c)
+	if !ok {
+		return
+	}
+
+	if usuario.TOTPActivo {
+		respuestas.RespondError(c, http.StatusConflict, "El segundo factor ya está activo")
+		return
+	}
+
+	datos, err := iniciarEnrolamiento(usuario)
+	if err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al generar secreto: "+err.Error())
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusOK, datos)
+}
+
+// Confirma el enrolamiento con un primer código y devuelve los códigos de recuperación
+func ActivarSegundoFactor(c *gin.Context) {
+	var input struct {
+		Codigo string `json:"codigo" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	usuario, ok := cargarUsuarioActual(c)
+	if !ok {
+		return
+	}
+
+	if usuario.TOTPActivo {
+		respuestas.RespondError(c, http.StatusConflict, "El segundo factor ya está activo")
+		return
+	}
+
+	if usuario.TOTPSecreto == "" {
+		respuestas.RespondError(c, http.StatusBadRequest, "Primero debe iniciar el enrolamiento")
+		return
+	}
+
+	if !validarCodigoUsuario(c, usuario, input.Codigo) {
+		return
+	}
+
+	var codigos []string
+	err := initializers.GetDB().Transaction(func(tx *gorm.DB) error {
+		var err error
+		codigos, err = activarSegundoFactor(tx, usuario)
+		return err
+	})
+	if err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al activar segundo factor: "+err.Error())
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusOK, gin.H{
+		"mensaje":              "Segundo factor activado",
+		"codigos_recuperacion": codigos,
+	})
+}
+
+// Desactiva el segundo factor (no permitido si la política del rol lo exige)
+func DesactivarSegundoFactor(c *gin.Context) {
+	var input struct {
+		Contrasena string `json:"contrasena" binding:"required"`
+		Codigo     string `json:"codigo" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	usuario, ok := cargarUsuarioActual(c)
+	if !ok {
+		return
+	}
+
+	if clave.SegundoFactorObligatorio(usuario.Rol) {
+		respuestas.RespondError(c, http.StatusForbidden, "El segundo factor es obligatorio para su rol")
+		return
+	}
+
+	if !usuario.TOTPActivo {
+		respuestas.RespondError(c, http.StatusBadRequest, "El segundo factor no está activo")
+		return
+	}
+
+	if segundoFactorBloqueado(usuario) {
+		responderSegundoFactorBloqueado(c)
+		return
+	}
+	if !clave.CheckPasswordHash(input.Contrasena, usuario.Contrasena) {
+		respuestas.RespondError(c, http.StatusUnauthorized, "Credenciales inválidas")
+		return
+	}
+	valido, err := aceptarCodigoTOTP(initializers.GetDB(), usuario, input.Codigo)
+	if err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al verificar código: "+err.Error())
+		return
+	}
+	if !valido {
+		respuestas.RespondError(c, http.StatusUnauthorized, "Credenciales inválidas")
+		return
+	}
+
+	if err := initializers.GetDB().Transaction(func(tx *gorm.DB) error {
+		return restablecerSegundoFactor(tx, usuario.ID)
+	}); err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al desactivar segundo factor: "+err.Error())
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusOK, "Segundo factor desactivado")
+}
+
+// Regenera los códigos de recuperación del usuario autenticado
+func RegenerarCodigosRecuperacion(c *gin.Context) {
+	var input struct {
+		Codigo string `json:"codigo" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	usuario, ok := cargarUsuarioActual(c)
+	if !ok {
+		return
+	}
+
+	if !usuario.TOTPActivo {
+		respuestas.RespondError(c, http.StatusBadRequest, "El segundo factor no está activo")
+		return
+	}
+
+	if !validarCodigoUsuario(c, usuario, input.Codigo) {
+		return
+	}
+
+	var codigos []string
+	err := initializers.GetDB().Transaction(func(tx *gorm.DB) error {
+		var err error
+		codigos, err = regenerarCodigosRecuperacion(tx, usuario.ID)
+		return err
+	})
+	if err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al generar códigos: "+err.Error())
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusOK, gin.H{"codigos_recuperacion": codigos})
+}
+
+// Borra secreto, códigos de recuperación y desafíos pendientes de un usuario
+func restablecerSegundoFactor(tx *gorm.DB, usuarioID uint) error {
+	if err := tx.Model(&models.Usuario{}).Where("id = ?", usuarioID).Updates(map[string]interface{}{
+		"totp_secreto":         "",
+		"totp_activo":          false,
+		"totp_activado_en":     nil,
+		"totp_fallos":          0,
+		"totp_bloqueado_hasta": nil,
+	}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("usuario_id = ?", usuarioID).Delete(&models.CodigoRecuperacion{}).Error; err != nil {
+		return err
+	}
+	return tx.Where("usuario_id = ? AND usado_en IS NULL", usuarioID).Delete(&models.DesafioLogin{}).Error
+}
+
+// Restablece el segundo factor de un usuario (administrador), p. ej. si perdió su dispositivo
+func ResetSegundoFactor(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, "ID inválido")
+		return
+	}
+
+	var usuario models.Usuario
+	if err := initializers.GetDB().First(&usuario, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			respuestas.RespondError(c, http.StatusNotFound, "Usuario no encontrado")
+		} else {
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al buscar usuario: "+err.Error())
+		}
+		return
+	}
+
+	if err := initializers.GetDB().Transaction(func(tx *gorm.DB) error {
+		return restablecerSegundoFactor(tx, usuario.ID)
+	}); err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al restablecer segundo factor: "+err.Error())
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusOK, gin.H{
+		"message":                "Segundo factor restablecido",
+		"enrolamiento_requerido": clave.SegundoFactorObligatorio(usuario.Rol),
+	})
+}
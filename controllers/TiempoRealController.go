@@ -0,0 +1,92 @@
+package controllers
+
+import (
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/Ilimm9/CMedicas/Respuestas"
+	"github.com/Ilimm9/CMedicas/clave"
+	"github.com/Ilimm9/CMedicas/initializers"
+	"github.com/Ilimm9/CMedicas/models"
+	"github.com/Ilimm9/CMedicas/tiemporeal"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Intervalo de latido para que proxies y balanceadores no cierren la conexión
+const intervaloLatido = 25 * time.Second
+
+// Boleto para abrir el flujo de eventos con EventSource (/api/eventos?ticket=)
+func PostTicketEventos(c *gin.Context) {
+	usuario, ok := cargarUsuarioActual(c)
+	if !ok {
+		return
+	}
+
+	ticket, err := clave.GenerarTicketEventos(usuario.ID, c.GetString("userRol"), usuario.VersionSesion)
+	if err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al generar boleto: "+err.Error())
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusCreated, gin.H{
+		"ticket":    ticket,
+		"expira_en": time.Now().Add(clave.VigenciaTicketEventos),
+	})
+}
+
+// La sesión con la que se abrió el flujo sigue vigente (no se cerró sesión,
+// no se cambió la contraseña ni se desactivó la cuenta)
+func sesionVigente(usuarioID, version uint) bool {
+	var usuario models.Usuario
+	if err := initializers.GetDB().Select("id", "activo", "version_sesion").First(&usuario, usuarioID).Error; err != nil {
+		return false
+	}
+	return usuario.Activo && usuario.VersionSesion == version
+}
+
+// Flujo Server-Sent Events con las notificaciones y cambios de cita visibles
+// para el usuario: el paciente los suyos, el médico su agenda, el administrador todo
+func GetEventosTiempoReal(c *gin.Context) {
+	usuarioID, ok := usuarioActualID(c)
+	if !ok {
+		respuestas.RespondError(c, http.StatusUnauthorized, "Usuario no autenticado")
+		return
+	}
+
+	version, _ := c.Get("versionSesion")
+	versionSesion, _ := version.(uint)
+
+	canal, cancelar := tiemporeal.Suscribir(tiemporeal.Suscriptor{UsuarioID: usuarioID, Rol: c.GetString("userRol")})
+	defer cancelar()
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no") // nginx: no almacenar en búfer el flujo
+
+	latido := time.NewTicker(intervaloLatido)
+	defer latido.Stop()
+
+	c.SSEvent("conectado", gin.H{"usuario_id": usuarioID})
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case ev, ok := <-canal:
+			if !ok {
+				return false
+			}
+			c.SSEvent(ev.Tipo, ev)
+			return true
+		case <-latido.C:
+			// Una sesión invalidada deja de recibir eventos
+			if !sesionVigente(usuarioID, versionSesion) {
+				c.SSEvent("sesion_cerrada", gin.H{})
+				return false
+			}
+			c.SSEvent("latido", time.Now().Unix())
+			return true
+		}
+	})
+}
@@ -15,8 +15,11 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+// Alta de usuario por el administrador con cualquier rol; en lugar de contraseña
+// se envía una invitación por correo para que el usuario la defina
 func PostUsuario(c *gin.Context) {
 	var input dto.UsuarioInput
 	if err := c.ShouldBindJSON(&input); err != nil {
@@ -24,7 +27,7 @@ func PostUsuario(c *gin.Context) {
 		return
 	}
 
-	if !repositories.ExistePersonaPorID(input.PersonaID) {
+	if input.Persona == nil && !repositories.ExistePersonaPorID(input.PersonaID) {
 		respuestas.RespondError(c, http.StatusBadRequest, "Persona no encontrada")
 		return
 	}
@@ -34,32 +37,83 @@ func PostUsuario(c *gin.Context) {
 		return
 	}
 
-	hashedPassword, err := clave.HashPassword(input.Contrasena)
-	if err != nil {
-		respuestas.RespondError(c, http.StatusInternalServerError, "Error al hashear contraseña")
+	tx := initializers.GetDB().Begin()
+	if tx.Error != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al iniciar transacción: "+tx.Error.Error())
 		return
 	}
 
+	personaID := input.PersonaID
+	if input.Persona != nil {
+		fecha, err := time.Parse("2006-01-02", input.Persona.FechaNacimiento)
+		if err != nil {
+			tx.Rollback()
+			respuestas.RespondError(c, http.StatusBadRequest, "Formato de fecha inválido (usa YYYY-MM-DD)")
+			return
+		}
+
+		persona := models.Persona{
+			Nombre:          input.Persona.Nombre,
+			ApellidoPaterno: input.Persona.ApellidoPaterno,
+			ApellidoMaterno: input.Persona.ApellidoMaterno,
+			Telefono:        input.Persona.Telefono,
+			FechaNacimiento: fecha,
+			Genero:          input.Persona.Genero,
+			Direccion:       input.Persona.Direccion,
+		}
+		if err := tx.Create(&persona).Error; err != nil {
+			tx.Rollback()
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al crear persona: "+err.Error())
+			return
+		}
+		personaID = persona.ID
+	}
+
+	// Sin contraseña utilizable hasta que acepte la invitación
 	usuario := models.Usuario{
-		PersonaID:  input.PersonaID,
-		Rol:        input.Rol,
-		Correo:     input.Correo,
-		Contrasena: hashedPassword,
+		PersonaID: personaID,
+		Rol:       input.Rol,
+		Correo:    input.Correo,
 	}
 
-	if err := repositories.CrearUsuario(&usuario); err != nil {
-		respuestas.RespondError(c, http.StatusInternalServerError, err.Error())
+	if err := tx.Create(&usuario).Error; err != nil {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusConflict, "No se pudo crear el usuario: "+err.Error())
 		return
 	}
 
-	response := dto.UsuarioResponse{
-		ID:        usuario.ID,
-		PersonaID: usuario.PersonaID,
-		Rol:       usuario.Rol,
-		Correo:    usuario.Correo,
+	if input.Rol == "medico" {
+		medico := models.Medico{UsuarioID: usuario.ID, Especialidad: input.Especialidad}
+		if err := tx.Create(&medico).Error; err != nil {
+			tx.Rollback()
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al crear médico: "+err.Error())
+			return
+		}
+		if err := asignarEspecialidades(tx, &medico, []string{input.Especialidad}); err != nil {
+			tx.Rollback()
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al asignar especialidad: "+err.Error())
+			return
+		}
 	}
 
-	respuestas.RespondSuccess(c, http.StatusCreated, response)
+	token, err := crearTokenUsuario(tx, usuario.ID, "invitacion")
+	if err != nil {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al generar invitación: "+err.Error())
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al confirmar transacción: "+err.Error())
+		return
+	}
+
+	enviado := enviarCorreoToken(usuario, "invitacion", token)
+
+	respuestas.RespondSuccess(c, http.StatusCreated, gin.H{
+		"usuario":            usuarioResponse(usuario),
+		"invitacion_enviada": enviado,
+	})
 }
 
 func RegistroCompleto(c *gin.Context) {
@@ -165,22 +219,29 @@ func GetUsuario(c *gin.Context) {
 		return
 	}
 
-	response := dto.UsuarioResponse{
-		ID:        usuario.ID,
-		PersonaID: usuario.PersonaID,
-		Rol:       usuario.Rol,
-		Correo:    usuario.Correo,
-	}
-
-	respuestas.RespondSuccess(c, http.StatusOK, response)
+	respuestas.RespondSuccess(c, http.StatusOK, usuarioResponse(*usuario))
 }
 
 
 
-// Obtener todos los usuarios
+// Obtener todos los usuarios (filtros opcionales: rol, activo)
 func GetAllUsuarios(c *gin.Context) {
+	query := initializers.GetDB().Preload("Persona")
+
+	if rol := c.Query("rol"); rol != "" {
+		query = query.Where("rol = ?", rol)
+	}
+	if activo := c.Query("activo"); activo != "" {
+		valor, err := strconv.ParseBool(activo)
+		if err != nil {
+			respuestas.RespondError(c, http.StatusBadRequest, "Valor de activo inválido")
+			return
+		}
+		query = query.Where("activo = ?", valor)
+	}
+
 	var usuarios []models.Usuario
-	result := initializers.GetDB().Preload("Persona").Find(&usuarios)
+	result := query.Order("id").Find(&usuarios)
 	if result.Error != nil {
 		respuestas.RespondError(c, http.StatusInternalServerError, "Error al obtener usuarios: "+result.Error.Error())
 		return
@@ -194,7 +255,8 @@ func GetAllUsuarios(c *gin.Context) {
 	respuestas.RespondSuccess(c, http.StatusOK, usuarios)
 }
 
-// Actualizar usuario
+// Actualizar datos de cuenta (correo, persona). El rol y la contraseña
+// tienen sus propios endpoints para aplicar sus reglas.
 func UpdateUsuario(c *gin.Context) {
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
@@ -202,29 +264,61 @@ func UpdateUsuario(c *gin.Context) {
 		return
 	}
 
-	var input dto.UsuarioInput
+	var input dto.UsuarioUpdateInput
 	if err := c.ShouldBindJSON(&input); err != nil {
 		respuestas.RespondError(c, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	usuario := models.Usuario{
-		Correo:    input.Correo,
-		Contrasena: input.Contrasena,
-		Rol:       input.Rol,
-		PersonaID: input.PersonaID,
+	usuario, err := repositories.ObtenerUsuarioPorID(uint(id))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			respuestas.RespondError(c, http.StatusNotFound, "Usuario no encontrado")
+		} else {
+			respuestas.RespondError(c, http.StatusInternalServerError, err.Error())
+		}
+		return
 	}
 
-	if err := repositories.ActualizarUsuario(uint(id), &usuario); err != nil {
+	if input.Correo != "" && input.Correo != usuario.Correo {
+		if repositories.ExisteCorreo(input.Correo) {
+			respuestas.RespondError(c, http.StatusBadRequest, "Correo ya registrado")
+			return
+		}
+		usuario.Correo = input.Correo
+	}
+	if input.PersonaID != 0 {
+		if !repositories.ExistePersonaPorID(input.PersonaID) {
+			respuestas.RespondError(c, http.StatusBadRequest, "Persona no encontrada")
+			return
+		}
+		usuario.PersonaID = input.PersonaID
+	}
+
+	if err := repositories.ActualizarUsuario(uint(id), usuario); err != nil {
 		respuestas.RespondError(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	respuestas.RespondSuccess(c, http.StatusOK, "Usuario actualizado correctamente")
+	respuestas.RespondSuccess(c, http.StatusOK, usuarioResponse(*usuario))
 }
 
 
-// Eliminar usuario
+var errUltimoAdministrador = errors.New("Debe existir al menos un administrador activo")
+
+// Hay otro administrador activo además de usuarioID. Bloquea sus filas hasta el
+// fin de la transacción, para que dos administradores no se quiten uno al otro
+// a la vez.
+func quedaOtroAdministrador(tx *gorm.DB, usuarioID uint) (bool, error) {
+	var admins []uint
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Model(&models.Usuario{}).
+		Where("rol = ? AND activo = ? AND id <> ?", "administrador", true, usuarioID).
+		Pluck("id", &admins).Error
+	return len(admins) > 0, err
+}
+
+// Eliminar usuario (solo si no tiene historial; en otro caso debe deshabilitarse)
 func DeleteUsuario(c *gin.Context) {
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
@@ -232,14 +326,258 @@ func DeleteUsuario(c *gin.Context) {
 		return
 	}
 
-	if err := repositories.EliminarUsuario(uint(id)); err != nil {
-		respuestas.RespondError(c, http.StatusInternalServerError, err.Error())
+	if actualID, _ := usuarioActualID(c); actualID == uint(id) {
+		respuestas.RespondError(c, http.StatusBadRequest, "No puede eliminar su propia cuenta")
+		return
+	}
+
+	var count int64
+	if err := initializers.GetDB().Model(&models.Cita{}).
+		Where("paciente_id = ? OR medico_id IN (?)", id,
+			initializers.GetDB().Model(&models.Medico{}).Select("id").Where("usuario_id = ?", id)).
+		Count(&count).Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al verificar citas: "+err.Error())
+		return
+	}
+
+	if count > 0 {
+		respuestas.RespondError(c, http.StatusConflict, "El usuario tiene citas registradas, deshabilítelo en su lugar")
+		return
+	}
+
+	err = initializers.GetDB().Transaction(func(tx *gorm.DB) error {
+		var usuario models.Usuario
+		if err := tx.Select("id", "rol", "activo").First(&usuario, id).Error; err != nil {
+			return err
+		}
+		if usuario.Rol == "administrador" && usuario.Activo {
+			otro, err := quedaOtroAdministrador(tx, usuario.ID)
+			if err != nil {
+				return err
+			}
+			if !otro {
+				return errUltimoAdministrador
+			}
+		}
+
+		var medico models.Medico
+		if err := tx.Where("usuario_id = ?", id).First(&medico).Error; err == nil {
+			if err := tx.Where("medico_id = ?", medico.ID).Delete(&models.Horario{}).Error; err != nil {
+				return err
+			}
+			if _, err := eliminarMedico(tx, medico.ID); err != nil {
+				return err
+			}
+		}
+		result := tx.Delete(&models.Usuario{}, id)
+		if result.Error == nil && result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return result.Error
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			respuestas.RespondError(c, http.StatusNotFound, "Usuario no encontrado")
+		} else if errors.Is(err, errUltimoAdministrador) {
+			respuestas.RespondError(c, http.StatusConflict, err.Error())
+		} else {
+			respuestas.RespondError(c, http.StatusInternalServerError, err.Error())
+		}
 		return
 	}
 
 	respuestas.RespondSuccess(c, http.StatusOK, "Usuario eliminado correctamente")
 }
 
+// Cambiar el rol de un usuario, creando o retirando el Medico asociado
+func CambiarRolUsuario(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, "ID inválido")
+		return
+	}
+
+	var input dto.CambioRolInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if actualID, _ := usuarioActualID(c); actualID == uint(id) {
+		respuestas.RespondError(c, http.StatusBadRequest, "No puede cambiar su propio rol")
+		return
+	}
+
+	tx := initializers.GetDB().Begin()
+	if tx.Error != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al iniciar transacción: "+tx.Error.Error())
+		return
+	}
+
+	var usuario models.Usuario
+	if err := tx.Preload("Medico").First(&usuario, id).Error; err != nil {
+		tx.Rollback()
+		if err == gorm.ErrRecordNotFound {
+			respuestas.RespondError(c, http.StatusNotFound, "Usuario no encontrado")
+		} else {
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al buscar usuario: "+err.Error())
+		}
+		return
+	}
+
+	if usuario.Rol == input.Rol {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusBadRequest, "El usuario ya tiene ese rol")
+		return
+	}
+
+	// Deja de ser médico: solo si no tiene citas, para no romper su historial
+	if usuario.Rol == "medico" && usuario.Medico != nil {
+		var count int64
+		if err := tx.Model(&models.Cita{}).Where("medico_id = ?", usuario.Medico.ID).Count(&count).Error; err != nil {
+			tx.Rollback()
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al verificar citas: "+err.Error())
+			return
+		}
+		if count > 0 {
+			tx.Rollback()
+			respuestas.RespondError(c, http.StatusConflict, "El médico tiene citas registradas; reasígnelas o deshabilite la cuenta")
+			return
+		}
+
+		if err := tx.Where("medico_id = ?", usuario.Medico.ID).Delete(&models.Horario{}).Error; err != nil {
+			tx.Rollback()
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al eliminar horarios: "+err.Error())
+			return
+		}
+		if _, err := eliminarMedico(tx, usuario.Medico.ID); err != nil {
+			tx.Rollback()
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al eliminar médico: "+err.Error())
+			return
+		}
+	}
+
+	// Deja de ser administrador: debe quedar al menos otro administrador activo
+	if usuario.Rol == "administrador" {
+		otro, err := quedaOtroAdministrador(tx, usuario.ID)
+		if err != nil {
+			tx.Rollback()
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al verificar administradores: "+err.Error())
+			return
+		}
+		if !otro {
+			tx.Rollback()
+			respuestas.RespondError(c, http.StatusConflict, errUltimoAdministrador.Error())
+			return
+		}
+	}
+
+	if input.Rol == "medico" {
+		medico := models.Medico{UsuarioID: usuario.ID, Especialidad: input.Especialidad}
+		if err := tx.Create(&medico).Error; err != nil {
+			tx.Rollback()
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al crear médico: "+err.Error())
+			return
+		}
+		if err := asignarEspecialidades(tx, &medico, []string{input.Especialidad}); err != nil {
+			tx.Rollback()
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al asignar especialidad: "+err.Error())
+			return
+		}
+	}
+
+	// El rol viaja en el JWT: se invalidan las sesiones abiertas
+	if err := tx.Model(&usuario).Updates(map[string]interface{}{
+		"rol":            input.Rol,
+		"version_sesion": gorm.Expr("version_sesion + 1"),
+	}).Error; err != nil {
+		tx.Rollback()
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al actualizar rol: "+err.Error())
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al confirmar transacción: "+err.Error())
+		return
+	}
+
+	usuario.Rol = input.Rol
+	respuestas.RespondSuccess(c, http.StatusOK, usuarioResponse(usuario))
+}
+
+// Habilitar o deshabilitar una cuenta; al deshabilitar se cierran sus sesiones
+func CambiarEstadoUsuario(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, "ID inválido")
+		return
+	}
+
+	var input struct {
+		Activo *bool `json:"activo" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		respuestas.RespondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if actualID, _ := usuarioActualID(c); actualID == uint(id) {
+		respuestas.RespondError(c, http.StatusBadRequest, "No puede cambiar el estado de su propia cuenta")
+		return
+	}
+
+	usuario, err := repositories.ObtenerUsuarioPorID(uint(id))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			respuestas.RespondError(c, http.StatusNotFound, "Usuario no encontrado")
+		} else {
+			respuestas.RespondError(c, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	cambios := map[string]interface{}{"activo": *input.Activo}
+	if !*input.Activo {
+		cambios["version_sesion"] = gorm.Expr("version_sesion + 1")
+	}
+
+	err = initializers.GetDB().Transaction(func(tx *gorm.DB) error {
+		// Deshabilitar al último administrador activo dejaría a todos fuera
+		if !*input.Activo && usuario.Rol == "administrador" {
+			otro, err := quedaOtroAdministrador(tx, usuario.ID)
+			if err != nil {
+				return err
+			}
+			if !otro {
+				return errUltimoAdministrador
+			}
+		}
+		return tx.Model(usuario).Updates(cambios).Error
+	})
+	if err != nil {
+		if errors.Is(err, errUltimoAdministrador) {
+			respuestas.RespondError(c, http.StatusConflict, err.Error())
+		} else {
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al actualizar estado: "+err.Error())
+		}
+		return
+	}
+
+	usuario.Activo = *input.Activo
+	respuestas.RespondSuccess(c, http.StatusOK, usuarioResponse(*usuario))
+}
+
+func usuarioResponse(usuario models.Usuario) dto.UsuarioResponse {
+	return dto.UsuarioResponse{
+		ID:            usuario.ID,
+		PersonaID:     usuario.PersonaID,
+		Rol:           usuario.Rol,
+		Correo:        usuario.Correo,
+		Activo:        usuario.Activo,
+		SegundoFactor: usuario.TOTPActivo,
+		CreadoEn:      usuario.CreadoEn,
+	}
+}
 
 // Autenticar un usuario y devolver token JWT
 func Login(c *gin.Context) {
@@ -268,8 +606,31 @@ func Login(c *gin.Context) {
 		return
 	}
 
+	if !usuario.Activo {
+		respuestas.RespondError(c, http.StatusForbidden, "La cuenta está deshabilitada")
+		return
+	}
+
+	// Segundo factor: si está activo (u obligatorio para el rol) se emite un desafío
+	// pendiente y el JWT solo se entrega tras VerificarSegundoFactor
+	if usuario.TOTPActivo || clave.SegundoFactorObligatorio(usuario.Rol) {
+		desafio, err := crearDesafioLogin(usuario.ID)
+		if err != nil {
+			respuestas.RespondError(c, http.StatusInternalServerError, "Error al crear desafío: "+err.Error())
+			return
+		}
+
+		respuestas.RespondSuccess(c, http.StatusOK, gin.H{
+			"segundo_factor_requerido": true,
+			"enrolamiento_requerido":   !usuario.TOTPActivo,
+			"desafio":                  desafio,
+			"expira_en":                time.Now().Add(duracionDesafioLogin),
+		})
+		return
+	}
+
 	// Generar JWT
-	token, err := clave.GenerateJWT(usuario.ID, usuario.Rol)
+	token, err := clave.GenerateJWT(usuario.ID, usuario.Rol, usuario.VersionSesion)
 	if err != nil {
 		respuestas.RespondError(c, http.StatusInternalServerError, "Error al generar token")
 		return
@@ -302,3 +663,41 @@ func GetCurrentUser(c *gin.Context) {
 	usuario.Contrasena = ""
 	respuestas.RespondSuccess(c, http.StatusOK, usuario)
 }
+
+// ID del usuario autenticado (el middleware guarda el claim "sub" tal como viene del JWT)
+func usuarioActualID(c *gin.Context) (uint, bool) {
+	valor, exists := c.Get("userID")
+	if !exists {
+		return 0, false
+	}
+
+	switch id := valor.(type) {
+	case float64:
+		return uint(id), true
+	case uint:
+		return id, true
+	case int:
+		return uint(id), true
+	}
+	return 0, false
+}
+
+// Carga el usuario autenticado; responde el error y devuelve false si no existe
+func cargarUsuarioActual(c *gin.Context) (*models.Usuario, bool) {
+	userID, ok := usuarioActualID(c)
+	if !ok {
+		respuestas.RespondError(c, http.StatusUnauthorized, "No se pudo identificar al usuario")
+		return nil, false
+	}
+
+	usuario, err := repositories.ObtenerUsuarioPorID(userID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			respuestas.RespondError(c, http.StatusNotFound, "Usuario no encontrado")
+		} else {
+			respuestas.RespondError(c, http.StatusInternalServerError, err.Error())
+		}
+		return nil, false
+	}
+	return usuario, true
+}
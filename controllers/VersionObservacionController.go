@@ -0,0 +1,287 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Ilimm9/CMedicas/Respuestas"
+	"github.com/Ilimm9/CMedicas/clave"
+	"github.com/Ilimm9/CMedicas/initializers"
+	"github.com/Ilimm9/CMedicas/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Línea de un diff: "=" igual, "+" agregada, "-" eliminada
+type LineaDiff struct {
+	Op    string `json:"op"`
+	Texto string `json:"texto"`
+}
+
+type CambioSignoVital struct {
+	Antes   *float64 `json:"antes"`
+	Despues *float64 `json:"despues"`
+}
+
+func leerVersionObservacion(v models.ObservacionVersion) (models.ContenidoObservacion, error) {
+	var contenido models.ContenidoObservacion
+	err := json.Unmarshal([]byte(v.Contenido), &contenido)
+	return contenido, err
+}
+
+// La firma de la observación corresponde a la versión guardada con ese número
+func firmaObservacionValida(observacion models.Observacion, versiones []models.ObservacionVersion) bool {
+	if observacion.FirmadaEn == nil || observacion.FirmadaPor == nil {
+		return false
+	}
+	for _, v := range versiones {
+		if v.Version != observacion.Version {
+			continue
+		}
+		contenido, err := leerVersionObservacion(v)
+		if err != nil {
+			return false
+		}
+		firmante, _, err := clave.VerificarFirmaDocumento(observacion.Firma, "observacion",
+			contenidoFirmaObservacion(observacion.ID, v.Version, contenido, *observacion.FirmadaEn))
+		return err == nil && firmante == *observacion.FirmadaPor
+	}
+	return false
+}
+
+// Historial de versiones de una observación, de la más reciente a la primera,
+// con quién hizo cada cambio y si la firma sigue siendo válida
+func GetVersionesObservacion(c *gin.Context) {
+	observacion, ok := observacionVisible(c)
+	if !ok {
+		return
+	}
+
+	var versiones []models.ObservacionVersion
+	if err := initializers.GetDB().
+		Where("observacion_id = ?", observacion.ID).
+		Order("version DESC").
+		Find(&versiones).Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al obtener versiones: "+err.Error())
+		return
+	}
+
+	historial := make([]gin.H, 0, len(versiones))
+	for _, v := range versiones {
+		contenido, err := leerVersionObservacion(v)
+		if err != nil {
+			respuestas.RespondError(c, http.StatusInternalServerError, "Versión ilegible: "+err.Error())
+			return
+		}
+		historial = append(historial, gin.H{
+			"version":     v.Version,
+			"editada_por": v.EditadaPor,
+			"creada_en":   v.CreadaEn,
+			"firmada":     observacion.FirmadaEn != nil && v.Version == observacion.Version,
+			"contenido":   contenido,
+		})
+	}
+
+	respuestas.RespondSuccess(c, http.StatusOK, gin.H{
+		"observacion_id":   observacion.ID,
+		"version_actual":   observacion.Version,
+		"firmada_en":       observacion.FirmadaEn,
+		"firmada_por":      observacion.FirmadaPor,
+		"firma_valida":     firmaObservacionValida(observacion, versiones),
+		"anulada_en":       observacion.AnuladaEn,
+		"motivo_anulacion": observacion.MotivoAnulacion,
+		"versiones":        historial,
+	})
+}
+
+// Diferencias entre dos versiones (?de=, ?a=; por omisión la actual contra la
+// anterior): texto por líneas, signos vitales y diagnósticos agregados o quitados
+func GetDiffVersionesObservacion(c *gin.Context) {
+	observacion, ok := observacionVisible(c)
+	if !ok {
+		return
+	}
+
+	a := observacion.Version
+	if v := c.Query("a"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			respuestas.RespondError(c, http.StatusBadRequest, "Versión inválida en 'a'")
+			return
+		}
+		a = n
+	}
+	de := a - 1
+	if v := c.Query("de"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			respuestas.RespondError(c, http.StatusBadRequest, "Versión inválida en 'de'")
+			return
+		}
+		de = n
+	}
+
+	var versiones []models.ObservacionVersion
+	if err := initializers.GetDB().
+		Where("observacion_id = ? AND version IN ?", observacion.ID, []int{de, a}).
+		Find(&versiones).Error; err != nil {
+		respuestas.RespondError(c, http.StatusInternalServerError, "Error al obtener versiones: "+err.Error())
+		return
+	}
+
+	contenidos := map[int]models.ContenidoObservacion{}
+	for _, v := range versiones {
+		contenido, err := leerVersionObservacion(v)
+		if err != nil {
+			respuestas.RespondError(c, http.StatusInternalServerError, "Versión ilegible: "+err.Error())
+			return
+		}
+		contenidos[v.Version] = contenido
+	}
+	antes, okAntes := contenidos[de]
+	despues, okDespues := contenidos[a]
+	if !okAntes || !okDespues {
+		respuestas.RespondError(c, http.StatusNotFound, fmt.Sprintf("No existen las versiones %d y %d", de, a))
+		return
+	}
+
+	respuestas.RespondSuccess(c, http.StatusOK, gin.H{
+		"de":             de,
+		"a":              a,
+		"texto":          diffTextosObservacion(antes, despues),
+		"signos_vitales": diffSignosVitales(antes.SignosVitales, despues.SignosVitales),
+		"diagnosticos":   diffDiagnosticos(antes.Diagnosticos, despues.Diagnosticos),
+	})
+}
+
+// Solo las secciones que cambiaron
+func diffTextosObservacion(antes, despues models.ContenidoObservacion) map[string][]LineaDiff {
+	secciones := []struct {
+		nombre         string
+		antes, despues string
+	}{
+		{"motivo_consulta", antes.MotivoConsulta, despues.MotivoConsulta},
+		{"observaciones", antes.Observaciones, despues.Observaciones},
+		{"exploracion_fisica", antes.ExploracionFisica, despues.ExploracionFisica},
+		{"evaluacion", antes.Evaluacion, despues.Evaluacion},
+		{"diagnostico", antes.Diagnostico, despues.Diagnostico},
+		{"plan", antes.Plan, despues.Plan},
+	}
+
+	cambios := map[string][]LineaDiff{}
+	for _, s := range secciones {
+		if s.antes != s.despues {
+			cambios[s.nombre] = diffLineas(s.antes, s.despues)
+		}
+	}
+	return cambios
+}
+
+// Diff por líneas con la subsecuencia común más larga; las notas clínicas son
+// cortas, así que la tabla completa no pesa
+func diffLineas(antes, despues string) []LineaDiff {
+	var x, y []string
+	if antes != "" {
+		x = strings.Split(antes, "\n")
+	}
+	if despues != "" {
+		y = strings.Split(despues, "\n")
+	}
+
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	lineas := []LineaDiff{}
+	i, j := 0, 0
+	for i < len(x) && j < len(y) {
+		switch {
+		case x[i] == y[j]:
+			lineas = append(lineas, LineaDiff{"=", x[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			lineas = append(lineas, LineaDiff{"-", x[i]})
+			i++
+		default:
+			lineas = append(lineas, LineaDiff{"+", y[j]})
+			j++
+		}
+	}
+	for ; i < len(x); i++ {
+		lineas = append(lineas, LineaDiff{"-", x[i]})
+	}
+	for ; j < len(y); j++ {
+		lineas = append(lineas, LineaDiff{"+", y[j]})
+	}
+	return lineas
+}
+
+func diffSignosVitales(antes, despues models.SignosVitales) map[string]CambioSignoVital {
+	entero := func(v *int) *float64 {
+		if v == nil {
+			return nil
+		}
+		f := float64(*v)
+		return &f
+	}
+	signos := []struct {
+		nombre         string
+		antes, despues *float64
+	}{
+		{"presion_sistolica", entero(antes.PresionSistolica), entero(despues.PresionSistolica)},
+		{"presion_diastolica", entero(antes.PresionDiastolica), entero(despues.PresionDiastolica)},
+		{"frecuencia_cardiaca", entero(antes.FrecuenciaCardiaca), entero(despues.FrecuenciaCardiaca)},
+		{"temperatura", antes.Temperatura, despues.Temperatura},
+		{"peso", antes.Peso, despues.Peso},
+		{"talla", antes.Talla, despues.Talla},
+		{"imc", antes.IMC, despues.IMC},
+	}
+
+	cambios := map[string]CambioSignoVital{}
+	for _, s := range signos {
+		igual := (s.antes == nil && s.despues == nil) ||
+			(s.antes != nil && s.despues != nil && *s.antes == *s.despues)
+		if !igual {
+			cambios[s.nombre] = CambioSignoVital{s.antes, s.despues}
+		}
+	}
+	return cambios
+}
+
+// Diagnósticos agregados y quitados; un cambio de tipo o nota cuenta como ambos
+func diffDiagnosticos(antes, despues []models.DiagnosticoVersionado) gin.H {
+	agregados := []models.DiagnosticoVersionado{}
+	quitados := []models.DiagnosticoVersionado{}
+
+	previos := map[models.DiagnosticoVersionado]bool{}
+	for _, d := range antes {
+		previos[d] = true
+	}
+	actuales := map[models.DiagnosticoVersionado]bool{}
+	for _, d := range despues {
+		actuales[d] = true
+		if !previos[d] {
+			agregados = append(agregados, d)
+		}
+	}
+	for _, d := range antes {
+		if !actuales[d] {
+			quitados = append(quitados, d)
+		}
+	}
+	return gin.H{"agregados": agregados, "quitados": quitados}
+}
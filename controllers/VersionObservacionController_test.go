@@ -0,0 +1,31 @@
+package controllers
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffLineas(t *testing.T) {
+	casos := []struct {
+		nombre  string
+		antes   string
+		despues string
+		lineas  []LineaDiff
+	}{
+		{"sin cambios", "a\nb", "a\nb", []LineaDiff{{"=", "a"}, {"=", "b"}}},
+		{"ambos vacíos", "", "", []LineaDiff{}},
+		{"texto nuevo", "", "a\nb", []LineaDiff{{"+", "a"}, {"+", "b"}}},
+		{"texto borrado", "a", "", []LineaDiff{{"-", "a"}}},
+		{"línea agregada al final", "a\nb", "a\nb\nc", []LineaDiff{{"=", "a"}, {"=", "b"}, {"+", "c"}}},
+		{"línea quitada en medio", "a\nb\nc", "a\nc", []LineaDiff{{"=", "a"}, {"-", "b"}, {"=", "c"}}},
+		{"línea reemplazada", "a\nb\nc", "a\nx\nc", []LineaDiff{{"=", "a"}, {"-", "b"}, {"+", "x"}, {"=", "c"}}},
+		{"líneas reordenadas", "a\nb", "b\na", []LineaDiff{{"-", "a"}, {"=", "b"}, {"+", "a"}}},
+	}
+	for _, c := range casos {
+		t.Run(c.nombre, func(t *testing.T) {
+			if lineas := diffLineas(c.antes, c.despues); !reflect.DeepEqual(lineas, c.lineas) {
+				t.Errorf("diffLineas = %v, se esperaba %v", lineas, c.lineas)
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package documentos
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	_ "image/png" // formatos que reconoce image.Decode
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// Límite de píxeles para decodificar: una imagen pequeña en bytes puede
+// declarar dimensiones enormes y agotar la memoria
+const maxPixelesImagen = 50_000_000
+
+// Miniatura JPEG que cabe en un cuadro de lado×lado, conservando la
+// proporción. Las transparencias quedan sobre fondo blanco.
+func MiniaturaJPEG(contenido []byte, lado int) ([]byte, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(contenido))
+	if err != nil {
+		return nil, err
+	}
+	if config.Width <= 0 || config.Height <= 0 || config.Width*config.Height > maxPixelesImagen {
+		return nil, errors.New("dimensiones de imagen no admitidas")
+	}
+
+	original, _, err := image.Decode(bytes.NewReader(contenido))
+	if err != nil {
+		return nil, err
+	}
+
+	ancho, alto := config.Width, config.Height
+	if ancho > lado || alto > lado {
+		if ancho >= alto {
+			alto = max(1, alto*lado/ancho)
+			ancho = lado
+		} else {
+			ancho = max(1, ancho*lado/alto)
+			alto = lado
+		}
+	}
+
+	miniatura := image.NewRGBA(image.Rect(0, 0, ancho, alto))
+	draw.Draw(miniatura, miniatura.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.CatmullRom.Scale(miniatura, miniatura.Bounds(), original, original.Bounds(), draw.Over, nil)
+
+	var b bytes.Buffer
+	if err := jpeg.Encode(&b, miniatura, &jpeg.Options{Quality: 80}); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
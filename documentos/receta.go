@@ -0,0 +1,144 @@
+package documentos
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Ilimm9/CMedicas/models"
+
+	"github.com/go-pdf/fpdf"
+)
+
+// Página de APP_URL donde una farmacia verifica el código de la receta
+func EnlaceVerificacionReceta(codigo string) string {
+	return fmt.Sprintf("%s/verificar-receta?codigo=%s", os.Getenv("APP_URL"), url.QueryEscape(codigo))
+}
+
+// Genera el PDF de una receta. La receta debe traer precargados
+// Medico.Usuario.Persona y Medicamentos; paciente es la persona atendida.
+func RecetaPDF(receta models.Receta, paciente models.Persona, zona *time.Location) ([]byte, error) {
+	pdf := fpdf.New("P", "mm", "Letter", "")
+	// Las fuentes base no son UTF-8: se traduce a cp1252 (acentos y ñ)
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+	pdf.SetMargins(20, 20, 20)
+	pdf.SetAutoPageBreak(true, 30)
+
+	codigo := receta.CodigoVerificacion
+	pdf.SetFooterFunc(func() {
+		pdf.SetY(-25)
+		pdf.SetFont("Helvetica", "", 8)
+		pdf.CellFormat(0, 4, tr("Código de verificación: "+codigo), "", 1, "C", false, 0, "")
+		pdf.CellFormat(0, 4, tr(EnlaceVerificacionReceta(codigo)), "", 1, "C", false, 0, "")
+		pdf.CellFormat(0, 4, fmt.Sprintf("%d/{nb}", pdf.PageNo()), "", 0, "C", false, 0, "")
+	})
+	pdf.AliasNbPages("")
+	pdf.AddPage()
+
+	// Encabezado: clínica y médico
+	clinica := os.Getenv("CLINICA_NOMBRE")
+	if clinica == "" {
+		clinica = "CMedicas"
+	}
+	pdf.SetFont("Helvetica", "B", 16)
+	pdf.CellFormat(0, 8, tr(clinica), "", 1, "L", false, 0, "")
+	pdf.SetFont("Helvetica", "", 9)
+	if direccion := os.Getenv("CLINICA_DIRECCION"); direccion != "" {
+		pdf.MultiCell(0, 4, tr(direccion), "", "L", false)
+	}
+	pdf.Ln(3)
+
+	medico := receta.Medico.Usuario.Persona
+	pdf.SetFont("Helvetica", "B", 12)
+	pdf.CellFormat(0, 6, tr(fmt.Sprintf("Dr(a). %s %s %s", medico.Nombre, medico.ApellidoPaterno, medico.ApellidoMaterno)), "", 1, "L", false, 0, "")
+	pdf.SetFont("Helvetica", "", 10)
+	pdf.CellFormat(0, 5, tr(receta.Medico.Especialidad), "", 1, "L", false, 0, "")
+	if receta.Medico.CedulaProfesional != "" {
+		pdf.CellFormat(0, 5, tr("Cédula profesional: "+receta.Medico.CedulaProfesional), "", 1, "L", false, 0, "")
+	}
+	pdf.Ln(2)
+	pdf.Line(20, pdf.GetY(), 196, pdf.GetY())
+	pdf.Ln(3)
+
+	// Paciente y fecha
+	emitida := receta.EmitidaEn.In(zona)
+	pdf.SetFont("Helvetica", "", 10)
+	pdf.CellFormat(110, 5, tr(fmt.Sprintf("Paciente: %s %s %s", paciente.Nombre, paciente.ApellidoPaterno, paciente.ApellidoMaterno)), "", 0, "L", false, 0, "")
+	pdf.CellFormat(0, 5, tr("Fecha: "+emitida.Format("02/01/2006")), "", 1, "R", false, 0, "")
+	if edad := edadEn(paciente.FechaNacimiento, emitida); edad >= 0 {
+		pdf.CellFormat(0, 5, tr(fmt.Sprintf("Edad: %d años", edad)), "", 1, "L", false, 0, "")
+	}
+	pdf.Ln(4)
+
+	if receta.Estado == "anulada" {
+		pdf.SetFont("Helvetica", "B", 14)
+		pdf.SetTextColor(200, 0, 0)
+		pdf.CellFormat(0, 8, tr("RECETA ANULADA"), "1", 1, "C", false, 0, "")
+		pdf.SetTextColor(0, 0, 0)
+		pdf.Ln(3)
+	}
+
+	// Medicamentos
+	pdf.SetFont("Helvetica", "B", 12)
+	pdf.CellFormat(0, 6, "Rx", "", 1, "L", false, 0, "")
+	for i, m := range receta.Medicamentos {
+		nombre := m.Nombre
+		if m.Presentacion != "" {
+			nombre += " - " + m.Presentacion
+		}
+		pdf.SetFont("Helvetica", "B", 10)
+		pdf.MultiCell(0, 5, tr(fmt.Sprintf("%d. %s", i+1, nombre)), "", "L", false)
+
+		pdf.SetFont("Helvetica", "", 10)
+		dias := "días"
+		if m.DuracionDias == 1 {
+			dias = "día"
+		}
+		detalle := fmt.Sprintf("%s, vía %s, %s durante %d %s.", m.Dosis, m.Via, m.Frecuencia, m.DuracionDias, dias)
+		if m.Cantidad != "" {
+			detalle += " Surtir: " + m.Cantidad + "."
+		}
+		if m.Indicaciones != "" {
+			detalle += " " + m.Indicaciones
+		}
+		pdf.SetX(25)
+		pdf.MultiCell(0, 5, tr(detalle), "", "L", false)
+		pdf.Ln(2)
+	}
+
+	if strings.TrimSpace(receta.Indicaciones) != "" {
+		pdf.Ln(2)
+		pdf.SetFont("Helvetica", "B", 10)
+		pdf.CellFormat(0, 5, "Indicaciones", "", 1, "L", false, 0, "")
+		pdf.SetFont("Helvetica", "", 10)
+		pdf.MultiCell(0, 5, tr(receta.Indicaciones), "", "L", false)
+	}
+
+	// Firma
+	pdf.Ln(20)
+	pdf.Line(118, pdf.GetY(), 196, pdf.GetY())
+	pdf.SetX(118)
+	pdf.SetFont("Helvetica", "", 9)
+	pdf.CellFormat(78, 5, tr("Firma del médico"), "", 1, "C", false, 0, "")
+
+	var buf bytes.Buffer
+	if err := pdf.Output(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Años cumplidos a una fecha; -1 si no hay fecha de nacimiento
+func edadEn(nacimiento, fecha time.Time) int {
+	if nacimiento.IsZero() {
+		return -1
+	}
+	edad := fecha.Year() - nacimiento.Year()
+	if fecha.Month() < nacimiento.Month() || (fecha.Month() == nacimiento.Month() && fecha.Day() < nacimiento.Day()) {
+		edad--
+	}
+	return edad
+}
@@ -1,15 +1,32 @@
 package dto
 
+import "time"
+
+// Alta de usuario por un administrador: no lleva contraseña, se envía una invitación
 type UsuarioInput struct {
-	PersonaID  uint   `json:"persona_id" binding:"required"`
-	Rol        string `json:"rol" binding:"required,oneof=paciente medico administrador"`
-	Correo     string `json:"correo" binding:"required,email"`
-	Contrasena string `json:"contrasena" binding:"required,min=8"`
+	PersonaID    uint          `json:"persona_id" binding:"required_without=Persona"`
+	Persona      *PersonaInput `json:"persona" binding:"omitempty"`
+	Rol          string        `json:"rol" binding:"required,oneof=paciente medico administrador"`
+	Correo       string        `json:"correo" binding:"required,email"`
+	Especialidad string        `json:"especialidad" binding:"required_if=Rol medico,max=100"`
 }
 
-type UsuarioResponse struct {
-	ID        uint   `json:"id"`
-	Correo    string `json:"correo"`
-	Rol       string `json:"rol"`
+type UsuarioUpdateInput struct {
+	Correo    string `json:"correo" binding:"omitempty,email"`
 	PersonaID uint   `json:"persona_id"`
 }
+
+type CambioRolInput struct {
+	Rol          string `json:"rol" binding:"required,oneof=paciente medico administrador"`
+	Especialidad string `json:"especialidad" binding:"required_if=Rol medico,max=100"`
+}
+
+type UsuarioResponse struct {
+	ID            uint      `json:"id"`
+	Correo        string    `json:"correo"`
+	Rol           string    `json:"rol"`
+	PersonaID     uint      `json:"persona_id"`
+	Activo        bool      `json:"activo"`
+	SegundoFactor bool      `json:"segundo_factor"`
+	CreadoEn      time.Time `json:"creado_en"`
+}
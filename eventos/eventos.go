@@ -0,0 +1,96 @@
+package eventos
+
+import (
+	"github.com/Ilimm9/CMedicas/models"
+
+	"gorm.io/gorm"
+)
+
+// Eventos del ciclo de vida de una cita
+type Tipo string
+
+const (
+	CitaCreada           Tipo = "cita_creada"
+	CitaReprogramada     Tipo = "cita_reprogramada"
+	CitaCancelada        Tipo = "cita_cancelada"
+	CitaCompletada       Tipo = "cita_completada"
+	CitaMedicoReasignado Tipo = "cita_medico_reasignado"
+	CitaConfirmada       Tipo = "cita_confirmada" // El paciente confirmó su asistencia
+)
+
+type Evento struct {
+	Tipo     Tipo
+	Cita     models.Cita  // Estado de la cita después del cambio
+	Anterior *models.Cita // Estado previo (reprogramación, reasignación)
+	ActorID  uint         // Usuario que originó el cambio (0 si fue el sistema)
+}
+
+// Un manejador corre dentro de la transacción que origina el evento; si devuelve
+// error, quien emite debe revertir la transacción completa
+type Manejador func(tx *gorm.DB, ev Evento) error
+
+var manejadores = map[Tipo][]Manejador{}
+
+// Registra un manejador para uno o varios tipos de evento. Se llama al arrancar,
+// antes de atender peticiones.
+func Suscribir(m Manejador, tipos ...Tipo) {
+	for _, tipo := range tipos {
+		manejadores[tipo] = append(manejadores[tipo], m)
+	}
+}
+
+// Emite un evento dentro de la transacción tx
+func Emitir(tx *gorm.DB, ev Evento) error {
+	for _, m := range manejadores[ev.Tipo] {
+		if err := m(tx, ev); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Un observador se entera de un evento después de que la transacción que lo
+// originó se confirmó (para efectos fuera de la base: tiempo real, métricas)
+type Observador func(ev Evento)
+
+var observadores = map[Tipo][]Observador{}
+
+// Registra un observador para uno o varios tipos de evento. Se llama al arrancar.
+func Observar(o Observador, tipos ...Tipo) {
+	for _, tipo := range tipos {
+		observadores[tipo] = append(observadores[tipo], o)
+	}
+}
+
+// Avisa a los observadores; quien emitió los eventos lo llama tras el Commit
+func Confirmados(evs ...Evento) {
+	for _, ev := range evs {
+		for _, o := range observadores[ev.Tipo] {
+			o(ev)
+		}
+	}
+}
+
+// Deduce los eventos que produce un cambio sobre una cita existente
+func CambiosCita(anterior, nueva models.Cita, actorID uint) []Evento {
+	var evs []Evento
+	previo := anterior
+
+	if nueva.Estado != anterior.Estado {
+		switch nueva.Estado {
+		case "cancelada":
+			evs = append(evs, Evento{Tipo: CitaCancelada, Cita: nueva, Anterior: &previo, ActorID: actorID})
+			return evs // una cita cancelada no se notifica además como reprogramada
+		case "completada":
+			evs = append(evs, Evento{Tipo: CitaCompletada, Cita: nueva, Anterior: &previo, ActorID: actorID})
+			return evs
+		}
+	}
+	if nueva.MedicoID != anterior.MedicoID {
+		evs = append(evs, Evento{Tipo: CitaMedicoReasignado, Cita: nueva, Anterior: &previo, ActorID: actorID})
+	}
+	if !nueva.FechaCita.Equal(anterior.FechaCita) {
+		evs = append(evs, Evento{Tipo: CitaReprogramada, Cita: nueva, Anterior: &previo, ActorID: actorID})
+	}
+	return evs
+}
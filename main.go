@@ -1,9 +1,14 @@
 package main
 
 import (
+	"context"
+
 	"github.com/Ilimm9/CMedicas/initializers"
+	"github.com/Ilimm9/CMedicas/mensajeria"
 	"github.com/Ilimm9/CMedicas/migrate"
+	"github.com/Ilimm9/CMedicas/referencias"
 	"github.com/Ilimm9/CMedicas/routes"
+	"github.com/Ilimm9/CMedicas/tiemporeal"
 
 	"github.com/gin-gonic/gin"
 )
@@ -22,5 +27,17 @@ func main() {
 	// Rutas
 	routes.AdminRutas(r)
 
+	// Notificaciones automáticas por eventos de cita y su entrega en segundo plano
+	mensajeria.RegistrarManejadores()
+	mensajeria.IniciarTrabajadores(context.Background())
+	mensajeria.IniciarRecordatorios(context.Background())
+
+	// Estado de las referencias según la cita agendada y su vencimiento
+	referencias.RegistrarManejadores()
+	referencias.IniciarExpiracion(context.Background(), initializers.GetDB())
+
+	// Cambios de cita en tiempo real para los clientes conectados
+	tiemporeal.RegistrarObservadores()
+
 	r.Run()
 }
@@ -0,0 +1,189 @@
+package mensajeria
+
+import (
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Ilimm9/CMedicas/calendario"
+	"github.com/Ilimm9/CMedicas/clave"
+	"github.com/Ilimm9/CMedicas/eventos"
+	"github.com/Ilimm9/CMedicas/models"
+
+	"gorm.io/gorm"
+)
+
+// Método iTIP que corresponde a cada evento de cita (los demás no llevan invitación)
+var metodoCalendario = map[eventos.Tipo]string{
+	eventos.CitaCreada:           calendario.MetodoSolicitud,
+	eventos.CitaReprogramada:     calendario.MetodoSolicitud,
+	eventos.CitaMedicoReasignado: calendario.MetodoSolicitud,
+	eventos.CitaCancelada:        calendario.MetodoCancelar,
+}
+
+// Sube el SEQUENCE de la cita para que el calendario del paciente reemplace la
+// versión anterior del evento. La creación usa la secuencia inicial.
+func avanzarSecuenciaICS(tx *gorm.DB, cita *models.Cita, tipo eventos.Tipo) error {
+	if _, ok := metodoCalendario[tipo]; !ok || tipo == eventos.CitaCreada {
+		return nil
+	}
+	if err := tx.Model(cita).UpdateColumn("secuencia_ics", gorm.Expr("secuencia_ics + 1")).Error; err != nil {
+		return err
+	}
+	return tx.Model(&models.Cita{}).Select("secuencia_ics").Where("id = ?", cita.ID).Scan(&cita.SecuenciaICS).Error
+}
+
+// Invitación iCalendar de la cita para el paciente, en su idioma. El motivo
+// solo se incluye si el paciente lo autorizó en sus preferencias.
+func invitacionCita(db *gorm.DB, cita models.Cita, metodo string, idioma string) (string, error) {
+	var paciente models.Usuario
+	if err := db.Select("id", "correo").First(&paciente, cita.PacienteID).Error; err != nil {
+		return "", err
+	}
+	pref, err := PreferenciasUsuario(cita.PacienteID)
+	if err != nil {
+		return "", err
+	}
+
+	medico := nombreCompleto(cita.Medico.Usuario.Persona)
+	resumen := "Cita con " + medico
+	descripcion := []string{"Médico: " + medico, "Especialidad: " + cita.Medico.Especialidad, "Paciente: " + nombrePaciente(cita)}
+	if idioma == "en" {
+		resumen = "Appointment with " + medico
+		descripcion = []string{"Doctor: " + medico, "Specialty: " + cita.Medico.Especialidad, "Patient: " + nombrePaciente(cita)}
+	}
+	if pref.IncluirMotivoCalendario && cita.Motivo != "" {
+		etiqueta := "Motivo: "
+		if idioma == "en" {
+			etiqueta = "Reason: "
+		}
+		descripcion = append(descripcion, etiqueta+cita.Motivo)
+	}
+
+	nombreOrganizador, organizador := clave.Remitente()
+
+	ics := calendario.Generar(calendario.Evento{
+		UID:               calendario.UIDCita(cita.ID),
+		Secuencia:         cita.SecuenciaICS,
+		Metodo:            metodo,
+		Inicio:            cita.FechaCita,
+		Fin:               cita.FechaCita.Add(calendario.DuracionCita()),
+		Resumen:           resumen,
+		Descripcion:       strings.Join(descripcion, "\n"),
+		Ubicacion:         os.Getenv("CLINICA_DIRECCION"),
+		Organizador:       organizador,
+		NombreOrganizador: nombreOrganizador,
+		Asistente:         paciente.Correo,
+		NombreAsistente:   nombrePaciente(cita),
+	})
+	return string(ics), nil
+}
+
+// Adjunto de correo con la invitación guardada en la notificación
+func adjuntoICS(ics string) clave.Adjunto {
+	metodo := calendario.MetodoSolicitud
+	if strings.Contains(ics, "METHOD:"+calendario.MetodoCancelar) {
+		metodo = calendario.MetodoCancelar
+	}
+	return clave.Adjunto{
+		Nombre:    "cita.ics",
+		Tipo:      "text/calendar; method=" + metodo + "; charset=UTF-8",
+		Contenido: []byte(ics),
+	}
+}
+
+// Citas del feed: desde hace 30 días en adelante. Las canceladas se incluyen
+// como CANCELLED para que el calendario suscrito las quite.
+const historialFeed = 30 * 24 * time.Hour
+
+// Calendario de suscripción de un usuario: las citas asignadas si es médico,
+// las propias (y de sus dependientes) si es paciente
+func FeedCitas(db *gorm.DB, feed models.FeedCalendario) ([]byte, error) {
+	var usuario models.Usuario
+	if err := db.Preload("Medico").First(&usuario, feed.UsuarioID).Error; err != nil {
+		return nil, err
+	}
+	pref, err := PreferenciasUsuario(usuario.ID)
+	if err != nil {
+		return nil, err
+	}
+	zona := zonaUsuario(pref)
+
+	consulta := db.Preload("Medico.Usuario.Persona").Preload("Paciente.Persona").Preload("PersonaPaciente").
+		Where("fecha_cita >= ?", time.Now().Add(-historialFeed)).
+		Order("fecha_cita")
+	esMedico := usuario.Rol == "medico" && usuario.Medico != nil
+	if esMedico {
+		consulta = consulta.Where("medico_id = ?", usuario.Medico.ID)
+	} else {
+		consulta = consulta.Where("paciente_id = ?", usuario.ID)
+	}
+
+	var citas []models.Cita
+	if err := consulta.Find(&citas).Error; err != nil {
+		return nil, err
+	}
+
+	ubicacion := os.Getenv("CLINICA_DIRECCION")
+	eventosFeed := make([]calendario.Evento, 0, len(citas))
+	for _, cita := range citas {
+		ev := calendario.Evento{
+			UID:       calendario.UIDCita(cita.ID),
+			Secuencia: cita.SecuenciaICS,
+			Metodo:    calendario.MetodoSolicitud,
+			Inicio:    cita.FechaCita,
+			Fin:       cita.FechaCita.Add(calendario.DuracionCita()),
+			Ubicacion: ubicacion,
+		}
+		if cita.Estado == "cancelada" {
+			ev.Metodo = calendario.MetodoCancelar
+		}
+		ev.Resumen, ev.Descripcion = textoEventoFeed(cita, esMedico, feed.Privacidad, usuario.Idioma)
+		eventosFeed = append(eventosFeed, ev)
+	}
+
+	nombre := feed.Nombre
+	if nombre == "" {
+		nombre = "Citas"
+	}
+	return calendario.GenerarFeed(nombre, zona, eventosFeed), nil
+}
+
+// Resumen y descripción del evento según la privacidad del feed. El médico ve
+// al paciente; el paciente ve al médico.
+func textoEventoFeed(cita models.Cita, esMedico bool, privacidad, idioma string) (string, string) {
+	etiquetaCita, etiquetaMotivo := "Cita", "Motivo: "
+	if idioma == "en" {
+		etiquetaCita, etiquetaMotivo = "Appointment", "Reason: "
+	}
+
+	if privacidad == "ocupado" {
+		return etiquetaCita, ""
+	}
+
+	otro := nombreCompleto(cita.Medico.Usuario.Persona)
+	if esMedico {
+		otro = nombrePaciente(cita)
+		if privacidad != "completa" {
+			otro = iniciales(otro)
+		}
+	}
+	resumen := etiquetaCita + " - " + otro
+	if !esMedico && cita.Medico.Especialidad != "" {
+		resumen += " (" + cita.Medico.Especialidad + ")"
+	}
+
+	if privacidad != "completa" || cita.Motivo == "" {
+		return resumen, ""
+	}
+	return resumen, etiquetaMotivo + cita.Motivo
+}
+
+// "Ana López" -> "A. L."
+func iniciales(nombre string) string {
+	var partes []string
+	for _, palabra := range strings.Fields(nombre) {
+		partes = append(partes, string([]rune(palabra)[:1])+".")
+	}
+	return strings.Join(partes, " ")
+}
@@ -0,0 +1,292 @@
+package mensajeria
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Ilimm9/CMedicas/clave"
+	"github.com/Ilimm9/CMedicas/initializers"
+	"github.com/Ilimm9/CMedicas/models"
+
+	webpush "github.com/SherClockHolmes/webpush-go"
+)
+
+// Mensaje listo para entregar por cualquier canal
+type Mensaje struct {
+	Destinatario models.Usuario // con Persona cargada (teléfono)
+	Asunto       string
+	Texto        string
+	HTML         string // Solo lo usan los canales que lo admiten (correo)
+	EnlaceBaja   string // Enlace para dejar de recibir este tipo de mensaje (correo)
+	Adjuntos     []clave.Adjunto
+}
+
+// Canal de entrega de notificaciones
+type Canal interface {
+	Nombre() string
+	Enviar(ctx context.Context, m Mensaje) error
+}
+
+// Error que indica que el canal no aplica al destinatario (sin teléfono,
+// sin suscripción push...). No cuenta como falla del proveedor.
+var ErrCanalNoAplica = errors.New("canal no disponible para el destinatario")
+
+// ---------- Correo (SMTP) ----------
+
+type CanalCorreo struct{}
+
+func (CanalCorreo) Nombre() string { return "correo" }
+
+func (CanalCorreo) Enviar(ctx context.Context, m Mensaje) error {
+	if m.Destinatario.Correo == "" {
+		return ErrCanalNoAplica
+	}
+
+	correo := clave.Correo{
+		Destinatario: m.Destinatario.Correo,
+		Asunto:       m.Asunto,
+		Texto:        m.Texto,
+		HTML:         m.HTML,
+		Adjuntos:     m.Adjuntos,
+	}
+	if m.EnlaceBaja != "" {
+		correo.Texto += "\n\nPara dejar de recibir estos avisos: " + m.EnlaceBaja
+		if correo.HTML != "" {
+			pie := `<p style="font-size:12px;color:#888"><a href="` + html.EscapeString(m.EnlaceBaja) + `">Dejar de recibir estos avisos</a></p>`
+			if i := strings.LastIndex(correo.HTML, "</body>"); i >= 0 {
+				correo.HTML = correo.HTML[:i] + pie + correo.HTML[i:]
+			} else {
+				correo.HTML += pie
+			}
+		}
+		correo.Cabeceras = map[string]string{"List-Unsubscribe": "<" + m.EnlaceBaja + ">"}
+	}
+	return clave.EnviarCorreoContexto(ctx, correo)
+}
+
+// ---------- SMS / WhatsApp (pasarela HTTP genérica) ----------
+
+// Envía POST {"to": ..., "message": ...} a la URL de la pasarela con el token como
+// Bearer. Se usa para SMS (SMS_GATEWAY_*) y WhatsApp (WHATSAPP_GATEWAY_*).
+type CanalPasarela struct {
+	Canal  string
+	URL    string
+	Token  string
+	Client *http.Client
+}
+
+func (s CanalPasarela) Nombre() string { return s.Canal }
+
+func (s CanalPasarela) Enviar(ctx context.Context, m Mensaje) error {
+	telefono := m.Destinatario.Persona.Telefono
+	if telefono == "" {
+		return ErrCanalNoAplica
+	}
+
+	cuerpo, err := json.Marshal(map[string]string{"to": telefono, "message": m.Texto})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(cuerpo))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.Token)
+	}
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("pasarela %s: %w", s.Canal, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("pasarela %s respondió %d", s.Canal, resp.StatusCode)
+	}
+	return nil
+}
+
+// ---------- Web Push ----------
+
+// Usa VAPID_PUBLICA, VAPID_PRIVADA y VAPID_CONTACTO (mailto: o URL)
+type CanalPush struct {
+	ClavePublica string
+	ClavePrivada string
+	Contacto     string
+}
+
+func (CanalPush) Nombre() string { return "push" }
+
+func (p CanalPush) Enviar(ctx context.Context, m Mensaje) error {
+	var suscripciones []models.SuscripcionPush
+	if err := initializers.GetDB().Where("usuario_id = ?", m.Destinatario.ID).Find(&suscripciones).Error; err != nil {
+		return err
+	}
+	if len(suscripciones) == 0 {
+		return ErrCanalNoAplica
+	}
+
+	carga, err := json.Marshal(map[string]string{"title": m.Asunto, "body": m.Texto})
+	if err != nil {
+		return err
+	}
+
+	// Basta con que llegue a un dispositivo
+	var errs []error
+	entregado := false
+	for _, s := range suscripciones {
+		resp, err := webpush.SendNotificationWithContext(ctx, carga, &webpush.Subscription{
+			Endpoint: s.Endpoint,
+			Keys:     webpush.Keys{P256dh: s.P256dh, Auth: s.Auth},
+		}, &webpush.Options{
+			Subscriber:      p.Contacto,
+			VAPIDPublicKey:  p.ClavePublica,
+			VAPIDPrivateKey: p.ClavePrivada,
+			TTL:             86400,
+		})
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		resp.Body.Close()
+
+		switch {
+		case resp.StatusCode == http.StatusGone || resp.StatusCode == http.StatusNotFound:
+			// El navegador revocó la suscripción
+			initializers.GetDB().Delete(&s)
+		case resp.StatusCode >= 300:
+			errs = append(errs, fmt.Errorf("servicio push respondió %d", resp.StatusCode))
+		default:
+			entregado = true
+		}
+	}
+
+	if entregado {
+		return nil
+	}
+	if len(errs) == 0 {
+		return ErrCanalNoAplica
+	}
+	return errors.Join(errs...)
+}
+
+// ---------- Registro (desarrollo local) ----------
+
+// Escribe los mensajes en NOTIF_ARCHIVO (una línea JSON por mensaje) o en el log
+type CanalRegistro struct {
+	Archivo string
+	mu      sync.Mutex
+}
+
+func (*CanalRegistro) Nombre() string { return "registro" }
+
+func (r *CanalRegistro) Enviar(ctx context.Context, m Mensaje) error {
+	linea, err := json.Marshal(map[string]interface{}{
+		"fecha":      time.Now(),
+		"usuario_id": m.Destinatario.ID,
+		"correo":     m.Destinatario.Correo,
+		"asunto":     m.Asunto,
+		"texto":      m.Texto,
+	})
+	if err != nil {
+		return err
+	}
+
+	if r.Archivo == "" {
+		log.Println("Notificación:", string(linea))
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	f, err := os.OpenFile(r.Archivo, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Write(append(linea, '\n'))
+	return err
+}
+
+// ---------- Canales disponibles ----------
+
+var (
+	canales     map[string]Canal
+	canalesOnce sync.Once
+)
+
+// Canales configurados en el entorno; los que no tienen configuración se omiten.
+// Con NOTIF_SOLO_REGISTRO=true todo se desvía al canal de registro (no se envía
+// nada real).
+func canalesConfigurados() map[string]Canal {
+	canalesOnce.Do(func() {
+		canales = map[string]Canal{}
+		registro := &CanalRegistro{Archivo: os.Getenv("NOTIF_ARCHIVO")}
+
+		if os.Getenv("NOTIF_SOLO_REGISTRO") == "true" {
+			for _, nombre := range CanalesValidos {
+				canales[nombre] = registro
+			}
+			return
+		}
+
+		if os.Getenv("MAIL_USER") != "" {
+			canales["correo"] = CanalCorreo{}
+		}
+		if url := os.Getenv("SMS_GATEWAY_URL"); url != "" {
+			canales["sms"] = CanalPasarela{Canal: "sms", URL: url, Token: os.Getenv("SMS_GATEWAY_TOKEN"), Client: &http.Client{Timeout: 15 * time.Second}}
+		}
+		if url := os.Getenv("WHATSAPP_GATEWAY_URL"); url != "" {
+			canales["whatsapp"] = CanalPasarela{Canal: "whatsapp", URL: url, Token: os.Getenv("WHATSAPP_GATEWAY_TOKEN"), Client: &http.Client{Timeout: 15 * time.Second}}
+		}
+		if pub, priv := os.Getenv("VAPID_PUBLICA"), os.Getenv("VAPID_PRIVADA"); pub != "" && priv != "" {
+			canales["push"] = CanalPush{ClavePublica: pub, ClavePrivada: priv, Contacto: os.Getenv("VAPID_CONTACTO")}
+		}
+	})
+	return canales
+}
+
+// Nombres de canal que un usuario puede elegir
+var CanalesValidos = []string{"correo", "sms", "whatsapp", "push"}
+
+// Intenta los canales en el orden preferido hasta que uno entregue.
+// Devuelve el canal que lo logró.
+func enviarPorCanales(ctx context.Context, orden []string, m Mensaje) (string, error) {
+	disponibles := canalesConfigurados()
+
+	var errs []error
+	for _, nombre := range orden {
+		canal, ok := disponibles[nombre]
+		if !ok {
+			continue
+		}
+
+		err := canal.Enviar(ctx, m)
+		if err == nil {
+			return nombre, nil
+		}
+		if !errors.Is(err, ErrCanalNoAplica) {
+			errs = append(errs, fmt.Errorf("%s: %w", nombre, err))
+		}
+	}
+
+	if len(errs) == 0 {
+		return "", errors.New("ningún canal configurado puede entregar al destinatario")
+	}
+	return "", errors.Join(errs...)
+}
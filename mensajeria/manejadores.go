@@ -0,0 +1,96 @@
+package mensajeria
+
+import (
+	"github.com/Ilimm9/CMedicas/eventos"
+	"github.com/Ilimm9/CMedicas/models"
+
+	"gorm.io/gorm"
+)
+
+// Suscribe la generación de notificaciones a los eventos de cita
+func RegistrarManejadores() {
+	eventos.Suscribir(notificarEventoCita,
+		eventos.CitaCreada,
+		eventos.CitaReprogramada,
+		eventos.CitaCancelada,
+		eventos.CitaCompletada,
+		eventos.CitaMedicoReasignado,
+	)
+}
+
+// Destinatario de una notificación derivada de un evento; el texto sale de la
+// plantilla del tipo para ese destino, en el idioma del usuario
+type aviso struct {
+	usuarioID uint
+	tipo      string
+	destino   string
+}
+
+func notificarEventoCita(tx *gorm.DB, ev eventos.Evento) error {
+	cita, err := CargarCitaParaPlantilla(tx, ev.Cita.ID)
+	if err != nil {
+		return err
+	}
+	if err := avanzarSecuenciaICS(tx, &cita, ev.Tipo); err != nil {
+		return err
+	}
+
+	var avisos []aviso
+	switch ev.Tipo {
+	case eventos.CitaCreada:
+		avisos = []aviso{{cita.PacienteID, "confirmación", "paciente"}, {cita.Medico.UsuarioID, "confirmación", "medico"}}
+
+	case eventos.CitaReprogramada:
+		avisos = []aviso{{cita.PacienteID, "reprogramación", "paciente"}, {cita.Medico.UsuarioID, "reprogramación", "medico"}}
+
+	case eventos.CitaCancelada:
+		avisos = []aviso{{cita.PacienteID, "cancelación", "paciente"}, {cita.Medico.UsuarioID, "cancelación", "medico"}}
+
+	case eventos.CitaCompletada:
+		avisos = []aviso{{cita.PacienteID, "completada", "paciente"}, {cita.Medico.UsuarioID, "completada", "medico"}}
+
+	case eventos.CitaMedicoReasignado:
+		var anterior models.Medico
+		if err := tx.First(&anterior, ev.Anterior.MedicoID).Error; err != nil {
+			return err
+		}
+		avisos = []aviso{
+			{cita.PacienteID, "reasignación", "paciente"},
+			{cita.Medico.UsuarioID, "reasignación", "medico"},
+			{anterior.UsuarioID, "reasignación", "medico_anterior"},
+		}
+	}
+
+	for _, a := range avisos {
+		notificacion, err := ComponerNotificacion(tx, a.usuarioID, a.tipo, a.destino, cita, ev.Anterior)
+		if err != nil {
+			return err
+		}
+		// El paciente recibe la invitación de calendario para agregar o quitar la cita
+		if metodo, ok := metodoCalendario[ev.Tipo]; ok && a.destino == "paciente" {
+			var usuario models.Usuario
+			if err := tx.Select("id", "idioma").First(&usuario, a.usuarioID).Error; err != nil {
+				return err
+			}
+			if notificacion.AdjuntoICS, err = invitacionCita(tx, cita, metodo, usuario.Idioma); err != nil {
+				return err
+			}
+		}
+		if err := Encolar(tx, &notificacion); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func nombreCompleto(persona models.Persona) string {
+	return persona.Nombre + " " + persona.ApellidoPaterno
+}
+
+// Paciente de registro: el dependiente si la cita es suya, si no el titular
+func nombrePaciente(cita models.Cita) string {
+	if cita.PersonaPaciente != nil {
+		return nombreCompleto(*cita.PersonaPaciente)
+	}
+	return nombreCompleto(cita.Paciente.Persona)
+}
@@ -0,0 +1,38 @@
+package mensajeria
+
+import (
+	"time"
+
+	"github.com/Ilimm9/CMedicas/models"
+
+	"gorm.io/gorm"
+)
+
+// Encola una notificación para entrega asíncrona. Debe llamarse con la misma
+// transacción que registra el cambio que la origina: si la transacción se
+// revierte, la notificación tampoco existe.
+func Encolar(tx *gorm.DB, notificacion *models.Notificacion) error {
+	ahora := time.Now()
+	if notificacion.FechaEnvio.IsZero() {
+		notificacion.FechaEnvio = ahora
+	}
+	notificacion.Estado = "pendiente"
+	notificacion.Intentos = 0
+	if notificacion.ProximoIntento.IsZero() {
+		notificacion.ProximoIntento = ahora
+	}
+	return tx.Create(notificacion).Error
+}
+
+// Vuelve a poner en cola una notificación fallida (reintento manual del administrador)
+func Reintentar(tx *gorm.DB, id uint) (int64, error) {
+	result := tx.Model(&models.Notificacion{}).
+		Where("id = ? AND estado = ?", id, "fallida").
+		Updates(map[string]interface{}{
+			"estado":          "pendiente",
+			"intentos":        0,
+			"proximo_intento": time.Now(),
+			"bloqueada_hasta": nil,
+		})
+	return result.RowsAffected, result.Error
+}
@@ -0,0 +1,285 @@
+package mensajeria
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"net/url"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/Ilimm9/CMedicas/clave"
+	"github.com/Ilimm9/CMedicas/models"
+
+	"gorm.io/gorm"
+)
+
+// Idiomas con plantillas predeterminadas; el primero es el de respaldo
+var Idiomas = []string{"es", "en"}
+
+// Destinatarios posibles de una plantilla
+var Destinos = []string{"paciente", "medico", "medico_anterior"}
+
+// Valores disponibles en las plantillas ({{.Paciente}}, {{.Fecha}}, ...)
+type DatosPlantilla struct {
+	Paciente       string
+	Medico         string
+	Especialidad   string
+	Fecha          string // En la zona horaria de la clínica
+	Hora           string
+	Direccion      string // CLINICA_DIRECCION
+	FechaAnterior  string // Reprogramación
+	HoraAnterior   string
+	MedicoAnterior string // Reasignación
+	// Enlaces firmados para confirmar o cancelar sin iniciar sesión (recordatorio al paciente)
+	EnlaceConfirmar string
+	EnlaceCancelar  string
+}
+
+// Mensaje ya renderizado
+type Contenido struct {
+	Asunto string `json:"asunto"`
+	Texto  string `json:"texto"`
+	HTML   string `json:"html"`
+}
+
+type clavePlantilla struct {
+	tipo, destino, idioma string
+}
+
+// Textos predeterminados. El HTML se genera a partir del texto.
+var plantillasPredeterminadas = map[clavePlantilla][2]string{
+	{"confirmación", "paciente", "es"}:        {"Cita confirmada", "Su cita de {{.Paciente}} con {{.Medico}} ({{.Especialidad}}) quedó agendada para el {{.Fecha}} a las {{.Hora}}.{{if .Direccion}} Dirección: {{.Direccion}}.{{end}}"},
+	{"confirmación", "medico", "es"}:          {"Nueva cita", "Nueva cita con {{.Paciente}} el {{.Fecha}} a las {{.Hora}}."},
+	{"recordatorio", "paciente", "es"}:        {"Recordatorio de cita", "Le recordamos su cita de {{.Paciente}} con {{.Medico}} ({{.Especialidad}}) el {{.Fecha}} a las {{.Hora}}.{{if .Direccion}} Dirección: {{.Direccion}}.{{end}}{{if .EnlaceConfirmar}} Confirme su asistencia: {{.EnlaceConfirmar}} Si no podrá asistir, cancele aquí: {{.EnlaceCancelar}}{{end}}"},
+	{"recordatorio", "medico", "es"}:          {"Recordatorio de cita", "Tiene cita con {{.Paciente}} el {{.Fecha}} a las {{.Hora}}."},
+	{"cancelación", "paciente", "es"}:         {"Cita cancelada", "Su cita ha sido cancelada ({{.Paciente}} con {{.Medico}}, {{.Fecha}} a las {{.Hora}})."},
+	{"cancelación", "medico", "es"}:           {"Cita cancelada", "Se canceló la cita con {{.Paciente}} del {{.Fecha}} a las {{.Hora}}."},
+	{"reprogramación", "paciente", "es"}:      {"Cita reprogramada", "Su cita de {{.Paciente}} con {{.Medico}} se movió del {{.FechaAnterior}} a las {{.HoraAnterior}} al {{.Fecha}} a las {{.Hora}}.{{if .Direccion}} Dirección: {{.Direccion}}.{{end}}"},
+	{"reprogramación", "medico", "es"}:        {"Cita reprogramada", "La cita con {{.Paciente}} se movió del {{.FechaAnterior}} a las {{.HoraAnterior}} al {{.Fecha}} a las {{.Hora}}."},
+	{"completada", "paciente", "es"}:          {"Consulta atendida", "La consulta de {{.Paciente}} con {{.Medico}} del {{.Fecha}} quedó registrada como atendida."},
+	{"completada", "medico", "es"}:            {"Consulta atendida", "La cita con {{.Paciente}} del {{.Fecha}} quedó registrada como atendida."},
+	{"reasignación", "paciente", "es"}:        {"Cambio de médico", "Su cita de {{.Paciente}} del {{.Fecha}} a las {{.Hora}} ahora será atendida por {{.Medico}} ({{.Especialidad}})."},
+	{"reasignación", "medico", "es"}:          {"Cita asignada", "Se le asignó la cita con {{.Paciente}} del {{.Fecha}} a las {{.Hora}}."},
+	{"reasignación", "medico_anterior", "es"}: {"Cita reasignada", "La cita con {{.Paciente}} del {{.Fecha}} a las {{.Hora}} fue reasignada a otro médico."},
+
+	{"confirmación", "paciente", "en"}:        {"Appointment confirmed", "The appointment for {{.Paciente}} with {{.Medico}} ({{.Especialidad}}) is scheduled for {{.Fecha}} at {{.Hora}}.{{if .Direccion}} Address: {{.Direccion}}.{{end}}"},
+	{"confirmación", "medico", "en"}:          {"New appointment", "New appointment with {{.Paciente}} on {{.Fecha}} at {{.Hora}}."},
+	{"recordatorio", "paciente", "en"}:        {"Appointment reminder", "This is a reminder of the appointment for {{.Paciente}} with {{.Medico}} ({{.Especialidad}}) on {{.Fecha}} at {{.Hora}}.{{if .Direccion}} Address: {{.Direccion}}.{{end}}{{if .EnlaceConfirmar}} Please confirm your attendance: {{.EnlaceConfirmar}} If you cannot attend, cancel here: {{.EnlaceCancelar}}{{end}}"},
+	{"recordatorio", "medico", "en"}:          {"Appointment reminder", "You have an appointment with {{.Paciente}} on {{.Fecha}} at {{.Hora}}."},
+	{"cancelación", "paciente", "en"}:         {"Appointment cancelled", "Your appointment has been cancelled ({{.Paciente}} with {{.Medico}}, {{.Fecha}} at {{.Hora}})."},
+	{"cancelación", "medico", "en"}:           {"Appointment cancelled", "The appointment with {{.Paciente}} on {{.Fecha}} at {{.Hora}} was cancelled."},
+	{"reprogramación", "paciente", "en"}:      {"Appointment rescheduled", "The appointment for {{.Paciente}} with {{.Medico}} was moved from {{.FechaAnterior}} at {{.HoraAnterior}} to {{.Fecha}} at {{.Hora}}.{{if .Direccion}} Address: {{.Direccion}}.{{end}}"},
+	{"reprogramación", "medico", "en"}:        {"Appointment rescheduled", "The appointment with {{.Paciente}} was moved from {{.FechaAnterior}} at {{.HoraAnterior}} to {{.Fecha}} at {{.Hora}}."},
+	{"completada", "paciente", "en"}:          {"Visit completed", "The visit of {{.Paciente}} with {{.Medico}} on {{.Fecha}} has been recorded as completed."},
+	{"completada", "medico", "en"}:            {"Visit completed", "The appointment with {{.Paciente}} on {{.Fecha}} has been recorded as completed."},
+	{"reasignación", "paciente", "en"}:        {"Doctor changed", "The appointment for {{.Paciente}} on {{.Fecha}} at {{.Hora}} will now be attended by {{.Medico}} ({{.Especialidad}})."},
+	{"reasignación", "medico", "en"}:          {"Appointment assigned", "You have been assigned the appointment with {{.Paciente}} on {{.Fecha}} at {{.Hora}}."},
+	{"reasignación", "medico_anterior", "en"}: {"Appointment reassigned", "The appointment with {{.Paciente}} on {{.Fecha}} at {{.Hora}} was reassigned to another doctor."},
+}
+
+// Envoltura del HTML generado a partir del texto
+var plantillaBaseHTML = htmltemplate.Must(htmltemplate.New("base").Parse(
+	`<!DOCTYPE html><html lang="{{.Idioma}}"><body style="font-family:sans-serif;color:#222">` +
+		`<h2 style="font-size:18px">{{.Asunto}}</h2><p>{{.Texto}}</p></body></html>`))
+
+func IdiomaValido(idioma string) bool {
+	for _, i := range Idiomas {
+		if i == idioma {
+			return true
+		}
+	}
+	return false
+}
+
+// Plantilla predeterminada, o nil si la combinación no existe en ningún idioma
+func plantillaPredeterminada(tipo, destino, idioma string) *models.PlantillaNotificacion {
+	for _, i := range []string{idioma, Idiomas[0]} {
+		if textos, ok := plantillasPredeterminadas[clavePlantilla{tipo, destino, i}]; ok {
+			return &models.PlantillaNotificacion{Tipo: tipo, Destino: destino, Idioma: i, Asunto: textos[0], Texto: textos[1]}
+		}
+	}
+	return nil
+}
+
+// Plantillas predeterminadas de todos los tipos y destinos en un idioma
+func PlantillasPredeterminadas(idioma string) []models.PlantillaNotificacion {
+	var plantillas []models.PlantillaNotificacion
+	for clave := range plantillasPredeterminadas {
+		if clave.idioma == idioma {
+			plantillas = append(plantillas, *plantillaPredeterminada(clave.tipo, clave.destino, idioma))
+		}
+	}
+	return plantillas
+}
+
+// Plantilla que se usará: la personalizada si existe, si no la predeterminada.
+// Si no hay versión en el idioma pedido se usa la del idioma de respaldo.
+func PlantillaVigente(db *gorm.DB, tipo, destino, idioma string) (*models.PlantillaNotificacion, bool, error) {
+	for _, i := range []string{idioma, Idiomas[0]} {
+		var plantilla models.PlantillaNotificacion
+		if err := db.Where("tipo = ? AND destino = ? AND idioma = ?", tipo, destino, i).Limit(1).Find(&plantilla).Error; err != nil {
+			return nil, false, err
+		}
+		if plantilla.ID != 0 {
+			return &plantilla, true, nil
+		}
+		if p := plantillaPredeterminada(tipo, destino, i); p != nil && p.Idioma == i {
+			return p, false, nil
+		}
+	}
+	return nil, false, fmt.Errorf("no hay plantilla para %s/%s", tipo, destino)
+}
+
+// Renderiza una plantilla. Un campo desconocido ({{.Nombre}}) es un error.
+func RenderizarPlantilla(plantilla models.PlantillaNotificacion, datos DatosPlantilla) (Contenido, error) {
+	var contenido Contenido
+	var err error
+
+	if contenido.Asunto, err = ejecutarTexto("asunto", plantilla.Asunto, datos); err != nil {
+		return contenido, err
+	}
+	if contenido.Texto, err = ejecutarTexto("texto", plantilla.Texto, datos); err != nil {
+		return contenido, err
+	}
+
+	var buf bytes.Buffer
+	if plantilla.HTML != "" {
+		t, err := htmltemplate.New("html").Option("missingkey=error").Parse(plantilla.HTML)
+		if err != nil {
+			return contenido, fmt.Errorf("html: %w", err)
+		}
+		if err := t.Execute(&buf, datos); err != nil {
+			return contenido, fmt.Errorf("html: %w", err)
+		}
+	} else if err := plantillaBaseHTML.Execute(&buf, map[string]string{
+		"Idioma": plantilla.Idioma,
+		"Asunto": contenido.Asunto,
+		"Texto":  contenido.Texto,
+	}); err != nil {
+		return contenido, err
+	}
+	contenido.HTML = buf.String()
+	return contenido, nil
+}
+
+func ejecutarTexto(nombre, fuente string, datos DatosPlantilla) (string, error) {
+	t, err := template.New(nombre).Option("missingkey=error").Parse(fuente)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", nombre, err)
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, datos); err != nil {
+		return "", fmt.Errorf("%s: %w", nombre, err)
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
+
+// Fecha y hora de la cita en la zona de la clínica, con el formato del idioma
+func formatoFechaHora(fecha time.Time, idioma string) (string, string) {
+	fecha = fecha.In(ZonaClinica())
+	if idioma == "en" {
+		return fecha.Format("January 2, 2006"), fecha.Format("3:04 PM")
+	}
+	return fecha.Format("02/01/2006"), fecha.Format("15:04")
+}
+
+// Enlace a la página de APP_URL que confirma o cancela la cita con el token
+func enlaceRespuestaCita(token, accion string) string {
+	return fmt.Sprintf("%s/cita?token=%s&accion=%s", os.Getenv("APP_URL"), url.QueryEscape(token), accion)
+}
+
+// Carga una cita con las relaciones que usan las plantillas
+func CargarCitaParaPlantilla(db *gorm.DB, citaID uint) (models.Cita, error) {
+	var cita models.Cita
+	err := db.
+		Preload("Paciente.Persona").
+		Preload("PersonaPaciente").
+		Preload("Medico.Usuario.Persona").
+		First(&cita, citaID).Error
+	return cita, err
+}
+
+// Datos de plantilla de una cita (cargada con CargarCitaParaPlantilla). Si se
+// pasa el estado anterior se llenan los campos de reprogramación y reasignación.
+func DatosCita(db *gorm.DB, cita models.Cita, anterior *models.Cita, idioma string) (DatosPlantilla, error) {
+	datos := DatosPlantilla{
+		Paciente:     nombrePaciente(cita),
+		Medico:       nombreCompleto(cita.Medico.Usuario.Persona),
+		Especialidad: cita.Medico.Especialidad,
+		Direccion:    os.Getenv("CLINICA_DIRECCION"),
+	}
+	datos.Fecha, datos.Hora = formatoFechaHora(cita.FechaCita, idioma)
+
+	if anterior != nil {
+		datos.FechaAnterior, datos.HoraAnterior = formatoFechaHora(anterior.FechaCita, idioma)
+		if anterior.MedicoID != cita.MedicoID {
+			var medico models.Medico
+			if err := db.Preload("Usuario.Persona").First(&medico, anterior.MedicoID).Error; err != nil {
+				return datos, err
+			}
+			datos.MedicoAnterior = nombreCompleto(medico.Usuario.Persona)
+		}
+	}
+	return datos, nil
+}
+
+// Datos ficticios para validar y previsualizar plantillas
+func DatosEjemplo(idioma string) DatosPlantilla {
+	ahora := time.Now()
+	datos := DatosPlantilla{
+		Paciente:       "Ana López",
+		Medico:         "Carlos Ramírez",
+		Especialidad:   "Cardiología",
+		Direccion:      os.Getenv("CLINICA_DIRECCION"),
+		MedicoAnterior: "Laura Méndez",
+	}
+	datos.Fecha, datos.Hora = formatoFechaHora(ahora.Add(48*time.Hour), idioma)
+	datos.FechaAnterior, datos.HoraAnterior = formatoFechaHora(ahora.Add(24*time.Hour), idioma)
+	datos.EnlaceConfirmar = enlaceRespuestaCita("ejemplo", "confirmar")
+	datos.EnlaceCancelar = enlaceRespuestaCita("ejemplo", "cancelar")
+	return datos
+}
+
+// Compone la notificación de un tipo para un usuario, en su idioma. La cita
+// debe venir cargada con CargarCitaParaPlantilla.
+func ComponerNotificacion(db *gorm.DB, usuarioID uint, tipo, destino string, cita models.Cita, anterior *models.Cita) (models.Notificacion, error) {
+	var usuario models.Usuario
+	if err := db.Select("id", "idioma").First(&usuario, usuarioID).Error; err != nil {
+		return models.Notificacion{}, err
+	}
+
+	plantilla, _, err := PlantillaVigente(db, tipo, destino, usuario.Idioma)
+	if err != nil {
+		return models.Notificacion{}, err
+	}
+
+	datos, err := DatosCita(db, cita, anterior, plantilla.Idioma)
+	if err != nil {
+		return models.Notificacion{}, err
+	}
+	if tipo == "recordatorio" && destino == "paciente" {
+		token, err := clave.GenerarTokenCita(cita.ID, cita.FechaCita)
+		if err != nil {
+			return models.Notificacion{}, err
+		}
+		datos.EnlaceConfirmar = enlaceRespuestaCita(token, "confirmar")
+		datos.EnlaceCancelar = enlaceRespuestaCita(token, "cancelar")
+	}
+
+	contenido, err := RenderizarPlantilla(*plantilla, datos)
+	if err != nil {
+		return models.Notificacion{}, fmt.Errorf("plantilla %s/%s/%s inválida: %w", tipo, destino, plantilla.Idioma, err)
+	}
+
+	return models.Notificacion{
+		IDUsuario:   usuarioID,
+		CitaID:      cita.ID,
+		Tipo:        tipo,
+		Asunto:      contenido.Asunto,
+		Mensaje:     contenido.Texto,
+		MensajeHTML: contenido.HTML,
+	}, nil
+}
@@ -0,0 +1,109 @@
+package mensajeria
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Ilimm9/CMedicas/initializers"
+	"github.com/Ilimm9/CMedicas/models"
+)
+
+// Tipos de notificación que un usuario puede desactivar
+var TiposNotificacion = []string{"confirmación", "recordatorio", "cancelación", "reprogramación", "completada", "reasignación"}
+
+// Preferencias del usuario; si no tiene registro se devuelven las predeterminadas
+// (solo correo, todos los tipos, sin horas de silencio)
+func PreferenciasUsuario(usuarioID uint) (models.PreferenciaNotificacion, error) {
+	pref := models.PreferenciaNotificacion{UsuarioID: usuarioID}
+	if err := initializers.GetDB().Where("usuario_id = ?", usuarioID).Limit(1).Find(&pref).Error; err != nil {
+		return pref, err
+	}
+	if pref.ID == 0 {
+		pref.Canales = "correo"
+	}
+	return pref, nil
+}
+
+// Divide una lista separada por comas, sin elementos vacíos
+func ListaPreferencia(valor string) []string {
+	var lista []string
+	for _, elemento := range strings.Split(valor, ",") {
+		if elemento = strings.TrimSpace(elemento); elemento != "" {
+			lista = append(lista, elemento)
+		}
+	}
+	return lista
+}
+
+// Orden de canales del usuario (por defecto solo correo)
+func ordenCanales(pref models.PreferenciaNotificacion) []string {
+	if orden := ListaPreferencia(pref.Canales); len(orden) > 0 {
+		return orden
+	}
+	return []string{"correo"}
+}
+
+func tipoDesactivado(pref models.PreferenciaNotificacion, tipo string) bool {
+	for _, t := range ListaPreferencia(pref.TiposDesactivados) {
+		if t == tipo {
+			return true
+		}
+	}
+	return false
+}
+
+// Acepta horas "HH:MM" de 00:00 a 23:59
+func HoraValida(hora string) bool {
+	_, err := time.Parse("15:04", hora)
+	return err == nil
+}
+
+// Zona horaria del usuario, o la de la clínica si no eligió una válida
+func zonaUsuario(pref models.PreferenciaNotificacion) *time.Location {
+	if pref.ZonaHoraria != "" {
+		if loc, err := time.LoadLocation(pref.ZonaHoraria); err == nil {
+			return loc
+		}
+	}
+	return ZonaClinica()
+}
+
+// Si ahora cae en las horas de silencio del usuario, devuelve cuándo terminan.
+// El periodo puede cruzar la medianoche (22:00 a 07:00).
+func finSilencio(pref models.PreferenciaNotificacion, ahora time.Time) (time.Time, bool) {
+	if !HoraValida(pref.SilencioInicio) || !HoraValida(pref.SilencioFin) || pref.SilencioInicio == pref.SilencioFin {
+		return time.Time{}, false
+	}
+
+	local := ahora.In(zonaUsuario(pref))
+	enDia := func(hora string) time.Time {
+		h, _ := time.Parse("15:04", hora)
+		return time.Date(local.Year(), local.Month(), local.Day(), h.Hour(), h.Minute(), 0, 0, local.Location())
+	}
+	inicio, fin := enDia(pref.SilencioInicio), enDia(pref.SilencioFin)
+
+	if inicio.Before(fin) {
+		if !local.Before(inicio) && local.Before(fin) {
+			return fin, true
+		}
+		return time.Time{}, false
+	}
+
+	// Cruza la medianoche
+	if !local.Before(inicio) {
+		return fin.AddDate(0, 0, 1), true
+	}
+	if local.Before(fin) {
+		return fin, true
+	}
+	return time.Time{}, false
+}
+
+// Enlace de baja de un tipo de notificación (página de APP_URL que confirma la baja)
+func enlaceBaja(token, tipo string) string {
+	return fmt.Sprintf("%s/baja-notificaciones?token=%s&tipo=%s",
+		os.Getenv("APP_URL"), url.QueryEscape(token), url.QueryEscape(tipo))
+}
@@ -0,0 +1,146 @@
+package mensajeria
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Ilimm9/CMedicas/initializers"
+	"github.com/Ilimm9/CMedicas/models"
+
+	"gorm.io/gorm/clause"
+)
+
+const intervaloRecordatorios = time.Minute
+
+// Anticipaciones de los recordatorios, de RECORDATORIO_ANTICIPACION (ej. "48h,2h").
+// Se devuelven de menor a mayor.
+func anticipacionesRecordatorio() []time.Duration {
+	valor := os.Getenv("RECORDATORIO_ANTICIPACION")
+	if valor == "" {
+		valor = "48h,2h"
+	}
+
+	var anticipaciones []time.Duration
+	for _, parte := range strings.Split(valor, ",") {
+		d, err := time.ParseDuration(strings.TrimSpace(parte))
+		if err != nil || d <= 0 {
+			log.Printf("Anticipación de recordatorio inválida: %q", parte)
+			continue
+		}
+		anticipaciones = append(anticipaciones, d)
+	}
+	sort.Slice(anticipaciones, func(i, j int) bool { return anticipaciones[i] < anticipaciones[j] })
+	return anticipaciones
+}
+
+// Zona horaria de la clínica para mostrar fechas (CLINICA_ZONA_HORARIA)
+func ZonaClinica() *time.Location {
+	nombre := os.Getenv("CLINICA_ZONA_HORARIA")
+	if nombre == "" {
+		nombre = "America/Mexico_City"
+	}
+	if loc, err := time.LoadLocation(nombre); err == nil {
+		return loc
+	}
+	return time.UTC
+}
+
+// Inicia el programador que genera recordatorios de citas próximas
+func IniciarRecordatorios(ctx context.Context) {
+	anticipaciones := anticipacionesRecordatorio()
+	if len(anticipaciones) == 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(intervaloRecordatorios)
+		defer ticker.Stop()
+
+		for {
+			if err := generarRecordatorios(time.Now(), anticipaciones); err != nil {
+				log.Println("Error al generar recordatorios:", err)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Encola los recordatorios que ya deben salir. Cada recordatorio tiene una clave
+// única (cita, anticipación, fecha de la cita), así que repetir la pasada —tras
+// un reinicio o desde otra réplica— no genera duplicados, y una cita reprogramada
+// obtiene recordatorios nuevos para su nueva fecha.
+func generarRecordatorios(ahora time.Time, anticipaciones []time.Duration) error {
+	mayor := anticipaciones[len(anticipaciones)-1]
+
+	var citas []models.Cita
+	if err := initializers.GetDB().
+		Preload("Paciente.Persona").
+		Preload("PersonaPaciente").
+		Preload("Medico.Usuario.Persona").
+		Where("estado = ? AND fecha_cita > ? AND fecha_cita <= ?", "programada", ahora, ahora.Add(mayor)).
+		Find(&citas).Error; err != nil {
+		return err
+	}
+
+	for _, cita := range citas {
+		// Solo la anticipación más cercana que ya aplica: una cita a 1h no recibe
+		// el de 48h y el de 2h a la vez
+		var anticipacion time.Duration
+		for _, a := range anticipaciones {
+			if !cita.FechaCita.After(ahora.Add(a)) {
+				anticipacion = a
+				break
+			}
+		}
+
+		// Si se agendó dentro de esa ventana, la confirmación ya cumple la función
+		if cita.CreadaEn.After(cita.FechaCita.Add(-anticipacion)) {
+			continue
+		}
+
+		notificacion, err := ComponerNotificacion(initializers.GetDB(), cita.PacienteID, "recordatorio", "paciente", cita, nil)
+		if err != nil {
+			log.Printf("Error al componer recordatorio de la cita %d: %v", cita.ID, err)
+			continue
+		}
+
+		clave := fmt.Sprintf("recordatorio:%d:%s:%d", cita.ID, anticipacion, cita.FechaCita.Unix())
+		fechaCita := cita.FechaCita
+		notificacion.FechaEnvio = ahora
+		notificacion.Estado = "pendiente"
+		notificacion.ProximoIntento = ahora
+		notificacion.Clave = &clave
+		notificacion.FechaCitaReferencia = &fechaCita
+
+		if err := initializers.GetDB().
+			Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "clave"}}, DoNothing: true}).
+			Create(&notificacion).Error; err != nil {
+			log.Printf("Error al encolar recordatorio de la cita %d: %v", cita.ID, err)
+		}
+	}
+	return nil
+}
+
+// Un recordatorio pierde vigencia si la cita se canceló o cambió de fecha
+// después de generarlo
+func recordatorioVigente(notificacion *models.Notificacion) (bool, error) {
+	if notificacion.Tipo != "recordatorio" || notificacion.FechaCitaReferencia == nil {
+		return true, nil
+	}
+
+	var cita models.Cita
+	if err := initializers.GetDB().First(&cita, notificacion.CitaID).Error; err != nil {
+		return false, err
+	}
+	return cita.Estado == "programada" && cita.FechaCita.Equal(*notificacion.FechaCitaReferencia), nil
+}
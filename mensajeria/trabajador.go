@@ -0,0 +1,238 @@
+package mensajeria
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/rand"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/Ilimm9/CMedicas/clave"
+	"github.com/Ilimm9/CMedicas/initializers"
+	"github.com/Ilimm9/CMedicas/models"
+	"github.com/Ilimm9/CMedicas/tiemporeal"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	intervaloSondeo = 2 * time.Second
+	duracionBloqueo = 5 * time.Minute // si el proceso muere a mitad de un envío, otro lo retoma
+	esperaBase      = 30 * time.Second
+	esperaMaxima    = 2 * time.Hour
+	trabajadoresDef = 2
+	maxIntentosDef  = 6
+)
+
+// Configuración leída de NOTIF_TRABAJADORES y NOTIF_MAX_INTENTOS
+func enteroEnv(nombre string, porDefecto int) int {
+	if v, err := strconv.Atoi(os.Getenv(nombre)); err == nil && v > 0 {
+		return v
+	}
+	return porDefecto
+}
+
+// Inicia el pool de trabajadores que entrega las notificaciones pendientes.
+// Varias réplicas pueden correrlo a la vez: cada fila se reclama con SKIP LOCKED.
+func IniciarTrabajadores(ctx context.Context) {
+	n := enteroEnv("NOTIF_TRABAJADORES", trabajadoresDef)
+	maxIntentos := enteroEnv("NOTIF_MAX_INTENTOS", maxIntentosDef)
+
+	for i := 0; i < n; i++ {
+		go trabajar(ctx, maxIntentos)
+	}
+}
+
+func trabajar(ctx context.Context, maxIntentos int) {
+	ticker := time.NewTicker(intervaloSondeo)
+	defer ticker.Stop()
+
+	for {
+		// Procesa mientras haya trabajo; al vaciarse la cola espera al siguiente sondeo
+		for {
+			notificacion, err := reclamarSiguiente()
+			if err != nil {
+				if !errors.Is(err, gorm.ErrRecordNotFound) {
+					log.Println("Error al reclamar notificación:", err)
+				}
+				break
+			}
+			procesar(notificacion, maxIntentos)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Marca como "enviando" la siguiente notificación lista para entrega
+func reclamarSiguiente() (*models.Notificacion, error) {
+	var notificacion models.Notificacion
+	ahora := time.Now()
+
+	err := initializers.GetDB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("(estado = ? AND proximo_intento <= ?) OR (estado = ? AND bloqueada_hasta < ?)",
+				"pendiente", ahora, "enviando", ahora).
+			Order("proximo_intento").
+			Limit(1).
+			Find(&notificacion).Error; err != nil {
+			return err
+		}
+		if notificacion.ID == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		bloqueo := ahora.Add(duracionBloqueo)
+		notificacion.Estado = "enviando"
+		notificacion.Intentos++
+		notificacion.BloqueadaHasta = &bloqueo
+		return tx.Model(&notificacion).Updates(map[string]interface{}{
+			"estado":          notificacion.Estado,
+			"intentos":        notificacion.Intentos,
+			"bloqueada_hasta": bloqueo,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &notificacion, nil
+}
+
+func procesar(notificacion *models.Notificacion, maxIntentos int) {
+	if vigente, err := recordatorioVigente(notificacion); err == nil && !vigente {
+		descartar(notificacion, "la cita se canceló o se reprogramó")
+		return
+	}
+
+	pref, err := PreferenciasUsuario(notificacion.IDUsuario)
+	if err != nil {
+		registrarFallo(notificacion, maxIntentos, err)
+		return
+	}
+
+	if tipoDesactivado(pref, notificacion.Tipo) {
+		descartar(notificacion, "el usuario desactivó este tipo de notificación")
+		return
+	}
+
+	// En horas de silencio se pospone hasta que terminen, sin contar como intento
+	if fin, ok := finSilencio(pref, time.Now()); ok {
+		if notificacion.FechaCitaReferencia != nil && !fin.Before(*notificacion.FechaCitaReferencia) {
+			descartar(notificacion, "las horas de silencio terminan después de la cita")
+			return
+		}
+		initializers.GetDB().Model(notificacion).Updates(map[string]interface{}{
+			"estado":          "pendiente",
+			"intentos":        notificacion.Intentos - 1,
+			"proximo_intento": fin,
+			"bloqueada_hasta": nil,
+		})
+		return
+	}
+
+	if err := entregar(notificacion, pref); err != nil {
+		registrarFallo(notificacion, maxIntentos, err)
+		return
+	}
+
+	initializers.GetDB().Model(notificacion).Updates(map[string]interface{}{
+		"estado":          "enviada",
+		"enviada_en":      time.Now(),
+		"bloqueada_hasta": nil,
+		"ultimo_error":    "",
+	})
+
+	// La bandeja solo muestra las entregadas: se avisa a la aplicación hasta
+	// que la notificación aparece en ella
+	tiemporeal.Publicar(tiemporeal.Evento{
+		Tipo:     "notificacion",
+		Datos:    notificacion,
+		Usuarios: []uint{notificacion.IDUsuario},
+	})
+}
+
+func descartar(notificacion *models.Notificacion, motivo string) {
+	initializers.GetDB().Model(notificacion).Updates(map[string]interface{}{
+		"estado":          "descartada",
+		"bloqueada_hasta": nil,
+		"ultimo_error":    motivo,
+	})
+}
+
+func registrarFallo(notificacion *models.Notificacion, maxIntentos int, err error) {
+	cambios := map[string]interface{}{
+		"ultimo_error":    err.Error(),
+		"bloqueada_hasta": nil,
+	}
+	if notificacion.Intentos >= maxIntentos {
+		// Cola de fallidas: solo sale con un reintento manual
+		cambios["estado"] = "fallida"
+		log.Printf("Notificación %d fallida tras %d intentos: %v", notificacion.ID, notificacion.Intentos, err)
+	} else {
+		cambios["estado"] = "pendiente"
+		cambios["proximo_intento"] = time.Now().Add(esperaReintento(notificacion.Intentos))
+	}
+	initializers.GetDB().Model(notificacion).Updates(cambios)
+}
+
+// Retroceso exponencial con jitter: 30s, 1m, 2m, 4m... hasta 2h
+func esperaReintento(intentos int) time.Duration {
+	espera := esperaBase << uint(intentos-1)
+	if espera <= 0 || espera > esperaMaxima {
+		espera = esperaMaxima
+	}
+	jitter := time.Duration(rand.Int63n(int64(espera) / 5))
+	return espera + jitter
+}
+
+// Entrega por los canales del usuario en su orden de preferencia y registra
+// el canal que la entregó
+func entregar(notificacion *models.Notificacion, pref models.PreferenciaNotificacion) error {
+	var usuario models.Usuario
+	if err := initializers.GetDB().Preload("Persona").First(&usuario, notificacion.IDUsuario).Error; err != nil {
+		return err
+	}
+
+	ctx, cancelar := context.WithTimeout(context.Background(), time.Minute)
+	defer cancelar()
+
+	asunto := notificacion.Asunto
+	if asunto == "" {
+		asunto = "Notificación " + notificacion.Tipo
+	}
+
+	// Cada mensaje lleva su propio enlace de baja; solo se guarda el hash
+	token, err := clave.GenerarTokenAleatorio()
+	if err != nil {
+		return err
+	}
+	if err := initializers.GetDB().Model(notificacion).Update("token_baja_hash", clave.HashToken(token)).Error; err != nil {
+		return err
+	}
+
+	mensaje := Mensaje{
+		Destinatario: usuario,
+		Asunto:       asunto,
+		Texto:        notificacion.Mensaje,
+		HTML:         notificacion.MensajeHTML,
+		EnlaceBaja:   enlaceBaja(token, notificacion.Tipo),
+	}
+	if notificacion.AdjuntoICS != "" {
+		mensaje.Adjuntos = append(mensaje.Adjuntos, adjuntoICS(notificacion.AdjuntoICS))
+	}
+
+	canal, err := enviarPorCanales(ctx, ordenCanales(pref), mensaje)
+	if err != nil {
+		return err
+	}
+
+	notificacion.Canal = canal
+	return initializers.GetDB().Model(notificacion).Update("canal", canal).Error
+}
@@ -5,8 +5,10 @@ import (
 
 	respuestas "github.com/Ilimm9/CMedicas/Respuestas"
 	"github.com/Ilimm9/CMedicas/clave"
+	"github.com/Ilimm9/CMedicas/initializers"
+	"github.com/Ilimm9/CMedicas/models"
 
-	"github.com/dgrijalva/jwt-go"
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/gin-gonic/gin"
 )
 
@@ -32,9 +34,32 @@ func AuthMiddleware() gin.HandlerFunc {
 		}
 
 		if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
+			// Solo tokens de sesión: los de enlace (con audiencia) no identifican a un usuario
+			if _, esSesion := claims["sub"].(float64); !esSesion || claims["aud"] != nil {
+				respuestas.RespondError(c, http.StatusUnauthorized, "Token inválido")
+				c.Abort()
+				return
+			}
+
+			// La cuenta debe seguir activa y el token pertenecer a la sesión vigente
+			var usuario models.Usuario
+			if err := initializers.GetDB().Select("id", "activo", "version_sesion").First(&usuario, claims["sub"]).Error; err != nil {
+				respuestas.RespondError(c, http.StatusUnauthorized, "Usuario no encontrado")
+				c.Abort()
+				return
+			}
+
+			version, _ := claims["ver"].(float64)
+			if !usuario.Activo || uint(version) != usuario.VersionSesion {
+				respuestas.RespondError(c, http.StatusUnauthorized, "Sesión inválida, inicie sesión nuevamente")
+				c.Abort()
+				return
+			}
+
 			// Guardar información del usuario en el contexto
 			c.Set("userID", claims["sub"])
 			c.Set("userRol", claims["rol"])
+			c.Set("versionSesion", usuario.VersionSesion)
 			c.Next()
 		} else {
 			respuestas.RespondError(c, http.StatusUnauthorized, "Token inválido")
@@ -43,6 +68,45 @@ func AuthMiddleware() gin.HandlerFunc {
 	}
 }
 
+// EventSource no permite enviar cabeceras: el flujo de eventos acepta en
+// ?ticket= un boleto de corta duración (POST /api/eventos/ticket). Nunca el
+// token de sesión, porque la URL queda en los registros de acceso. Sin boleto
+// se autentica con la cabecera como cualquier otra ruta.
+func AuthEventos() gin.HandlerFunc {
+	autenticar := AuthMiddleware()
+	return func(c *gin.Context) {
+		ticket := c.Query("ticket")
+		if ticket == "" {
+			autenticar(c)
+			return
+		}
+
+		usuarioID, rol, version, err := clave.ValidarTicketEventos(ticket)
+		if err != nil {
+			respuestas.RespondError(c, http.StatusUnauthorized, "Boleto inválido o vencido")
+			c.Abort()
+			return
+		}
+
+		var usuario models.Usuario
+		if err := initializers.GetDB().Select("id", "activo", "version_sesion").First(&usuario, usuarioID).Error; err != nil {
+			respuestas.RespondError(c, http.StatusUnauthorized, "Usuario no encontrado")
+			c.Abort()
+			return
+		}
+		if !usuario.Activo || version != usuario.VersionSesion {
+			respuestas.RespondError(c, http.StatusUnauthorized, "Sesión inválida, inicie sesión nuevamente")
+			c.Abort()
+			return
+		}
+
+		c.Set("userID", usuarioID)
+		c.Set("userRol", rol)
+		c.Set("versionSesion", version)
+		c.Next()
+	}
+}
+
 func AdminOnly() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		rol := c.GetString("userRol")
@@ -8,9 +8,77 @@ import (
 func Migrations(){
 	initializers.DB.AutoMigrate(&models.Persona{})
 	initializers.DB.AutoMigrate(&models.Usuario{})
+	initializers.DB.AutoMigrate(&models.Especialidad{})
 	initializers.DB.AutoMigrate(&models.Medico{})
+	// La especialidad principal también figura entre las asociadas, que son las
+	// que consultan filtros y referencias
+	initializers.DB.Exec(`INSERT INTO especialidads (nombre)
+		SELECT DISTINCT TRIM(especialidad) FROM medicos WHERE TRIM(especialidad) <> ''
+		ON CONFLICT (nombre) DO NOTHING`)
+	initializers.DB.Exec(`INSERT INTO medico_especialidades (medico_id, especialidad_id)
+		SELECT m.id, e.id FROM medicos m JOIN especialidads e ON e.nombre = TRIM(m.especialidad)
+		ON CONFLICT DO NOTHING`)
 	initializers.DB.AutoMigrate(&models.Cita{})
 	initializers.DB.AutoMigrate(&models.Horario{})
+	// Las notificaciones anteriores al outbox ya se enviaron en su momento:
+	// se marcan como enviadas para que el trabajador no las reenvíe
+	sinOutbox := initializers.DB.Migrator().HasTable(&models.Notificacion{}) &&
+		!initializers.DB.Migrator().HasColumn(&models.Notificacion{}, "Estado")
+	// AutoMigrate no reemplaza un check existente: se eliminan para que se
+	// vuelvan a crear con los tipos y estados actuales
+	for _, restriccion := range []string{"chk_notificacions_tipo", "chk_notificacions_estado"} {
+		if initializers.DB.Migrator().HasConstraint(&models.Notificacion{}, restriccion) {
+			initializers.DB.Migrator().DropConstraint(&models.Notificacion{}, restriccion)
+		}
+	}
 	initializers.DB.AutoMigrate(&models.Notificacion{})
+	if sinOutbox {
+		initializers.DB.Model(&models.Notificacion{}).Where("1 = 1").Update("estado", "enviada")
+	}
+	initializers.DB.AutoMigrate(&models.CatalogoDiagnostico{})
 	initializers.DB.AutoMigrate(&models.Observacion{})
+	initializers.DB.AutoMigrate(&models.DiagnosticoObservacion{})
+	initializers.DB.AutoMigrate(&models.Medicamento{})
+	// Una receta emitida no se borra con su cita: la llave foránea se vuelve a
+	// crear con RESTRICT en bases que la tenían en cascada
+	if initializers.DB.Migrator().HasConstraint(&models.Receta{}, "Cita") {
+		initializers.DB.Migrator().DropConstraint(&models.Receta{}, "Cita")
+	}
+	initializers.DB.AutoMigrate(&models.Receta{})
+	initializers.DB.AutoMigrate(&models.RecetaMedicamento{})
+	initializers.DB.AutoMigrate(&models.Alergia{})
+	initializers.DB.AutoMigrate(&models.CondicionCronica{})
+	initializers.DB.AutoMigrate(&models.MedicacionActual{})
+	initializers.DB.AutoMigrate(&models.ContactoEmergencia{})
+	initializers.DB.AutoMigrate(&models.Adjunto{})
+	initializers.DB.AutoMigrate(&models.Referencia{})
+	initializers.DB.AutoMigrate(&models.ObservacionVersion{})
+	initializers.DB.AutoMigrate(&models.AdendaObservacion{})
+	// Versiones y adendas son evidencia médico-legal: la base rechaza
+	// cambiarlas o borrarlas, incluso en cascada
+	initializers.DB.Exec(`CREATE OR REPLACE FUNCTION registro_clinico_inmutable() RETURNS trigger AS $$
+		BEGIN
+			RAISE EXCEPTION 'registro clínico inmutable: no se puede % en %', TG_OP, TG_TABLE_NAME;
+		END;
+		$$ LANGUAGE plpgsql`)
+	for _, tabla := range []string{"observacion_versions", "adenda_observacions"} {
+		initializers.DB.Exec("DROP TRIGGER IF EXISTS " + tabla + "_inmutable ON " + tabla)
+		initializers.DB.Exec("CREATE TRIGGER " + tabla + "_inmutable BEFORE UPDATE OR DELETE ON " + tabla +
+			" FOR EACH ROW EXECUTE FUNCTION registro_clinico_inmutable()")
+	}
+	initializers.DB.AutoMigrate(&models.CodigoRecuperacion{})
+	initializers.DB.AutoMigrate(&models.DesafioLogin{})
+	initializers.DB.AutoMigrate(&models.TokenUsuario{})
+	initializers.DB.AutoMigrate(&models.Dependiente{})
+	initializers.DB.AutoMigrate(&models.PreferenciaNotificacion{})
+	// Los tokens de baja se guardaban en claro: se conserva solo su hash
+	if initializers.DB.Migrator().HasColumn(&models.PreferenciaNotificacion{}, "token_baja") {
+		initializers.DB.Exec(`UPDATE preferencia_notificacions
+			SET token_baja_hash = encode(sha256(convert_to(token_baja, 'UTF8')), 'hex')
+			WHERE token_baja IS NOT NULL`)
+		initializers.DB.Migrator().DropColumn(&models.PreferenciaNotificacion{}, "token_baja")
+	}
+	initializers.DB.AutoMigrate(&models.SuscripcionPush{})
+	initializers.DB.AutoMigrate(&models.PlantillaNotificacion{})
+	initializers.DB.AutoMigrate(&models.FeedCalendario{})
 }
\ No newline at end of file
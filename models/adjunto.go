@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+// Archivo clínico de una persona (resultado de laboratorio, imagen, referencia),
+// opcionalmente ligado a una cita. El archivo vive en el almacén configurado.
+type Adjunto struct {
+    ID             uint       `gorm:"primaryKey"`
+    PersonaID      uint       `gorm:"not null;index"`
+    Persona        Persona    `gorm:"foreignKey:PersonaID;constraint:OnUpdate:CASCADE,OnDelete:RESTRICT;" json:"-"`
+    CitaID         *uint      `gorm:"index"`
+    Categoria      string     `gorm:"type:varchar(20);not null;check(categoria IN ('laboratorio', 'imagen', 'referencia', 'otro'))"`
+    Nombre         string     `gorm:"size:255;not null"` // Nombre original del archivo
+    Descripcion    string     `gorm:"type:text"`
+    TipoMIME       string     `gorm:"size:100;not null"` // Detectado del contenido, no el declarado
+    Tamano         int64      `gorm:"not null"`
+    SHA256         string     `gorm:"column:sha256;size:64;not null;index"`
+    Clave          string     `gorm:"size:300;not null" json:"-"` // Ubicación en el almacén
+    Miniatura      bool       `gorm:"not null;default:false"` // Imágenes: miniatura JPEG en Clave + ".miniatura.jpg"
+    Almacen        string     `gorm:"size:20;not null" json:"-"` // local o s3
+    SubidoPor      uint       `gorm:"not null"`
+    CreadoEn       time.Time  `gorm:"autoCreateTime"`
+    // Se retira en lugar de borrarse; el archivo se conserva
+    EliminadoEn    *time.Time
+    EliminadoPor   *uint
+}
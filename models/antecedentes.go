@@ -0,0 +1,63 @@
+package models
+
+import "time"
+
+// Alergia de una persona. Con MedicamentoID se reconoce al recetar ese medicamento;
+// si no, se compara la sustancia con el nombre del medicamento.
+type Alergia struct {
+    ID            uint       `gorm:"primaryKey"`
+    PersonaID     uint       `gorm:"not null;index"`
+    Persona       Persona    `gorm:"foreignKey:PersonaID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`
+    Sustancia     string     `gorm:"size:200;not null"`
+    MedicamentoID *uint
+    Reaccion      string     `gorm:"type:text"`
+    Severidad     string     `gorm:"type:varchar(20);not null;check(severidad IN ('leve', 'moderada', 'grave'))"`
+    Activa        bool       `gorm:"not null;default:true"` // Se desactiva en lugar de borrarse
+    RegistradaPor *uint
+    CreadaEn      time.Time  `gorm:"autoCreateTime"`
+    ActualizadaEn time.Time  `gorm:"autoUpdateTime"`
+}
+
+// Enfermedad crónica de una persona, opcionalmente codificada en CIE-10
+type CondicionCronica struct {
+    ID                    uint                 `gorm:"primaryKey"`
+    PersonaID             uint                 `gorm:"not null;index"`
+    Persona               Persona              `gorm:"foreignKey:PersonaID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`
+    Nombre                string               `gorm:"size:200;not null"`
+    CatalogoDiagnosticoID *uint
+    Catalogo              *CatalogoDiagnostico `gorm:"foreignKey:CatalogoDiagnosticoID"`
+    DiagnosticadaEn       *time.Time           `gorm:"type:date"`
+    Notas                 string               `gorm:"type:text"`
+    Activa                bool                 `gorm:"not null;default:true"`
+    RegistradaPor         *uint
+    CreadaEn              time.Time            `gorm:"autoCreateTime"`
+    ActualizadaEn         time.Time            `gorm:"autoUpdateTime"`
+}
+
+// Medicamento que la persona toma actualmente (de cualquier origen, no solo recetas de la clínica)
+type MedicacionActual struct {
+    ID            uint       `gorm:"primaryKey"`
+    PersonaID     uint       `gorm:"not null;index"`
+    Persona       Persona    `gorm:"foreignKey:PersonaID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`
+    Nombre        string     `gorm:"size:200;not null"`
+    MedicamentoID *uint
+    Dosis         string     `gorm:"size:100"`
+    Frecuencia    string     `gorm:"size:100"`
+    Via           string     `gorm:"size:30"`
+    Desde         *time.Time `gorm:"type:date"`
+    Activa        bool       `gorm:"not null;default:true"` // false: la suspendió
+    RegistradaPor *uint
+    CreadaEn      time.Time  `gorm:"autoCreateTime"`
+    ActualizadaEn time.Time  `gorm:"autoUpdateTime"`
+}
+
+type ContactoEmergencia struct {
+    ID         uint    `gorm:"primaryKey"`
+    PersonaID  uint    `gorm:"not null;index"`
+    Persona    Persona `gorm:"foreignKey:PersonaID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`
+    Nombre     string  `gorm:"size:200;not null"`
+    Parentesco string  `gorm:"size:50"`
+    Telefono   string  `gorm:"size:20;not null"`
+    Correo     string  `gorm:"size:100"`
+    Prioridad  int     `gorm:"not null;default:1"` // 1 = primero en llamarse
+}
@@ -6,12 +6,19 @@ type Cita struct {
     ID         uint      `gorm:"primaryKey"`
     PacienteID uint      `gorm:"not null"`
     Paciente   Usuario   `gorm:"foreignKey:PacienteID;constraint:OnUpdate:CASCADE,OnDelete:SET NULL;"`
+    // Paciente de registro cuando la cita es de un dependiente; PacienteID es la cuenta responsable
+    PersonaPacienteID *uint    `gorm:"index"`
+    PersonaPaciente   *Persona `gorm:"foreignKey:PersonaPacienteID"`
     MedicoID   uint      `gorm:"not null"`
     Medico     Medico    `gorm:"foreignKey:MedicoID;constraint:OnUpdate:CASCADE,OnDelete:SET NULL;"`
     FechaCita  time.Time `gorm:"not null;index"` // Índice para búsquedas
     Motivo     string    `gorm:"type:text"`
     Estado     string    `gorm:"type:varchar(20);check(estado IN ('programada', 'cancelada', 'completada'));index"`
     CreadaEn   time.Time `gorm:"autoCreateTime"`
+    // El paciente confirmó su asistencia (enlace del recordatorio); se borra al reprogramar
+    ConfirmadaEn *time.Time
+    // SEQUENCE del evento iCalendar; sube con cada cambio enviado al paciente
+    SecuenciaICS int `gorm:"not null;default:0" json:"-"`
     
     Notificaciones []Notificacion `gorm:"foreignKey:CitaID"`
 }
@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// Relación tutor–dependiente: un usuario gestiona personas sin cuenta propia
+// (menores, adultos mayores) y agenda citas en su nombre
+type Dependiente struct {
+    ID           uint      `gorm:"primaryKey"`
+    TutorID      uint      `gorm:"not null;index"`
+    Tutor        Usuario   `gorm:"foreignKey:TutorID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`
+    PersonaID    uint      `gorm:"not null;index"`
+    Persona      Persona   `gorm:"foreignKey:PersonaID"`
+    Parentesco   string    `gorm:"type:varchar(20);not null;check(parentesco IN ('hijo', 'padre', 'madre', 'conyuge', 'tutelado', 'otro'))"`
+    Activa       bool      `gorm:"not null;default:true"`
+    CreadaEn     time.Time `gorm:"autoCreateTime"`
+    FinalizadaEn *time.Time
+}
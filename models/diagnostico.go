@@ -0,0 +1,19 @@
+package models
+
+// Código CIE-10 (ICD-10) del catálogo de diagnósticos
+type CatalogoDiagnostico struct {
+    ID          uint   `gorm:"primaryKey"`
+    Codigo      string `gorm:"size:10;uniqueIndex;not null"` // Normalizado: "E11.9"
+    Descripcion string `gorm:"type:text;not null"`
+    Activo      bool   `gorm:"not null;default:true"` // Los retirados no se ofrecen en la búsqueda
+}
+
+// Diagnóstico codificado de una observación
+type DiagnosticoObservacion struct {
+    ID                    uint                `gorm:"primaryKey"`
+    ObservacionID         uint                `gorm:"not null;uniqueIndex:idx_diagnostico_observacion"`
+    CatalogoDiagnosticoID uint                `gorm:"not null;uniqueIndex:idx_diagnostico_observacion;index"`
+    Catalogo              CatalogoDiagnostico `gorm:"foreignKey:CatalogoDiagnosticoID"`
+    Tipo                  string              `gorm:"type:varchar(20);not null;check(tipo IN ('principal', 'secundario'))"`
+    Nota                  string              `gorm:"type:text"`
+}
@@ -0,0 +1,7 @@
+package models
+
+// Catálogo de especialidades médicas
+type Especialidad struct {
+    ID     uint   `gorm:"primaryKey"`
+    Nombre string `gorm:"size:100;uniqueIndex;not null"`
+}
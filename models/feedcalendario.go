@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// URL de suscripción iCalendar (solo lectura) con las citas de un usuario.
+// El token va en la URL; en BD solo se guarda su hash.
+type FeedCalendario struct {
+    ID           uint       `gorm:"primaryKey"`
+    UsuarioID    uint       `gorm:"not null;index"`
+    Usuario      Usuario    `gorm:"foreignKey:UsuarioID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`
+    Nombre       string     `gorm:"size:100;not null"`
+    TokenHash    string     `gorm:"size:64;uniqueIndex;not null" json:"-"`
+    // Qué se muestra en los eventos: 'ocupado' (solo "Cita"), 'reducida' (iniciales
+    // del paciente, sin motivo) o 'completa' (nombres y motivo)
+    Privacidad   string     `gorm:"type:varchar(20);not null;default:'reducida';check(privacidad IN ('ocupado', 'reducida', 'completa'))"`
+    CreadoEn     time.Time  `gorm:"autoCreateTime"`
+    UltimoAcceso *time.Time
+    RevocadoEn   *time.Time
+}
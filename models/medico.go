@@ -4,7 +4,9 @@ type Medico struct {
     ID           uint    `gorm:"primaryKey"`
     UsuarioID    uint    `gorm:"unique;not null"`
     Usuario      Usuario `gorm:"foreignKey:UsuarioID"`
-    Especialidad string  `gorm:"size:100;not null"`
+    Especialidad string  `gorm:"size:100;not null"` // Especialidad principal
+    CedulaProfesional string `gorm:"size:20"` // Aparece en las recetas
+    Especialidades []Especialidad `gorm:"many2many:medico_especialidades;"`
     Horarios    []Horario `gorm:"foreignKey:MedicoID"`
     Cita       []Cita    `gorm:"foreignKey:MedicoID"` 
 }
\ No newline at end of file
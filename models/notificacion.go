@@ -4,11 +4,36 @@ import "time"
 
 type Notificacion struct {
     ID         uint      `gorm:"primaryKey"`
-    IDUsuario  uint      `gorm:"not null"`
+    IDUsuario  uint      `gorm:"not null;index"`
     Usuario    Usuario   `gorm:"foreignKey:IDUsuario"` // Relación con Usuario
     CitaID     uint      `gorm:"not null"`
     Cita       Cita      `gorm:"foreignKey:CitaID"` // Relación con Cita
-    Tipo       string    `gorm:"type:varchar(20);check(tipo IN ('confirmación', 'recordatorio', 'cancelación'))"`
+    Tipo       string    `gorm:"type:varchar(20);check(tipo IN ('confirmación', 'recordatorio', 'cancelación', 'reprogramación', 'completada', 'reasignación'))"`
+    Asunto     string    `gorm:"size:200"`
     Mensaje    string    `gorm:"type:text"`
+    MensajeHTML string   `gorm:"type:text" json:"-"` // Variante HTML para correo
+    // Invitación iCalendar adjunta al correo (METHOD:REQUEST o CANCEL)
+    AdjuntoICS  string   `gorm:"type:text" json:"-"`
     FechaEnvio time.Time `gorm:"not null"`
-}
\ No newline at end of file
+
+    // Bandeja de la aplicación
+    LeidaEn     *time.Time `gorm:"index"`
+    ArchivadaEn *time.Time
+
+    // Entrega (outbox): la fila se crea en la misma transacción que el cambio
+    // que la origina y un trabajador en segundo plano la envía
+    Estado         string     `gorm:"type:varchar(20);not null;default:'pendiente';check(estado IN ('pendiente', 'enviando', 'enviada', 'fallida', 'descartada'));index"`
+    Intentos       int        `gorm:"not null;default:0"`
+    ProximoIntento time.Time  `gorm:"not null;default:CURRENT_TIMESTAMP;index"`
+    BloqueadaHasta *time.Time `json:"-"`
+    UltimoError    string     `gorm:"type:text"`
+    EnviadaEn      *time.Time
+    Canal          string     `gorm:"size:20"` // Canal por el que finalmente se entregó
+
+    // Clave de idempotencia para notificaciones automáticas (evita duplicados entre réplicas)
+    Clave               *string    `gorm:"size:120;uniqueIndex" json:"-"`
+    // Fecha de la cita a la que se refiere un recordatorio; si la cita cambia, se descarta
+    FechaCitaReferencia *time.Time `json:"-"`
+    // Hash del token del enlace para darse de baja incluido en el mensaje
+    TokenBajaHash       *string    `gorm:"size:64;uniqueIndex" json:"-"`
+}
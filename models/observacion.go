@@ -9,4 +9,80 @@ type Observacion struct {
     Observaciones string    `gorm:"type:text"`
     Diagnostico   string    `gorm:"type:text"`
     FechaRegistro time.Time `gorm:"not null;default:CURRENT_TIMESTAMP"`
-}
\ No newline at end of file
+    // Nota estructurada de la consulta
+    MotivoConsulta    string        `gorm:"type:text"`
+    ExploracionFisica string        `gorm:"type:text"`
+    Evaluacion        string        `gorm:"type:text"`
+    Plan              string        `gorm:"type:text"`
+    SignosVitales     SignosVitales `gorm:"embedded"`
+    // Diagnósticos CIE-10; Diagnostico queda como texto libre complementario
+    Diagnosticos      []DiagnosticoObservacion `gorm:"foreignKey:ObservacionID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+    // Sube con cada cambio; cada versión queda guardada en ObservacionVersion
+    Version       int       `gorm:"not null;default:1"`
+    ActualizadaEn time.Time `gorm:"autoUpdateTime"`
+    // El médico de la cita puede editarla hasta entonces; después solo se
+    // agregan adendas. Nil en observaciones anteriores: cerradas.
+    EditableHasta *time.Time
+    Adendas       []AdendaObservacion `gorm:"foreignKey:ObservacionID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+    // Firmada por el médico: ya nadie la edita, solo se agregan adendas
+    FirmadaEn     *time.Time
+    FirmadaPor    *uint
+    Firma         string `gorm:"type:text"` // JWS sobre la versión firmada, ver clave.FirmarDocumento
+    // Las observaciones no se borran: se anulan y conservan todas sus versiones
+    AnuladaEn       *time.Time
+    AnuladaPor      *uint
+    MotivoAnulacion string `gorm:"type:text"`
+}
+
+// Signos vitales en unidades normalizadas (mmHg, lpm, °C, kg, cm); nil si no se midió
+type SignosVitales struct {
+    PresionSistolica   *int
+    PresionDiastolica  *int
+    FrecuenciaCardiaca *int
+    Temperatura        *float64
+    Peso               *float64
+    Talla              *float64
+    IMC                *float64 `gorm:"column:imc"` // Calculado de peso y talla
+}
+
+// Copia de una observación tal como quedó en cada versión. No se modifica ni
+// se borra (un trigger lo impide en la base, ver migrate)
+type ObservacionVersion struct {
+    ID            uint        `gorm:"primaryKey"`
+    ObservacionID uint        `gorm:"not null;uniqueIndex:idx_observacion_version"`
+    Observacion   Observacion `gorm:"foreignKey:ObservacionID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`
+    Version       int         `gorm:"not null;uniqueIndex:idx_observacion_version"`
+    Contenido     string      `gorm:"type:jsonb;not null"` // ContenidoObservacion en JSON
+    EditadaPor    *uint
+    CreadaEn      time.Time   `gorm:"autoCreateTime"`
+}
+
+// Nota firmada que el médico agrega a una observación ya cerrada
+type AdendaObservacion struct {
+    ID            uint      `gorm:"primaryKey"`
+    ObservacionID uint      `gorm:"not null;index"`
+    MedicoID      uint      `gorm:"not null"`
+    Medico        Medico    `gorm:"foreignKey:MedicoID"`
+    Texto         string    `gorm:"type:text;not null"`
+    CreadaEn      time.Time `gorm:"not null"` // Forma parte de lo firmado; tampoco se modifica
+    Firma         string    `gorm:"type:text;not null"` // JWS de clave.FirmarDocumento
+}
+
+// Campos clínicos de una observación que se versionan
+type ContenidoObservacion struct {
+    Observaciones     string
+    Diagnostico       string
+    MotivoConsulta    string
+    ExploracionFisica string
+    Evaluacion        string
+    Plan              string
+    SignosVitales     SignosVitales
+    Diagnosticos      []DiagnosticoVersionado
+}
+
+type DiagnosticoVersionado struct {
+    Codigo      string
+    Descripcion string
+    Tipo        string
+    Nota        string
+}
@@ -11,5 +11,11 @@ type Persona struct {
     FechaNacimiento time.Time `gorm:"type:date"`    
     Genero          string    `gorm:"type:varchar(20);check(genero IN ('masculino', 'femenino', 'otro'))"`
     Direccion       string    `gorm:"type:text"`
-    
+    TipoSangre      string    `gorm:"type:varchar(3)"`
+
+    // Antecedentes clínicos; solo se cargan para quien puede ver el historial
+    Alergias              []Alergia            `gorm:"foreignKey:PersonaID" json:",omitempty"`
+    CondicionesCronicas   []CondicionCronica   `gorm:"foreignKey:PersonaID" json:",omitempty"`
+    MedicacionActual      []MedicacionActual   `gorm:"foreignKey:PersonaID" json:",omitempty"`
+    ContactosEmergencia   []ContactoEmergencia `gorm:"foreignKey:PersonaID" json:",omitempty"`
 }
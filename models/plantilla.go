@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// Plantilla de notificación personalizada por un administrador. Las que no
+// existen en la tabla usan el texto predeterminado del sistema.
+type PlantillaNotificacion struct {
+    ID      uint   `gorm:"primaryKey"`
+    Tipo    string `gorm:"type:varchar(20);not null;uniqueIndex:idx_plantilla_tipo_destino_idioma"`
+    // Quién la recibe: el paciente, el médico o el médico que deja la cita (reasignación)
+    Destino string `gorm:"type:varchar(20);not null;uniqueIndex:idx_plantilla_tipo_destino_idioma;check(destino IN ('paciente', 'medico', 'medico_anterior'))"`
+    Idioma  string `gorm:"type:varchar(5);not null;uniqueIndex:idx_plantilla_tipo_destino_idioma"`
+    Asunto  string `gorm:"size:200;not null"`
+    Texto   string `gorm:"type:text;not null"`
+    // Variante HTML para correo; si está vacía se genera a partir del texto
+    HTML    string `gorm:"type:text"`
+    ActualizadaEn  time.Time `gorm:"autoUpdateTime"`
+    ActualizadaPor *uint
+}
@@ -0,0 +1,36 @@
+package models
+
+import "time"
+
+// Preferencias de notificación de un usuario
+type PreferenciaNotificacion struct {
+    ID        uint    `gorm:"primaryKey"`
+    UsuarioID uint    `gorm:"uniqueIndex;not null"`
+    Usuario   Usuario `gorm:"foreignKey:UsuarioID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`
+    // Canales en orden de preferencia, separados por coma (ej. "push,whatsapp,correo");
+    // si uno falla se intenta el siguiente
+    Canales   string  `gorm:"size:100;not null;default:'correo'"`
+    // Tipos de notificación que el usuario no quiere recibir, separados por coma
+    TiposDesactivados string `gorm:"size:200;not null;default:''"`
+    // Horas de silencio ("22:00" a "07:00") en la zona horaria del usuario;
+    // lo que caiga dentro se entrega al terminar el periodo
+    SilencioInicio string `gorm:"size:5"`
+    SilencioFin    string `gorm:"size:5"`
+    ZonaHoraria    string `gorm:"size:50"` // Vacía: la de la clínica
+    // El motivo de la cita solo se incluye en la invitación de calendario si el usuario lo autoriza
+    IncluirMotivoCalendario bool `gorm:"not null;default:false"`
+    // Hash del token de baja que usaban los correos anteriores a que cada
+    // notificación llevara el suyo (ver Notificacion.TokenBajaHash)
+    TokenBajaHash  *string `gorm:"size:64;uniqueIndex" json:"-"`
+}
+
+// Suscripción Web Push de un navegador/dispositivo
+type SuscripcionPush struct {
+    ID        uint      `gorm:"primaryKey"`
+    UsuarioID uint      `gorm:"not null;index"`
+    Usuario   Usuario   `gorm:"foreignKey:UsuarioID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`
+    Endpoint  string    `gorm:"type:text;uniqueIndex;not null"`
+    P256dh    string    `gorm:"size:200;not null" json:"-"`
+    Auth      string    `gorm:"size:100;not null" json:"-"`
+    CreadaEn  time.Time `gorm:"autoCreateTime"`
+}
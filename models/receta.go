@@ -0,0 +1,47 @@
+package models
+
+import "time"
+
+// Catálogo de medicamentos para capturar recetas
+type Medicamento struct {
+    ID           uint   `gorm:"primaryKey"`
+    Nombre       string `gorm:"size:200;not null;uniqueIndex:idx_medicamento"` // Nombre genérico
+    Presentacion string `gorm:"size:200;not null;default:'';uniqueIndex:idx_medicamento"` // "Tableta 500 mg"
+    Via          string `gorm:"size:30"` // Vía habitual, se sugiere al recetar
+    Activo       bool   `gorm:"not null;default:true"`
+}
+
+// Receta emitida por el médico de una cita
+type Receta struct {
+    ID            uint         `gorm:"primaryKey"`
+    CitaID        uint         `gorm:"not null;index"`
+    Cita          Cita         `gorm:"foreignKey:CitaID;constraint:OnUpdate:CASCADE,OnDelete:RESTRICT;" json:"-"`
+    ObservacionID *uint        `gorm:"index"`
+    MedicoID      uint         `gorm:"not null;index"`
+    Medico        Medico       `gorm:"foreignKey:MedicoID"`
+    PacienteID    uint         `gorm:"not null;index"` // Cuenta responsable, como en Cita
+    // Código impreso en la receta para que una farmacia la verifique
+    CodigoVerificacion string  `gorm:"size:20;uniqueIndex;not null"`
+    Indicaciones  string       `gorm:"type:text"` // Indicaciones generales
+    Estado        string       `gorm:"type:varchar(20);not null;default:'vigente';check(estado IN ('vigente', 'anulada'))"`
+    EmitidaEn     time.Time    `gorm:"autoCreateTime"`
+    AnuladaEn     *time.Time
+    MotivoAnulacion string     `gorm:"type:text"`
+    Medicamentos  []RecetaMedicamento `gorm:"foreignKey:RecetaID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+}
+
+// Medicamento recetado. Nombre y presentación se copian del catálogo para que
+// la receta no cambie si el catálogo se edita.
+type RecetaMedicamento struct {
+    ID            uint   `gorm:"primaryKey"`
+    RecetaID      uint   `gorm:"not null;index"`
+    MedicamentoID *uint
+    Nombre        string `gorm:"size:200;not null"`
+    Presentacion  string `gorm:"size:200"`
+    Dosis         string `gorm:"size:100;not null"` // "1 tableta"
+    Via           string `gorm:"size:30;not null"`
+    Frecuencia    string `gorm:"size:100;not null"` // "cada 8 horas"
+    DuracionDias  int    `gorm:"not null"`
+    Cantidad      string `gorm:"size:100"` // Cantidad a surtir
+    Indicaciones  string `gorm:"type:text"`
+}
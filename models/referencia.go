@@ -0,0 +1,31 @@
+package models
+
+import "time"
+
+// Referencia de un paciente a otra especialidad o a un médico en particular,
+// emitida por el médico de una cita. El paciente la agenda directamente en la
+// disponibilidad del médico referido.
+type Referencia struct {
+    ID                uint       `gorm:"primaryKey"`
+    CitaOrigenID      uint       `gorm:"not null;index"`
+    CitaOrigen        Cita       `gorm:"foreignKey:CitaOrigenID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`
+    ObservacionID     *uint
+    MedicoOrigenID    uint       `gorm:"not null;index"`
+    MedicoOrigen      Medico     `gorm:"foreignKey:MedicoOrigenID"`
+    PacienteID        uint       `gorm:"not null;index"` // Cuenta responsable, como en Cita
+    PersonaPacienteID *uint      `gorm:"index"`
+    // Destino: la especialidad y, si se eligió, el médico
+    Especialidad      string     `gorm:"size:100;not null"`
+    MedicoDestinoID   *uint      `gorm:"index"`
+    MedicoDestino     *Medico    `gorm:"foreignKey:MedicoDestinoID"`
+    Motivo            string     `gorm:"type:text;not null"` // Motivo clínico
+    Prioridad         string     `gorm:"type:varchar(20);not null;default:'rutina';check(prioridad IN ('rutina', 'preferente', 'urgente'))"`
+    Estado            string     `gorm:"type:varchar(20);not null;default:'pendiente';check(estado IN ('pendiente', 'agendada', 'atendida', 'expirada'));index"`
+    // Cita agendada con el médico referido; si se cancela, la referencia vuelve a pendiente
+    CitaID            *uint      `gorm:"index"`
+    Cita              *Cita      `gorm:"foreignKey:CitaID;constraint:OnUpdate:CASCADE,OnDelete:SET NULL;"`
+    VenceEn           time.Time  `gorm:"not null"` // Sin agendar para entonces, expira
+    CreadaEn          time.Time  `gorm:"autoCreateTime"`
+    AgendadaEn        *time.Time
+    AtendidaEn        *time.Time
+}
@@ -0,0 +1,25 @@
+package models
+
+import "time"
+
+// Código de recuperación de un solo uso para el segundo factor
+type CodigoRecuperacion struct {
+    ID        uint      `gorm:"primaryKey"`
+    UsuarioID uint      `gorm:"not null;index"`
+    Usuario   Usuario   `gorm:"foreignKey:UsuarioID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`
+    Hash      string    `gorm:"size:64;not null" json:"-"`
+    UsadoEn   *time.Time
+    CreadoEn  time.Time `gorm:"autoCreateTime"`
+}
+
+// Desafío pendiente entre la contraseña y el código TOTP en el login
+type DesafioLogin struct {
+    ID        uint      `gorm:"primaryKey"`
+    UsuarioID uint      `gorm:"not null;index"`
+    Usuario   Usuario   `gorm:"foreignKey:UsuarioID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`
+    TokenHash string    `gorm:"size:64;uniqueIndex;not null"`
+    Intentos  int       `gorm:"not null;default:0"`
+    ExpiraEn  time.Time `gorm:"not null"`
+    UsadoEn   *time.Time
+    CreadoEn  time.Time `gorm:"autoCreateTime"`
+}
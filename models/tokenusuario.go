@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// Token de un solo uso enviado por correo para fijar contraseña
+type TokenUsuario struct {
+    ID        uint      `gorm:"primaryKey"`
+    UsuarioID uint      `gorm:"not null;index"`
+    Usuario   Usuario   `gorm:"foreignKey:UsuarioID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`
+    Tipo      string    `gorm:"type:varchar(20);not null;check(tipo IN ('invitacion', 'restablecer'))"`
+    TokenHash string    `gorm:"size:64;uniqueIndex;not null" json:"-"`
+    ExpiraEn  time.Time `gorm:"not null"`
+    UsadoEn   *time.Time
+    CreadoEn  time.Time `gorm:"autoCreateTime"`
+}
@@ -8,8 +8,21 @@ type Usuario struct {
     Persona    Persona   `gorm:"foreignKey:PersonaID"` // Referencia 
     Rol        string    `gorm:"type:varchar(20);not null;check(rol IN ('paciente','medico','administrador'))"`
     Correo     string    `gorm:"size:100;unique;not null"`
-    Contrasena string    `gorm:"size:255;not null"`
+    Contrasena string    `gorm:"size:255;not null" json:"-"` // Hash bcrypt; nunca se serializa
     CreadoEn   time.Time `gorm:"autoCreateTime"`
+    Activo     bool      `gorm:"not null;default:true"`
+    Idioma     string    `gorm:"type:varchar(5);not null;default:'es'"` // Idioma de las notificaciones
+    // Se incrementa para invalidar los JWT emitidos (deshabilitar, cambio de rol, restablecer contraseña)
+    VersionSesion uint   `gorm:"not null;default:0" json:"-"`
+    // Segundo factor (TOTP)
+    TOTPSecreto    string     `gorm:"size:64" json:"-"`
+    TOTPActivo     bool       `gorm:"not null;default:false"`
+    TOTPActivadoEn *time.Time
+    // Último paso TOTP aceptado: un código no se acepta dos veces
+    TOTPUltimoPaso     int64      `gorm:"not null;default:0" json:"-"`
+    // Fallos seguidos del segundo factor y bloqueo temporal al acumular demasiados
+    TOTPFallos         int        `gorm:"not null;default:0" json:"-"`
+    TOTPBloqueadoHasta *time.Time `json:"-"`
     Medico      *Medico       `gorm:"foreignKey:UsuarioID"`
     Cita       []Cita        `gorm:"foreignKey:PacienteID"`
     Notificaciones []Notificacion `gorm:"foreignKey:IDUsuario"`
@@ -0,0 +1,87 @@
+package referencias
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/Ilimm9/CMedicas/eventos"
+	"github.com/Ilimm9/CMedicas/models"
+
+	"gorm.io/gorm"
+)
+
+const (
+	Pendiente = "pendiente"
+	Agendada  = "agendada"
+	Atendida  = "atendida"
+	Expirada  = "expirada"
+)
+
+// Plazo para agendar una referencia según su prioridad
+func Vigencia(prioridad string) time.Duration {
+	switch prioridad {
+	case "urgente":
+		return 7 * 24 * time.Hour
+	case "preferente":
+		return 30 * 24 * time.Hour
+	}
+	return 90 * 24 * time.Hour
+}
+
+// Sigue el estado de las referencias con el de la cita agendada
+func RegistrarManejadores() {
+	eventos.Suscribir(actualizarPorCita, eventos.CitaCompletada, eventos.CitaCancelada)
+}
+
+func actualizarPorCita(tx *gorm.DB, ev eventos.Evento) error {
+	referencias := tx.Model(&models.Referencia{}).Where("cita_id = ? AND estado = ?", ev.Cita.ID, Agendada)
+
+	switch ev.Tipo {
+	case eventos.CitaCompletada:
+		return referencias.Updates(map[string]interface{}{
+			"estado":      Atendida,
+			"atendida_en": time.Now(),
+		}).Error
+
+	case eventos.CitaCancelada:
+		// El paciente puede volver a agendarla mientras no venza
+		return referencias.Updates(map[string]interface{}{
+			"estado":      gorm.Expr("CASE WHEN vence_en > ? THEN ? ELSE ? END", time.Now(), Pendiente, Expirada),
+			"cita_id":     nil,
+			"agendada_en": nil,
+		}).Error
+	}
+	return nil
+}
+
+// Marca como expiradas las referencias pendientes cuyo plazo ya pasó
+func ExpirarVencidas(db *gorm.DB) error {
+	return db.Model(&models.Referencia{}).
+		Where("estado = ? AND vence_en <= ?", Pendiente, time.Now()).
+		Update("estado", Expirada).Error
+}
+
+// Intervalo de la pasada que expira referencias; al agendar se revisa el plazo,
+// así que el retraso solo afecta al estado mostrado
+const intervaloExpiracion = time.Minute
+
+// Inicia la pasada periódica que expira las referencias vencidas
+func IniciarExpiracion(ctx context.Context, db *gorm.DB) {
+	go func() {
+		ticker := time.NewTicker(intervaloExpiracion)
+		defer ticker.Stop()
+
+		for {
+			if err := ExpirarVencidas(db); err != nil {
+				log.Println("Error al expirar referencias:", err)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
@@ -9,22 +9,78 @@ import (
 
 func AdminRutas(r *gin.Engine) {
 
+	// Llaves públicas para que otros servicios validen nuestros JWT
+	r.GET("/.well-known/jwks.json", controllers.GetJWKS)
+
 	public := r.Group("/api")
 	{
 		// Autenticación
 		public.POST("/auth/registro", controllers.RegistroCompleto)
 		public.POST("/auth/login", controllers.Login)
+		public.POST("/auth/2fa/verificar", controllers.VerificarSegundoFactor)
+		public.POST("/auth/2fa/enrolar", controllers.EnrolarSegundoFactorDesafio)
+		public.POST("/auth/contrasena/establecer", controllers.EstablecerContrasena)
+
+		// Baja de notificaciones desde el enlace del correo
+		public.POST("/notificaciones/baja", controllers.BajaNotificaciones)
+
+		// Confirmar o cancelar una cita con el enlace firmado del recordatorio
+		public.GET("/citas/enlace", controllers.GetCitaEnlace)
+		public.POST("/citas/enlace/confirmar", controllers.ConfirmarCitaEnlace)
+		public.POST("/citas/enlace/cancelar", controllers.CancelarCitaEnlace)
+
+		// Suscripción iCalendar de solo lectura (/calendario/<token>.ics)
+		public.GET("/calendario/:archivo", controllers.GetFeedCalendarioICS)
+
+		// Verificación de recetas por farmacias
+		public.GET("/recetas/verificar/:codigo", controllers.VerificarReceta)
 	}
 
 
+	// Eventos en tiempo real (SSE); admite un boleto de corta duración en ?ticket=
+	// porque EventSource no envía cabeceras
+	r.GET("/api/eventos", middlewares.AuthEventos(), controllers.GetEventosTiempoReal)
+
 	// ================== RUTAS PROTEGIDAS (requieren autenticación) ==================
 	protected := r.Group("/api")
 	protected.Use(middlewares.AuthMiddleware())
 	{
+		// Boleto para abrir el flujo de eventos en tiempo real
+		protected.POST("/eventos/ticket", controllers.PostTicketEventos)
+
 		// Perfil de usuario
 		protected.GET("/usuario/actual", controllers.GetCurrentUser)
 		// protected.PUT("/usuario/actual", controllers.UpdateCurrentUser)
 
+		// Segundo factor (TOTP)
+		segundoFactor := protected.Group("/usuario/2fa")
+		{
+			segundoFactor.POST("/enrolar", controllers.EnrolarSegundoFactor)
+			segundoFactor.POST("/activar", controllers.ActivarSegundoFactor)
+			segundoFactor.POST("/desactivar", controllers.DesactivarSegundoFactor)
+			segundoFactor.POST("/codigos-recuperacion", controllers.RegenerarCodigosRecuperacion)
+		}
+
+		// Preferencias de notificación del usuario autenticado
+		preferencias := protected.Group("/usuario/notificaciones")
+		{
+			preferencias.GET("/preferencias", controllers.GetPreferenciasNotificacion)
+			preferencias.PUT("/preferencias", controllers.UpdatePreferenciasNotificacion)
+			preferencias.GET("/push/clave", controllers.GetClavePublicaPush)
+			preferencias.POST("/push", controllers.PostSuscripcionPush)
+			preferencias.DELETE("/push", controllers.DeleteSuscripcionPush)
+		}
+
+		// URLs de suscripción a las citas en calendarios externos
+		feedCalendario := protected.Group("/usuario/calendarios")
+		{
+			feedCalendario.GET("", controllers.GetFeedsCalendario)
+			feedCalendario.POST("", controllers.PostFeedCalendario)
+			feedCalendario.PUT("/:id", controllers.UpdateFeedCalendario)
+			feedCalendario.POST("/:id/regenerar", controllers.RegenerarFeedCalendario)
+			feedCalendario.DELETE("/:id", controllers.DeleteFeedCalendario)
+		}
+
 		// Personas (accesible para usuarios autenticados)
 		persona := protected.Group("/personas")
 		{
@@ -42,6 +98,16 @@ func AdminRutas(r *gin.Engine) {
 			medico.GET("/:id/horarios", controllers.GetHorariosPorMedico)
 		}
 
+		// Dependientes (personas a cargo del usuario: menores, adultos mayores)
+		dependiente := protected.Group("/dependientes")
+		{
+			dependiente.GET("", controllers.GetDependientes)
+			dependiente.POST("", controllers.PostDependiente)
+			dependiente.PUT("/:id", controllers.UpdateDependiente)
+			dependiente.DELETE("/:id", controllers.DeleteDependiente)
+			dependiente.POST("/:id/transferir", controllers.TransferirDependiente)
+		}
+
 		// Citas (accesible para pacientes y médicos)
 		cita := protected.Group("/citas")
 		{
@@ -55,25 +121,109 @@ func AdminRutas(r *gin.Engine) {
 		observacion := protected.Group("/observaciones")
 		{
 			observacion.GET("/cita/:cita_id", controllers.GetObservacionPorCita)
+			observacion.GET("/:id/adendas", controllers.GetAdendasObservacion)
+			observacion.GET("/:id/versiones", controllers.GetVersionesObservacion)
+			observacion.GET("/:id/versiones/diff", controllers.GetDiffVersionesObservacion)
+		}
+
+		// El médico de la cita escribe su observación; pasado el plazo o firmada solo agrega adendas
+		observacionMedico := protected.Group("/medico/observaciones")
+		{
+			observacionMedico.POST("", controllers.PostObservacionMedico)
+			observacionMedico.PUT("/:id", controllers.UpdateObservacionMedico)
+			observacionMedico.POST("/:id/adendas", controllers.PostAdendaObservacion)
+			observacionMedico.POST("/:id/firmar", controllers.FirmarObservacion)
 		}
 
-		// Notificaciones
-		// notificacion := protected.Group("/notificaciones")
-		// {
-		// 	notificacion.GET("", controllers.GetNotificacionesUsuarioActual)
-		// 	notificacion.PUT("/:id/marcar-leida", controllers.MarcarNotificacionLeida)
-		// }
+		// Historial clínico de un paciente (la persona, su tutor, sus médicos o un administrador)
+		paciente := protected.Group("/pacientes/:persona_id")
+		{
+			paciente.GET("/historial", controllers.GetHistorialPaciente)
+
+			// Antecedentes clínicos (editables por los médicos tratantes)
+			paciente.GET("/antecedentes", controllers.GetAntecedentes)
+			paciente.PUT("/tipo-sangre", controllers.UpdateTipoSangre)
+			paciente.POST("/alergias", controllers.PostAlergia)
+			paciente.PUT("/alergias/:id", controllers.UpdateAlergia)
+			paciente.DELETE("/alergias/:id", controllers.DeleteAlergia)
+			paciente.POST("/condiciones", controllers.PostCondicionCronica)
+			paciente.PUT("/condiciones/:id", controllers.UpdateCondicionCronica)
+			paciente.DELETE("/condiciones/:id", controllers.DeleteCondicionCronica)
+			paciente.POST("/medicacion", controllers.PostMedicacionActual)
+			paciente.PUT("/medicacion/:id", controllers.UpdateMedicacionActual)
+			paciente.DELETE("/medicacion/:id", controllers.DeleteMedicacionActual)
+			paciente.POST("/contactos-emergencia", controllers.PostContactoEmergencia)
+			paciente.PUT("/contactos-emergencia/:id", controllers.UpdateContactoEmergencia)
+			paciente.DELETE("/contactos-emergencia/:id", controllers.DeleteContactoEmergencia)
+
+			// Archivos del expediente (laboratorios, imágenes, referencias)
+			paciente.GET("/adjuntos", controllers.GetAdjuntos)
+			paciente.POST("/adjuntos", controllers.PostAdjunto)
+		}
+
+		adjunto := protected.Group("/adjuntos")
+		{
+			adjunto.GET("/:id/archivo", controllers.GetAdjuntoArchivo)
+			adjunto.GET("/:id/miniatura", controllers.GetAdjuntoMiniatura)
+			adjunto.DELETE("/:id", controllers.DeleteAdjunto)
+		}
+
+		// Referencias a otro médico o especialidad (el paciente las agenda)
+		referencia := protected.Group("/referencias")
+		{
+			referencia.GET("", controllers.GetReferencias)
+			referencia.POST("", controllers.PostReferencia)
+			referencia.GET("/:id", controllers.GetReferencia)
+			referencia.GET("/:id/disponibilidad", controllers.GetDisponibilidadReferencia)
+			referencia.POST("/:id/agendar", controllers.AgendarReferencia)
+		}
+
+		// Catálogo CIE-10 (autocompletar diagnósticos)
+		protected.GET("/diagnosticos", controllers.BuscarDiagnosticos)
+
+		// Recetas (el médico de la cita las emite; el paciente ve las suyas)
+		receta := protected.Group("/recetas")
+		{
+			receta.GET("", controllers.GetRecetas)
+			receta.POST("", controllers.PostReceta)
+			receta.GET("/:id", controllers.GetReceta)
+			receta.GET("/:id/pdf", controllers.GetRecetaPDF)
+			receta.PUT("/:id/anular", controllers.AnularReceta)
+		}
+		protected.GET("/medicamentos", controllers.BuscarMedicamentos)
+
+		// Notificaciones (bandeja del usuario autenticado)
+		notificacion := protected.Group("/notificaciones")
+		{
+			notificacion.GET("", controllers.GetNotificacionesUsuarioActual)
+			notificacion.GET("/no-leidas", controllers.GetConteoNoLeidas)
+			notificacion.PUT("/marcar-leidas", controllers.MarcarTodasNotificacionesLeidas)
+			notificacion.PUT("/:id/marcar-leida", controllers.MarcarNotificacionLeida)
+			notificacion.PUT("/:id/archivar", controllers.ArchivarNotificacion)
+		}
 	}
 
 	// ================== RUTAS DE ADMINISTRADOR ==================
 	admin := r.Group("/api/admin")
 	admin.Use(middlewares.AuthMiddleware(), middlewares.AdminOnly())
 	{
+		// Gestión de usuarios
+		admin.GET("/usuarios", controllers.GetAllUsuarios)
+		admin.GET("/usuarios/:id", controllers.GetUsuario)
+		admin.POST("/usuarios", controllers.PostUsuario)
+		admin.PUT("/usuarios/:id", controllers.UpdateUsuario)
+		admin.DELETE("/usuarios/:id", controllers.DeleteUsuario)
+		admin.PUT("/usuarios/:id/rol", controllers.CambiarRolUsuario)
+		admin.PUT("/usuarios/:id/estado", controllers.CambiarEstadoUsuario)
+		admin.POST("/usuarios/:id/restablecer-contrasena", controllers.ForzarRestablecimientoContrasena)
+		admin.DELETE("/usuarios/:id/2fa", controllers.ResetSegundoFactor)
+
 		// Gestión completa de personas
 		admin.DELETE("/personas/:id", controllers.DeletePersona)
 
 		// Gestión completa de médicos
 		admin.POST("/medicos", controllers.PostMedico)
+		admin.POST("/medicos/alta", controllers.AltaMedico)
 		admin.PUT("/medicos/:id", controllers.UpdateMedico)
 		admin.DELETE("/medicos/:id", controllers.DeleteMedico)
 
@@ -86,17 +236,36 @@ func AdminRutas(r *gin.Engine) {
 		admin.PUT("/citas/:id", controllers.UpdateCita)
 		admin.DELETE("/citas/:id", controllers.DeleteCita)
 		admin.GET("/citas/todas", controllers.GetAllCitas)
+		admin.GET("/metricas/confirmaciones", controllers.GetMetricaConfirmaciones)
 
 		// Gestión de observaciones
 		admin.POST("/observaciones", controllers.PostObservacion)
 		admin.PUT("/observaciones/:id", controllers.UpdateObservacion)
-		admin.DELETE("/observaciones/:id", controllers.DeleteObservacion)
+		admin.GET("/observaciones/:id/versiones", controllers.GetVersionesObservacion)
+		admin.PUT("/observaciones/:id/anular", controllers.AnularObservacion)
+
+		// Catálogo de diagnósticos y reportes
+		admin.POST("/diagnosticos/importar", controllers.ImportarCatalogoDiagnosticos)
+		admin.GET("/reportes/diagnosticos", controllers.GetReporteDiagnosticos)
+
+		// Catálogo de medicamentos
+		admin.POST("/medicamentos", controllers.PostMedicamento)
+		admin.PUT("/medicamentos/:id", controllers.UpdateMedicamento)
+		admin.DELETE("/medicamentos/:id", controllers.DeleteMedicamento)
 
 		// Gestión de notificaciones
 		admin.POST("/notificaciones", controllers.PostNotificacion)
 		// admin.GET("/notificaciones/todas", controllers.GetAllNotificaciones)
+		admin.GET("/notificaciones/entrega", controllers.GetNotificacionesPorEstado)
+		admin.POST("/notificaciones/:id/reintentar", controllers.ReintentarNotificacion)
 		admin.DELETE("/notificaciones/:id", controllers.DeleteNotificacion)
 
+		// Plantillas de notificación
+		admin.GET("/plantillas", controllers.GetPlantillas)
+		admin.PUT("/plantillas", controllers.PutPlantilla)
+		admin.DELETE("/plantillas/:id", controllers.DeletePlantilla)
+		admin.POST("/plantillas/vista-previa", controllers.VistaPreviaPlantilla)
+
 	}
 
 }
\ No newline at end of file
@@ -0,0 +1,56 @@
+package tiemporeal
+
+import (
+	"log"
+	"time"
+
+	"github.com/Ilimm9/CMedicas/eventos"
+	"github.com/Ilimm9/CMedicas/initializers"
+	"github.com/Ilimm9/CMedicas/models"
+)
+
+// Cambio de estado de una cita tal como lo recibe el cliente
+type cambioCita struct {
+	Evento    eventos.Tipo `json:"evento"`
+	CitaID    uint         `json:"cita_id"`
+	Estado    string       `json:"estado"`
+	FechaCita time.Time    `json:"fecha_cita"`
+	MedicoID  uint         `json:"medico_id"`
+}
+
+// Publica los cambios de cita confirmados al paciente responsable y a los
+// médicos cuya agenda se ve afectada
+func RegistrarObservadores() {
+	eventos.Observar(publicarCambioCita,
+		eventos.CitaCreada,
+		eventos.CitaReprogramada,
+		eventos.CitaCancelada,
+		eventos.CitaCompletada,
+		eventos.CitaMedicoReasignado,
+		eventos.CitaConfirmada,
+	)
+}
+
+func publicarCambioCita(ev eventos.Evento) {
+	medicos := []uint{ev.Cita.MedicoID}
+	if ev.Anterior != nil && ev.Anterior.MedicoID != ev.Cita.MedicoID {
+		medicos = append(medicos, ev.Anterior.MedicoID)
+	}
+
+	var usuariosMedico []uint
+	if err := initializers.GetDB().Model(&models.Medico{}).Where("id IN ?", medicos).Pluck("usuario_id", &usuariosMedico).Error; err != nil {
+		log.Println("Error al obtener médicos para tiempo real:", err)
+	}
+
+	Publicar(Evento{
+		Tipo: "cita",
+		Datos: cambioCita{
+			Evento:    ev.Tipo,
+			CitaID:    ev.Cita.ID,
+			Estado:    ev.Cita.Estado,
+			FechaCita: ev.Cita.FechaCita,
+			MedicoID:  ev.Cita.MedicoID,
+		},
+		Usuarios: append(usuariosMedico, ev.Cita.PacienteID),
+	})
+}
@@ -0,0 +1,111 @@
+package tiemporeal
+
+import (
+	"sync"
+	"time"
+)
+
+// Mensaje que se empuja a los clientes conectados
+type Evento struct {
+	Tipo     string      `json:"tipo"` // "notificacion", "cita"
+	Datos    interface{} `json:"datos"`
+	Fecha    time.Time   `json:"fecha"`
+	Usuarios []uint      `json:"-"` // Destinatarios; los administradores reciben todo
+}
+
+// Cliente suscrito (tomado del JWT de la conexión)
+type Suscriptor struct {
+	UsuarioID uint
+	Rol       string
+}
+
+func (ev Evento) visiblePara(s Suscriptor) bool {
+	if s.Rol == "administrador" {
+		return true
+	}
+	for _, id := range ev.Usuarios {
+		if id == s.UsuarioID {
+			return true
+		}
+	}
+	return false
+}
+
+// Hub de publicación/suscripción. La implementación en proceso solo llega a los
+// clientes de esta instancia; para varias réplicas se puede sustituir por una
+// respaldada en Postgres LISTEN/NOTIFY con Configurar.
+type Hub interface {
+	Publicar(ev Evento)
+	// Devuelve el canal de eventos del suscriptor y la función para darse de baja
+	Suscribir(s Suscriptor) (<-chan Evento, func())
+}
+
+const tamanoBufer = 32
+
+type suscripcion struct {
+	Suscriptor
+	canal chan Evento
+}
+
+// Hub en memoria del proceso
+type HubLocal struct {
+	mu            sync.RWMutex
+	suscripciones map[*suscripcion]struct{}
+}
+
+func NuevoHubLocal() *HubLocal {
+	return &HubLocal{suscripciones: map[*suscripcion]struct{}{}}
+}
+
+func (h *HubLocal) Publicar(ev Evento) {
+	if ev.Fecha.IsZero() {
+		ev.Fecha = time.Now()
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for s := range h.suscripciones {
+		if !ev.visiblePara(s.Suscriptor) {
+			continue
+		}
+		// Un cliente lento no frena a los demás: si su búfer está lleno, pierde el evento
+		select {
+		case s.canal <- ev:
+		default:
+		}
+	}
+}
+
+func (h *HubLocal) Suscribir(s Suscriptor) (<-chan Evento, func()) {
+	sus := &suscripcion{Suscriptor: s, canal: make(chan Evento, tamanoBufer)}
+
+	h.mu.Lock()
+	h.suscripciones[sus] = struct{}{}
+	h.mu.Unlock()
+
+	var once sync.Once
+	return sus.canal, func() {
+		once.Do(func() {
+			h.mu.Lock()
+			delete(h.suscripciones, sus)
+			h.mu.Unlock()
+			close(sus.canal)
+		})
+	}
+}
+
+var hub Hub = NuevoHubLocal()
+
+// Reemplaza el hub (al arrancar, antes de atender peticiones)
+func Configurar(h Hub) {
+	hub = h
+}
+
+func Publicar(ev Evento) {
+	hub.Publicar(ev)
+}
+
+func Suscribir(s Suscriptor) (<-chan Evento, func()) {
+	return hub.Suscribir(s)
+}